@@ -7,12 +7,20 @@ import (
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigateway"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awscertificatemanager"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsdynamodb"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambdaeventsources"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsroute53"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsroute53targets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssqs"
 	awslambdago "github.com/aws/aws-cdk-go/awscdklambdagoalpha/v2"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/db/storeconfig"
 )
 
 type StackProps struct {
@@ -41,6 +49,61 @@ func NewStack(scope constructs.Construct, id string, props *StackProps) awscdk.S
 	}
 	stack := awscdk.NewStack(scope, &id, &sprops)
 
+	userDB := NewUserTable(stack)
+
+	piiKeys := NewPIIKeys(stack)
+
+	// Used to sign pagination cursors handed out by the API
+	cursorKey := awssecretsmanager.NewSecret(stack, jsii.String("cursorKey"), &awssecretsmanager.SecretProps{
+		GenerateSecretString: &awssecretsmanager.SecretStringGenerator{
+			PasswordLength:     jsii.Number(64),
+			ExcludePunctuation: jsii.Bool(true),
+		},
+	})
+
+	// The API is served either by a Lambda per operation, or by a single Lambda routing every request, which has
+	// fewer cold starts
+	var userApi awsapigateway.LambdaRestApi
+	var writers []awslambdago.GoFunction
+	if os.Getenv("USER_API_DEPLOYMENT") == "router" {
+		userApi, writers = NewRouterApi(stack, userDB, piiKeys, cursorKey)
+	} else {
+		userApi, writers = NewPerFunctionApi(stack, userDB, piiKeys, cursorKey)
+	}
+
+	// Events are published either from the table's stream or from an outbox written alongside each change, but
+	// never both, as every change would then be published twice
+	if os.Getenv("USER_EVENTS_DELIVERY") == "outbox" {
+		NewUserEventsRelay(stack, userDB, piiKeys, writers...)
+	} else {
+		NewUserEventsConsumer(stack, userDB, piiKeys)
+	}
+
+	z := awsroute53.HostedZone_FromLookup(stack, jsii.String("zone"), &awsroute53.HostedZoneProviderProps{
+		DomainName: jsii.String("benjaminkitson.com"),
+	})
+
+	awsroute53.NewARecord(stack, jsii.String("apiRecord"), &awsroute53.ARecordProps{
+		Zone:       z,
+		RecordName: jsii.String("api"),
+		Target:     awsroute53.RecordTarget_FromAlias(awsroute53targets.NewApiGateway(userApi)),
+	})
+
+	// TODO: Eventually ascertain if the below is really needed
+	// awscloudfront.NewDistribution(stack, jsii.String("myDist"), &awscloudfront.DistributionProps{
+	// 	DefaultBehavior: &awscloudfront.BehaviorOptions{
+	// 		Origin: awscloudfrontorigins.NewRestApiOrigin(pokedexApi, &awscloudfrontorigins.RestApiOriginProps{}),
+	// 	},
+	// })
+
+	return stack
+}
+
+/*
+NewPerFunctionApi creates the user API with a Lambda per operation, each integrated with the routes it serves,
+and a fallback Lambda for requests to any other route. The Lambdas that write users are returned.
+*/
+func NewPerFunctionApi(stack awscdk.Stack, table awsdynamodb.Table, piiKeys PIIKeys, cursorKey awssecretsmanager.Secret) (awsapigateway.LambdaRestApi, []awslambdago.GoFunction) {
 	fallbackLambdaProps := NewDefaultLambdaProps("../lambda/fallback")
 	fallbackLambda := awslambdago.NewGoFunction(stack, jsii.String("fallbackHandler"), fallbackLambdaProps)
 
@@ -50,75 +113,150 @@ func NewStack(scope constructs.Construct, id string, props *StackProps) awscdk.S
 	deleteUserLambdaProps := NewDefaultLambdaProps("../lambda/user/delete")
 	deleteUserLambda := awslambdago.NewGoFunction(stack, jsii.String("deleteUserHandler"), deleteUserLambdaProps)
 
-	userDB := awsdynamodb.NewTable(stack, jsii.String("userTable"), &awsdynamodb.TableProps{
-		PartitionKey: &awsdynamodb.Attribute{
-			Name: jsii.String("_pk"),
-			Type: awsdynamodb.AttributeType_STRING,
-		},
-		// SortKey: &awsdynamodb.Attribute{
-		// 	Name: jsii.String("_sk"),
-		// 	Type: awsdynamodb.AttributeType_STRING,
-		// },
-		TableName:   jsii.String("userTable"),
-		BillingMode: awsdynamodb.BillingMode_PAY_PER_REQUEST,
-	})
+	getUserLambdaProps := NewDefaultLambdaProps("../lambda/user/get")
+	getUserLambda := awslambdago.NewGoFunction(stack, jsii.String("getUserHandler"), getUserLambdaProps)
 
-	userDB.AddGlobalSecondaryIndex(&awsdynamodb.GlobalSecondaryIndexProps{
-		IndexName: jsii.String("gsi1"),
-		PartitionKey: &awsdynamodb.Attribute{
-			Name: jsii.String("_gsi1"),
-			Type: awsdynamodb.AttributeType_STRING,
-		},
+	updateUserLambdaProps := NewDefaultLambdaProps("../lambda/user/update")
+	updateUserLambda := awslambdago.NewGoFunction(stack, jsii.String("updateUserHandler"), updateUserLambdaProps)
+
+	restoreUserLambdaProps := NewDefaultLambdaProps("../lambda/user/restore")
+	restoreUserLambda := awslambdago.NewGoFunction(stack, jsii.String("restoreUserHandler"), restoreUserLambdaProps)
+
+	listUserLambdaProps := NewDefaultLambdaProps("../lambda/user/list")
+	listUserLambda := awslambdago.NewGoFunction(stack, jsii.String("listUserHandler"), listUserLambdaProps)
+
+	userHistoryLambdaProps := NewDefaultLambdaProps("../lambda/user/history")
+	userHistoryLambda := awslambdago.NewGoFunction(stack, jsii.String("userHistoryHandler"), userHistoryLambdaProps)
+
+	cursorKey.GrantRead(listUserLambda, nil)
+	listUserLambda.AddEnvironment(jsii.String("CURSOR_KEY_SECRET_NAME"), cursorKey.SecretName(), nil)
+	cursorKey.GrantRead(userHistoryLambda, nil)
+	userHistoryLambda.AddEnvironment(jsii.String("CURSOR_KEY_SECRET_NAME"), cursorKey.SecretName(), nil)
+
+	for _, fn := range []awslambdago.GoFunction{createUserLambda, deleteUserLambda, getUserLambda, updateUserLambda, restoreUserLambda, listUserLambda, userHistoryLambda} {
+		piiKeys.Grant(fn)
+	}
+
+	table.GrantReadWriteData(createUserLambda)
+	table.GrantReadWriteData(deleteUserLambda)
+	table.GrantReadWriteData(updateUserLambda)
+	table.GrantReadData(getUserLambda)
+	table.GrantReadWriteData(restoreUserLambda)
+	table.GrantReadData(listUserLambda)
+	table.GrantReadData(userHistoryLambda)
+
+	userApi := NewUserApi(stack, fallbackLambda, false)
+
+	users := userApi.Root().AddResource(jsii.String("user"), &awsapigateway.ResourceOptions{})
+	users.AddMethod(jsii.String("GET"), awsapigateway.NewLambdaIntegration(listUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
+	})
+	users.AddMethod(jsii.String("POST"), awsapigateway.NewLambdaIntegration(createUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
 	})
 
-	userDB.GrantReadWriteData(createUserLambda)
-	userDB.GrantReadWriteData(deleteUserLambda)
+	user := users.AddResource(jsii.String("{id}"), &awsapigateway.ResourceOptions{})
+	user.AddMethod(jsii.String("GET"), awsapigateway.NewLambdaIntegration(getUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
+	})
+	user.AddMethod(jsii.String("PATCH"), awsapigateway.NewLambdaIntegration(updateUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
+	})
+	user.AddMethod(jsii.String("DELETE"), awsapigateway.NewLambdaIntegration(deleteUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
+	})
 
-	userApi := awsapigateway.NewLambdaRestApi(stack, jsii.String("Endpoint"), &awsapigateway.LambdaRestApiProps{
-		DomainName: &awsapigateway.DomainNameOptions{
-			DomainName: jsii.String("api.benjaminkitson.com"),
-			Certificate: awscertificatemanager.Certificate_FromCertificateArn(
-				stack,
-				jsii.String("benjaminkitson-certificate"),
-				jsii.String("arn:aws:acm:eu-west-2:905418429454:certificate/42197bf4-d86d-404a-87a6-748c4858d916"),
-			),
-		},
-		DisableExecuteApiEndpoint: jsii.Bool(true),
-		RestApiName:               jsii.String("bk-api"),
-		Handler:                   fallbackLambda,
-		Proxy:                     jsii.Bool(false),
+	userRestore := user.AddResource(jsii.String("restore"), &awsapigateway.ResourceOptions{})
+	userRestore.AddMethod(jsii.String("POST"), awsapigateway.NewLambdaIntegration(restoreUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
 	})
 
-	users := userApi.Root().AddResource(jsii.String("user"), &awsapigateway.ResourceOptions{})
+	userHistory := user.AddResource(jsii.String("history"), &awsapigateway.ResourceOptions{})
+	userHistory.AddMethod(jsii.String("GET"), awsapigateway.NewLambdaIntegration(userHistoryLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
+	})
 
+	// The RPC-style routes the above replaced, which respond with a Deprecation header until they're removed.
+	// API Gateway prefers these static resources to {id}, so they can't be mistaken for user ids.
 	createUser := users.AddResource(jsii.String("create"), &awsapigateway.ResourceOptions{})
 	createUser.AddMethod(jsii.String("POST"), awsapigateway.NewLambdaIntegration(createUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
 		AuthorizationType: awsapigateway.AuthorizationType_IAM,
 	})
 
+	getUser := users.AddResource(jsii.String("get"), &awsapigateway.ResourceOptions{})
+	getUser.AddMethod(jsii.String("POST"), awsapigateway.NewLambdaIntegration(getUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
+	})
+
 	deleteUser := users.AddResource(jsii.String("delete"), &awsapigateway.ResourceOptions{})
 	deleteUser.AddMethod(jsii.String("POST"), awsapigateway.NewLambdaIntegration(deleteUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
 		AuthorizationType: awsapigateway.AuthorizationType_IAM,
 	})
 
-	z := awsroute53.HostedZone_FromLookup(stack, jsii.String("zone"), &awsroute53.HostedZoneProviderProps{
-		DomainName: jsii.String("benjaminkitson.com"),
+	updateUser := users.AddResource(jsii.String("update"), &awsapigateway.ResourceOptions{})
+	updateUser.AddMethod(jsii.String("PATCH"), awsapigateway.NewLambdaIntegration(updateUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
 	})
 
-	awsroute53.NewARecord(stack, jsii.String("apiRecord"), &awsroute53.ARecordProps{
-		Zone:       z,
-		RecordName: jsii.String("api"),
-		Target:     awsroute53.RecordTarget_FromAlias(awsroute53targets.NewApiGateway(userApi)),
+	restoreUser := users.AddResource(jsii.String("restore"), &awsapigateway.ResourceOptions{})
+	restoreUser.AddMethod(jsii.String("POST"), awsapigateway.NewLambdaIntegration(restoreUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
 	})
 
-	// TODO: Eventually ascertain if the below is really needed
-	// awscloudfront.NewDistribution(stack, jsii.String("myDist"), &awscloudfront.DistributionProps{
-	// 	DefaultBehavior: &awscloudfront.BehaviorOptions{
-	// 		Origin: awscloudfrontorigins.NewRestApiOrigin(pokedexApi, &awscloudfrontorigins.RestApiOriginProps{}),
-	// 	},
-	// })
+	// Batches are sent in the request body, so stay on the routes under create and get
+	createUserBatch := createUser.AddResource(jsii.String("batch"), &awsapigateway.ResourceOptions{})
+	createUserBatch.AddMethod(jsii.String("POST"), awsapigateway.NewLambdaIntegration(createUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
+	})
 
-	return stack
+	getUserBatch := getUser.AddResource(jsii.String("batch"), &awsapigateway.ResourceOptions{})
+	getUserBatch.AddMethod(jsii.String("POST"), awsapigateway.NewLambdaIntegration(getUserLambda, &awsapigateway.LambdaIntegrationOptions{}), &awsapigateway.MethodOptions{
+		AuthorizationType: awsapigateway.AuthorizationType_IAM,
+	})
+
+	return userApi, []awslambdago.GoFunction{createUserLambda, deleteUserLambda, updateUserLambda, restoreUserLambda}
+}
+
+/*
+NewRouterApi creates the user API with a single Lambda serving every route, which API Gateway proxies every
+request to. The Lambda routes requests to the same handlers as the per-function Lambdas run, and is returned as
+the only Lambda that writes users.
+*/
+func NewRouterApi(stack awscdk.Stack, table awsdynamodb.Table, piiKeys PIIKeys, cursorKey awssecretsmanager.Secret) (awsapigateway.LambdaRestApi, []awslambdago.GoFunction) {
+	routerLambdaProps := NewDefaultLambdaProps("../lambda/router")
+	routerLambda := awslambdago.NewGoFunction(stack, jsii.String("userRouterHandler"), routerLambdaProps)
+
+	cursorKey.GrantRead(routerLambda, nil)
+	routerLambda.AddEnvironment(jsii.String("CURSOR_KEY_SECRET_NAME"), cursorKey.SecretName(), nil)
+	piiKeys.Grant(routerLambda)
+	table.GrantReadWriteData(routerLambda)
+
+	return NewUserApi(stack, routerLambda, true), []awslambdago.GoFunction{routerLambda}
+}
+
+/*
+NewUserApi creates the REST API, served from the API's custom domain. With proxy set, every request is sent to
+the handler, and otherwise only requests to routes without integrations of their own are. Every method requires
+IAM authorization.
+*/
+func NewUserApi(stack awscdk.Stack, handler awslambdago.GoFunction, proxy bool) awsapigateway.LambdaRestApi {
+	return awsapigateway.NewLambdaRestApi(stack, jsii.String("Endpoint"), &awsapigateway.LambdaRestApiProps{
+		DomainName: &awsapigateway.DomainNameOptions{
+			DomainName: jsii.String("api.benjaminkitson.com"),
+			Certificate: awscertificatemanager.Certificate_FromCertificateArn(
+				stack,
+				jsii.String("benjaminkitson-certificate"),
+				jsii.String("arn:aws:acm:eu-west-2:905418429454:certificate/42197bf4-d86d-404a-87a6-748c4858d916"),
+			),
+		},
+		DisableExecuteApiEndpoint: jsii.Bool(true),
+		RestApiName:               jsii.String("bk-api"),
+		Handler:                   handler,
+		Proxy:                     jsii.Bool(proxy),
+		DefaultMethodOptions: &awsapigateway.MethodOptions{
+			AuthorizationType: awsapigateway.AuthorizationType_IAM,
+		},
+	})
 }
 
 func main() {
@@ -161,3 +299,139 @@ func env() *awscdk.Environment {
 		Region:  jsii.String(os.Getenv("CDK_DEFAULT_REGION")),
 	}
 }
+
+/*
+NewUserEventsConsumer publishes user lifecycle events to the default event bus from the user table's stream.
+Batches are retried from the first record that fails, and records that still can't be published are sent to a
+dead letter queue rather than holding up the rest of the stream.
+*/
+func NewUserEventsConsumer(stack awscdk.Stack, table awsdynamodb.Table, piiKeys PIIKeys) {
+	userEventsLambdaProps := NewDefaultLambdaProps("../lambda/user/events")
+	userEventsLambda := awslambdago.NewGoFunction(stack, jsii.String("userEventsHandler"), userEventsLambdaProps)
+	// Users are encrypted in the stream's images just as they are in the table
+	piiKeys.Grant(userEventsLambda)
+
+	bus := awsevents.EventBus_FromEventBusName(stack, jsii.String("defaultEventBus"), jsii.String("default"))
+	bus.GrantPutEventsTo(userEventsLambda)
+	userEventsLambda.AddEnvironment(jsii.String("EVENT_BUS_NAME"), bus.EventBusName(), nil)
+
+	dlq := awssqs.NewQueue(stack, jsii.String("userEventsDLQ"), &awssqs.QueueProps{
+		RetentionPeriod: awscdk.Duration_Days(jsii.Number(14)),
+	})
+
+	userEventsLambda.AddEventSource(awslambdaeventsources.NewDynamoEventSource(table, &awslambdaeventsources.DynamoEventSourceProps{
+		StartingPosition:        awslambda.StartingPosition_TRIM_HORIZON,
+		BatchSize:               jsii.Number(100),
+		ReportBatchItemFailures: jsii.Bool(true),
+		RetryAttempts:           jsii.Number(10),
+		OnFailure:               awslambdaeventsources.NewSqsDlq(dlq),
+	}))
+}
+
+/*
+NewUserEventsRelay publishes user lifecycle events to the default event bus from the user table's outbox, which
+the stores given by writers fill in the same transaction as each write. The relay runs every minute, and events
+that can't be published are retried with backoff by later runs.
+*/
+func NewUserEventsRelay(stack awscdk.Stack, table awsdynamodb.Table, piiKeys PIIKeys, writers ...awslambdago.GoFunction) {
+	for _, writer := range writers {
+		writer.AddEnvironment(jsii.String(storeconfig.OutboxEnv), jsii.String("true"), nil)
+	}
+
+	userEventsRelayLambdaProps := NewDefaultLambdaProps("../lambda/user/outbox")
+	userEventsRelayLambdaProps.Timeout = awscdk.Duration_Minutes(jsii.Number(1))
+	userEventsRelayLambda := awslambdago.NewGoFunction(stack, jsii.String("userEventsRelayHandler"), userEventsRelayLambdaProps)
+	table.GrantReadWriteData(userEventsRelayLambda)
+	piiKeys.Grant(userEventsRelayLambda)
+
+	bus := awsevents.EventBus_FromEventBusName(stack, jsii.String("defaultEventBus"), jsii.String("default"))
+	bus.GrantPutEventsTo(userEventsRelayLambda)
+	userEventsRelayLambda.AddEnvironment(jsii.String("EVENT_BUS_NAME"), bus.EventBusName(), nil)
+
+	awsevents.NewRule(stack, jsii.String("userEventsRelaySchedule"), &awsevents.RuleProps{
+		Schedule: awsevents.Schedule_Rate(awscdk.Duration_Minutes(jsii.Number(1))),
+		Targets: &[]awsevents.IRuleTarget{
+			awseventstargets.NewLambdaFunction(userEventsRelayLambda, &awseventstargets.LambdaFunctionProps{}),
+		},
+	})
+}
+
+// PIIKeys are the keys used to encrypt users' personal data, and to key their emails by a blind index
+type PIIKeys struct {
+	Key      awskms.Key
+	IndexKey awssecretsmanager.Secret
+}
+
+func NewPIIKeys(stack awscdk.Stack) PIIKeys {
+	return PIIKeys{
+		Key: awskms.NewKey(stack, jsii.String("piiKey"), &awskms.KeyProps{
+			Description:       jsii.String("Encrypts the data keys protecting users' personal data"),
+			EnableKeyRotation: jsii.Bool(true),
+			// Everything encrypted under the key would be lost along with it
+			RemovalPolicy: awscdk.RemovalPolicy_RETAIN,
+		}),
+		// Changing the index key means rewriting every user's email keys, so it isn't rotated
+		IndexKey: awssecretsmanager.NewSecret(stack, jsii.String("piiIndexKey"), &awssecretsmanager.SecretProps{
+			GenerateSecretString: &awssecretsmanager.SecretStringGenerator{
+				PasswordLength:     jsii.Number(64),
+				ExcludePunctuation: jsii.Bool(true),
+			},
+			RemovalPolicy: awscdk.RemovalPolicy_RETAIN,
+		}),
+	}
+}
+
+// Grant lets the function encrypt and decrypt personal data, and configures its store to
+func (k PIIKeys) Grant(fn awslambdago.GoFunction) {
+	k.Key.GrantEncryptDecrypt(fn)
+	k.IndexKey.GrantRead(fn, nil)
+	fn.AddEnvironment(jsii.String(storeconfig.PIIKeyARNEnv), k.Key.KeyArn(), nil)
+	fn.AddEnvironment(jsii.String(storeconfig.PIIIndexKeySecretEnv), k.IndexKey.SecretName(), nil)
+}
+
+// NewUserTable creates the user table from the layout declared in the schema package
+func NewUserTable(stack awscdk.Stack) awsdynamodb.Table {
+	t := schema.UserTable
+
+	props := &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String(t.PartitionKey),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName:   jsii.String(schema.TableName),
+		BillingMode: awsdynamodb.BillingMode_PAY_PER_REQUEST,
+		// Purges soft-deleted users once their restore window has passed
+		TimeToLiveAttribute: jsii.String(t.TTLAttribute),
+		// Consumed by the user events lambda, which needs both images to tell what changed
+		Stream: awsdynamodb.StreamViewType_NEW_AND_OLD_IMAGES,
+		// Keeps the table's users if it is ever replaced, as it was when the sort key was added, so that
+		// migrate-table can copy them across
+		RemovalPolicy: awscdk.RemovalPolicy_RETAIN,
+	}
+	if t.SortKey != "" {
+		props.SortKey = &awsdynamodb.Attribute{
+			Name: jsii.String(t.SortKey),
+			Type: awsdynamodb.AttributeType_STRING,
+		}
+	}
+	table := awsdynamodb.NewTable(stack, jsii.String("userTable"), props)
+
+	for _, index := range t.Indexes {
+		indexProps := &awsdynamodb.GlobalSecondaryIndexProps{
+			IndexName: jsii.String(index.Name),
+			PartitionKey: &awsdynamodb.Attribute{
+				Name: jsii.String(index.PartitionKey),
+				Type: awsdynamodb.AttributeType_STRING,
+			},
+		}
+		if index.SortKey != "" {
+			indexProps.SortKey = &awsdynamodb.Attribute{
+				Name: jsii.String(index.SortKey),
+				Type: awsdynamodb.AttributeType_STRING,
+			}
+		}
+		table.AddGlobalSecondaryIndex(indexProps)
+	}
+
+	return table
+}
@@ -0,0 +1,66 @@
+/*
+backfill-created-index adds users written before the creation index was added to it, so that they can be listed
+by creation date. Deleted users are left out of the index, as they are by the store. Run it with -dry-run first
+to see how many users it would index.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+)
+
+func main() {
+	tableName := flag.String("table", schema.TableName, "the user table to backfill")
+	endpoint := flag.String("endpoint", "", "the DynamoDB endpoint, for running against DynamoDB Local")
+	dryRun := flag.Bool("dry-run", false, "report how many users would be indexed without writing anything")
+	rate := flag.Float64("rate", 10, "the maximum number of users to index per second, or 0 for no limit")
+	flag.Parse()
+
+	err := run(context.Background(), *tableName, *endpoint, *dryRun, *rate)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, tableName string, endpoint string, dryRun bool, rate float64) error {
+	sdkConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialise SDK config: %w", err)
+	}
+
+	client := dynamodb.NewFromConfig(sdkConfig, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = &endpoint
+		}
+	})
+	store := userstore.NewUserStore(client, tableName)
+
+	report, err := store.BackfillCreatedIndex(ctx, userstore.CreatedIndexOptions{
+		DryRun:          dryRun,
+		WritesPerSecond: rate,
+	})
+	if err != nil {
+		return fmt.Errorf("backfill failed after scanning %d users: %w", report.Scanned, err)
+	}
+
+	for id, err := range report.Failed {
+		fmt.Printf("Failed to index %s: %v\n", id, err)
+	}
+
+	fmt.Printf("Scanned %d users, %d indexed, %d missing from the index, %d failures\n",
+		report.Scanned, report.Indexed, report.Unindexed, len(report.Failed))
+
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d users could not be indexed", len(report.Failed))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+/*
+create-table creates the user table, as declared in the schema package, for local development against DynamoDB
+Local. Deployed tables are created by the CDK stack from the same declaration.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+)
+
+func main() {
+	tableName := flag.String("table", schema.TableName, "the name of the table to create")
+	endpoint := flag.String("endpoint", "http://localhost:8000", "the DynamoDB endpoint, or empty for AWS")
+	flag.Parse()
+
+	ctx := context.Background()
+	sdkConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialise SDK config: %v\n", err)
+		os.Exit(1)
+	}
+
+	client := dynamodb.NewFromConfig(sdkConfig, func(o *dynamodb.Options) {
+		if *endpoint != "" {
+			o.BaseEndpoint = endpoint
+		}
+	})
+
+	err = schema.UserTable.Create(ctx, client, *tableName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Created table %s\n", *tableName)
+}
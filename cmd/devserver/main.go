@@ -0,0 +1,138 @@
+/*
+devserver serves the user API over HTTP on the local machine, for developing against the API without deploying
+it. Requests are adapted into the API Gateway events the lambdas receive and routed to the same handlers the
+lambdas run, with requests to paths the API doesn't have going to the fallback handler as they do once deployed.
+Users are kept in memory by default, so they're lost when the server stops. Run with -backend dynamodb to keep
+them in DynamoDB Local instead, after starting it with dynamo.sh and creating the table with create-table. The
+store is otherwise configured through the same environment variables as the lambdas, such as PII_KEY_FILE to
+encrypt personal data, and USER_CACHE_TTL to cache user lookups. Callers are assigned the tenant their
+X-Tenant-ID header names, as a tenant-aware authorizer would assign one once deployed.
+*/
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/db/storeconfig"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/cache"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/memstore"
+	"go.uber.org/zap"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "the address to serve the API on")
+	backend := flag.String("backend", "memory", "the user store backend: memory, dynamodb, sqlite or postgres")
+	endpoint := flag.String("endpoint", "http://localhost:8000", "the DynamoDB endpoint, or empty for AWS")
+	tableName := flag.String("table", schema.TableName, "the user table, for the dynamodb backend")
+	flag.Parse()
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialise logger: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = run(ctx, logger, *addr, *backend, *endpoint, *tableName)
+	if err != nil {
+		logger.Error("devserver failed", zap.Error(err))
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, logger *zap.Logger, addr string, backend string, endpoint string, tableName string) error {
+	store, err := openStore(ctx, backend, endpoint, tableName)
+	if err != nil {
+		return err
+	}
+
+	s, err := newServer(logger, store)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("serving the user API", zap.String("addr", addr), zap.String("backend", backend))
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func openStore(ctx context.Context, backend string, endpoint string, tableName string) (userstore.Store, error) {
+	// Cursors are signed with a key of the server's own, as they only need to outlive a page or two of browsing
+	cursorKey := make([]byte, 32)
+	_, err := rand.Read(cursorKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate cursor key: %w", err)
+	}
+
+	store, err := openBackend(ctx, backend, endpoint, tableName, cursorKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every request goes through the same store, so the cache sees every write, as it does in the router Lambda
+	userCache, err := cache.NewFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if userCache != nil {
+		store = userCache.Wrap(store)
+	}
+	return store, nil
+}
+
+func openBackend(ctx context.Context, backend string, endpoint string, tableName string, cursorKey []byte) (userstore.Store, error) {
+	if backend == "memory" {
+		return memstore.NewStore(userstore.WithCursorKey(cursorKey)), nil
+	}
+
+	sdkConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise SDK config: %w", err)
+	}
+
+	c := storeconfig.FromEnv()
+	c.Backend = backend
+	if backend != "dynamodb" {
+		return storeconfig.Open(ctx, c, sdkConfig, userstore.WithCursorKey(cursorKey))
+	}
+
+	opts, err := storeconfig.StoreOptions(ctx, c, sdkConfig)
+	if err != nil {
+		return nil, err
+	}
+	client := dynamodb.NewFromConfig(sdkConfig, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = &endpoint
+		}
+	})
+	return userstore.NewUserStore(client, tableName, append(opts, userstore.WithCursorKey(cursorKey))...), nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	fallbackhandler "github.com/benjaminkitson/bk-user-api/lambda/fallback/handler"
+	routerhandler "github.com/benjaminkitson/bk-user-api/lambda/router/handler"
+	"github.com/benjaminkitson/bk-user-api/router"
+	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// server serves the user API over HTTP, passing each request to the handler of its route as API Gateway would
+type server struct {
+	logger *zap.Logger
+	router *router.Router
+}
+
+func newServer(logger *zap.Logger, u userstore.Store) (server, error) {
+	r, err := routerhandler.NewRouter(logger, u)
+	if err != nil {
+		return server{}, err
+	}
+
+	fallback, err := fallbackhandler.NewHandler(logger)
+	if err != nil {
+		return server{}, err
+	}
+	r.NotFound(fallback.Handle)
+
+	return server{
+		logger: logger,
+		router: r,
+	}, nil
+}
+
+func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	request, err := proxyRequest(r, uuid.New().String())
+	if err != nil {
+		s.logger.Error("failed to read request", zap.Error(err))
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+	s.logger.Debug("request",
+		zap.String("requestID", request.RequestContext.RequestID),
+		zap.Any("headers", request.Headers),
+		zap.String("body", request.Body),
+	)
+
+	response, err := s.router.Route(r.Context(), request)
+	if err != nil {
+		// API Gateway responds to errors returned by a Lambda with a 502
+		s.logger.Error("handler returned an error", zap.String("requestID", request.RequestContext.RequestID), zap.Error(err))
+		response = events.APIGatewayProxyResponse{
+			StatusCode: http.StatusBadGateway,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"message": "Internal server error"}`,
+		}
+	}
+
+	err = writeResponse(w, response)
+	if err != nil {
+		s.logger.Error("failed to write response", zap.String("requestID", request.RequestContext.RequestID), zap.Error(err))
+	}
+
+	s.logger.Debug("response",
+		zap.String("requestID", request.RequestContext.RequestID),
+		zap.Any("headers", response.Headers),
+		zap.String("body", response.Body),
+	)
+	s.logger.Info("served request",
+		zap.String("requestID", request.RequestContext.RequestID),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.RequestURI()),
+		zap.Int("status", response.StatusCode),
+		zap.Duration("duration", time.Since(start)),
+	)
+}
+
+/*
+proxyRequest adapts an HTTP request into the event API Gateway would send a Lambda for it. Where a header or
+query parameter is given more than once, the single-valued maps hold its last value as API Gateway's do, and the
+multi-valued maps hold all of them. The path is left escaped, as API Gateway leaves it, so that the router can
+tell an escaped slash in a path parameter from one separating segments. There's no authorizer locally, so the
+server stands in for a tenant-aware one, assigning callers the tenant their X-Tenant-ID header names.
+*/
+func proxyRequest(r *http.Request, requestID string) (events.APIGatewayProxyRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return events.APIGatewayProxyRequest{}, err
+	}
+
+	header := r.Header.Clone()
+	header.Set("Host", r.Host)
+
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+
+	var authorizer map[string]interface{}
+	if id := header.Get(utils.TenantHeader); id != "" {
+		authorizer = map[string]interface{}{utils.TenantAuthorizerKey: id}
+	}
+
+	return events.APIGatewayProxyRequest{
+		HTTPMethod:                      r.Method,
+		Path:                            r.URL.EscapedPath(),
+		Headers:                         lastValues(header),
+		MultiValueHeaders:               header,
+		QueryStringParameters:           lastValues(r.URL.Query()),
+		MultiValueQueryStringParameters: r.URL.Query(),
+		Body:                            string(body),
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID:  requestID,
+			Stage:      "local",
+			HTTPMethod: r.Method,
+			Path:       r.URL.EscapedPath(),
+			Authorizer: authorizer,
+			Identity: events.APIGatewayRequestIdentity{
+				SourceIP:  sourceIP,
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}, nil
+}
+
+/*
+writeResponse writes the response a handler returned as API Gateway would. A body that claims to be base64
+encoded but isn't is responded to with a 502, as API Gateway does.
+*/
+func writeResponse(w http.ResponseWriter, response events.APIGatewayProxyResponse) error {
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			http.Error(w, "invalid base64 response body", http.StatusBadGateway)
+			return err
+		}
+	}
+
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range response.MultiValueHeaders {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
+	w.WriteHeader(response.StatusCode)
+	_, err := w.Write(body)
+	return err
+}
+
+// lastValues returns the last value given for each key, or nil if there are none
+func lastValues(values map[string][]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	last := make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) > 0 {
+			last[k] = v[len(v)-1]
+		}
+	}
+	return last
+}
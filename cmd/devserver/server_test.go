@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/cache"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/memstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	s, err := newServer(zap.NewNop(), memstore.NewStore(userstore.WithCursorKey([]byte("test-cursor-key"))))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func do(t *testing.T, method string, url string, body string, headers map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(b)
+}
+
+func TestServer(t *testing.T) {
+	srv := newTestServer(t)
+
+	res, body := do(t, "POST", srv.URL+"/user", `{"email": "abc@gmail.com"}`, nil)
+	require.Equal(t, 200, res.StatusCode)
+	var user models.User
+	require.NoError(t, json.Unmarshal([]byte(body), &user))
+
+	res, _ = do(t, "GET", srv.URL+"/user/"+user.UserID, "", nil)
+	assert.Equal(t, 200, res.StatusCode)
+	etag := res.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	res, _ = do(t, "PATCH", srv.URL+"/user/"+user.UserID, `{"email": "def@gmail.com"}`, map[string]string{"If-Match": etag})
+	assert.Equal(t, 200, res.StatusCode)
+
+	// The If-Match header reaches the handler, so a stale version is rejected
+	res, _ = do(t, "PATCH", srv.URL+"/user/"+user.UserID, `{"email": "ghi@gmail.com"}`, map[string]string{"If-Match": etag})
+	assert.Equal(t, 412, res.StatusCode)
+
+	// As do query parameters
+	res, _ = do(t, "GET", srv.URL+"/user?limit=0", "", nil)
+	assert.Equal(t, 400, res.StatusCode)
+
+	// Users are kept apart by the tenant header
+	res, _ = do(t, "GET", srv.URL+"/user/"+user.UserID, "", map[string]string{"X-Tenant-ID": "acme"})
+	assert.Equal(t, 404, res.StatusCode)
+}
+
+func TestServerCacheSeesWrites(t *testing.T) {
+	t.Setenv(cache.TTLEnv, "1h")
+	store, err := openStore(context.Background(), "memory", "", "")
+	require.NoError(t, err)
+	s, err := newServer(zap.NewNop(), store)
+	require.NoError(t, err)
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	res, body := do(t, "POST", srv.URL+"/user", `{"email": "abc@gmail.com"}`, nil)
+	require.Equal(t, 200, res.StatusCode)
+	var user models.User
+	require.NoError(t, json.Unmarshal([]byte(body), &user))
+
+	res, _ = do(t, "GET", srv.URL+"/user/"+user.UserID, "", nil)
+	assert.Equal(t, 200, res.StatusCode)
+
+	// The cached user is invalidated by the delete, rather than served until it expires
+	res, _ = do(t, "DELETE", srv.URL+"/user/"+user.UserID, "", nil)
+	assert.Equal(t, 200, res.StatusCode)
+	res, _ = do(t, "GET", srv.URL+"/user/"+user.UserID, "", nil)
+	assert.Equal(t, 404, res.StatusCode)
+}
+
+func TestServerUnknownRoutes(t *testing.T) {
+	srv := newTestServer(t)
+
+	// Paths the API doesn't have go to the fallback handler
+	res, body := do(t, "GET", srv.URL+"/users", "", nil)
+	assert.Equal(t, 400, res.StatusCode)
+	assert.Equal(t, models.ProblemContentType, res.Header.Get("Content-Type"))
+	var problem models.Problem
+	require.NoError(t, json.Unmarshal([]byte(body), &problem))
+	assert.Equal(t, models.ProblemInvalidPath, problem.Type)
+	assert.NotEmpty(t, problem.Instance)
+
+	res, _ = do(t, "PUT", srv.URL+"/user/12345", "", nil)
+	assert.Equal(t, 405, res.StatusCode)
+	assert.Equal(t, "DELETE, GET, OPTIONS, PATCH", res.Header.Get("Allow"))
+}
+
+func TestServerPreflight(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, path := range []string{"/user", "/user/12345", "/user/12345/history"} {
+		res, body := do(t, "OPTIONS", srv.URL+path, "", map[string]string{
+			"Origin":                         "http://localhost:3000",
+			"Access-Control-Request-Method":  "PATCH",
+			"Access-Control-Request-Headers": "If-Match",
+		})
+		assert.Equal(t, 204, res.StatusCode, path)
+		assert.Empty(t, body)
+		assert.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, res.Header.Get("Access-Control-Allow-Methods"), "PATCH")
+		assert.Contains(t, res.Header.Get("Access-Control-Allow-Headers"), "If-Match")
+	}
+}
+
+func TestServerEscapedPath(t *testing.T) {
+	srv := newTestServer(t)
+
+	// An escaped slash stays in the id rather than splitting it across segments, so it reaches the get handler
+	// for an id that doesn't exist instead of matching the history route or none at all
+	for _, path := range []string{"/user/a%2Fhistory", "/user/a%2Fb%2Fc"} {
+		res, body := do(t, "GET", srv.URL+path, "", nil)
+		assert.Equal(t, 404, res.StatusCode, path)
+		var problem models.Problem
+		require.NoError(t, json.Unmarshal([]byte(body), &problem))
+		assert.NotEqual(t, models.ProblemInvalidPath, problem.Type, path)
+	}
+
+	r := httptest.NewRequest("GET", "/user/a%2Fb", nil)
+	request, err := proxyRequest(r, "request-1")
+	require.NoError(t, err)
+	assert.Equal(t, "/user/a%2Fb", request.Path)
+	assert.Equal(t, "/user/a%2Fb", request.RequestContext.Path)
+}
+
+func TestProxyRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/user?limit=5&cursor=a&cursor=b", nil)
+	r.Header.Add("X-Tenant-ID", "acme")
+	r.Header.Add("Accept", "text/plain")
+	r.Header.Add("Accept", "application/json")
+
+	request, err := proxyRequest(r, "request-1")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/user", request.Path)
+	assert.Equal(t, "acme", request.Headers["X-Tenant-Id"])
+	assert.Equal(t, "acme", request.RequestContext.Authorizer["tenantID"])
+	assert.Equal(t, "application/json", request.Headers["Accept"])
+	assert.Equal(t, []string{"text/plain", "application/json"}, request.MultiValueHeaders["Accept"])
+	assert.Equal(t, map[string]string{"limit": "5", "cursor": "b"}, request.QueryStringParameters)
+	assert.Equal(t, []string{"a", "b"}, request.MultiValueQueryStringParameters["cursor"])
+	assert.Equal(t, "request-1", request.RequestContext.RequestID)
+	assert.Equal(t, "example.com", request.Headers["Host"])
+}
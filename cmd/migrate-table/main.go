@@ -0,0 +1,218 @@
+/*
+migrate-table copies users and their email reservations from the original userTable into the table the API uses
+now. Adding the sort key meant replacing userTable, as DynamoDB can't change the key schema of an existing table,
+so deploying the stack creates the new table empty. Users are copied with a _sk of user and reservations with a
+_sk of email, and anything already in the new table is left alone, so the copy can be safely re-run.
+
+The cutover runs in this order:
+
+ 1. Deploy the stack. CloudFormation creates the new table and points the lambdas at it, and retains userTable
+    rather than deleting it, but the API can't see its users until they are copied, so deploy at a quiet time.
+    Deploy with USER_EVENTS_DELIVERY=outbox, as the table's stream would announce every copied user as created.
+ 2. Run migrate-table with -dry-run to see what it would copy, then without.
+ 3. Run repair-email-index, which reindexes users written before emails were normalised or by the old Put,
+    and backfill-created-index, which adds the copied users to the creation index.
+ 4. Once the API is serving the copied users, delete userTable by hand.
+
+Users written to the new table between the deploy and the copy win over the copies, and are reported as
+conflicts.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+)
+
+func main() {
+	from := flag.String("from", schema.LegacyTableName, "the table to copy users from")
+	to := flag.String("to", schema.TableName, "the table to copy users to")
+	endpoint := flag.String("endpoint", "", "the DynamoDB endpoint, for running against DynamoDB Local")
+	dryRun := flag.Bool("dry-run", false, "report what would be copied without writing anything")
+	rate := flag.Float64("rate", 25, "the maximum number of items to copy per second, or 0 for no limit")
+	flag.Parse()
+
+	err := run(context.Background(), *from, *to, *endpoint, *dryRun, *rate)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+type report struct {
+	// Scanned is the number of items examined
+	Scanned int
+	// Users and Reservations are the numbers of each copied, or that would be copied by a dry run
+	Users        int
+	Reservations int
+	// Conflicts are the partition keys of items that were already in the new table
+	Conflicts []string
+	// Skipped are the partition keys of items that are neither users nor reservations
+	Skipped []string
+	// Failed holds the items that couldn't be copied, by partition key
+	Failed map[string]error
+}
+
+func run(ctx context.Context, from string, to string, endpoint string, dryRun bool, rate float64) error {
+	if from == to {
+		return fmt.Errorf("can't copy %s to itself", from)
+	}
+
+	sdkConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialise SDK config: %w", err)
+	}
+
+	client := dynamodb.NewFromConfig(sdkConfig, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = &endpoint
+		}
+	})
+
+	r, err := migrate(ctx, client, from, to, dryRun, rate)
+	if err != nil {
+		return fmt.Errorf("copy failed after scanning %d items: %w", r.Scanned, err)
+	}
+
+	fmt.Printf("Scanned %d items in %s\n", r.Scanned, from)
+	for _, pk := range r.Skipped {
+		fmt.Printf("Skipped %s, which is neither a user nor a reservation\n", pk)
+	}
+	for _, pk := range r.Conflicts {
+		fmt.Printf("Conflict on %s, which is already in %s\n", pk, to)
+	}
+	for pk, err := range r.Failed {
+		fmt.Printf("Failed to copy %s: %v\n", pk, err)
+	}
+
+	verb := "copied"
+	if dryRun {
+		verb = "would be copied"
+	}
+	fmt.Printf("%d users and %d reservations %s to %s, %d conflicts, %d skipped, %d failures\n",
+		r.Users, r.Reservations, verb, to, len(r.Conflicts), len(r.Skipped), len(r.Failed))
+
+	if len(r.Failed) > 0 {
+		return fmt.Errorf("%d items could not be copied", len(r.Failed))
+	}
+	return nil
+}
+
+// migrate copies every user and reservation in from to to, writing at most rate items per second
+func migrate(ctx context.Context, client *dynamodb.Client, from string, to string, dryRun bool, rate float64) (report, error) {
+	r := report{Failed: map[string]error{}}
+
+	var throttle <-chan time.Time
+	if rate > 0 && !dryRun {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName:      aws.String(from),
+		ConsistentRead: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return r, err
+		}
+
+		for _, item := range page.Items {
+			r.Scanned++
+			pk := partitionKey(item)
+			migrated, sk, ok := migratedItem(item)
+			if !ok {
+				r.Skipped = append(r.Skipped, pk)
+				continue
+			}
+
+			if !dryRun {
+				if throttle != nil {
+					select {
+					case <-ctx.Done():
+						return r, ctx.Err()
+					case <-throttle:
+					}
+				}
+
+				_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
+					TableName:           aws.String(to),
+					Item:                migrated,
+					ConditionExpression: aws.String("attribute_not_exists(#pk)"),
+					ExpressionAttributeNames: map[string]string{
+						"#pk": schema.PartitionKey,
+					},
+				})
+				var conflict *types.ConditionalCheckFailedException
+				if errors.As(err, &conflict) {
+					r.Conflicts = append(r.Conflicts, pk)
+					continue
+				}
+				if err != nil {
+					r.Failed[pk] = err
+					continue
+				}
+			}
+
+			if sk == schema.UserSK {
+				r.Users++
+			} else {
+				r.Reservations++
+			}
+		}
+	}
+
+	sort.Strings(r.Conflicts)
+	sort.Strings(r.Skipped)
+	return r, nil
+}
+
+/*
+migratedItem returns the item as it's stored in the new table, along with its sort key, or false if it isn't a
+user or an email reservation. Items from the original table only have a partition key, so anything with a sort
+key has already been migrated.
+*/
+func migratedItem(item map[string]types.AttributeValue) (map[string]types.AttributeValue, string, bool) {
+	if _, ok := item[schema.SortKey]; ok {
+		return nil, "", false
+	}
+
+	var sk string
+	pk := partitionKey(item)
+	switch {
+	case strings.HasPrefix(pk, schema.UserPrefix):
+		sk = schema.UserSK
+	case strings.HasPrefix(pk, schema.EmailPrefix):
+		sk = schema.EmailSK
+	default:
+		return nil, "", false
+	}
+
+	migrated := make(map[string]types.AttributeValue, len(item)+1)
+	for k, v := range item {
+		migrated[k] = v
+	}
+	migrated[schema.SortKey] = &types.AttributeValueMemberS{Value: sk}
+	return migrated, sk, true
+}
+
+func partitionKey(item map[string]types.AttributeValue) string {
+	if v, ok := item[schema.PartitionKey].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
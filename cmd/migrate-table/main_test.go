@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigratedItem(t *testing.T) {
+	s := func(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+
+	user := map[string]types.AttributeValue{schema.PartitionKey: s("user/12345"), "email": s("benk13@gmail.com")}
+	migrated, sk, ok := migratedItem(user)
+	assert.True(t, ok)
+	assert.Equal(t, schema.UserSK, sk)
+	assert.Equal(t, map[string]types.AttributeValue{
+		schema.PartitionKey: s("user/12345"),
+		schema.SortKey:      s(schema.UserSK),
+		"email":             s("benk13@gmail.com"),
+	}, migrated)
+	// The original item is left as it was
+	assert.NotContains(t, user, schema.SortKey)
+
+	migrated, sk, ok = migratedItem(map[string]types.AttributeValue{schema.PartitionKey: s("email/benk13@gmail.com"), "userID": s("12345")})
+	assert.True(t, ok)
+	assert.Equal(t, schema.EmailSK, sk)
+	assert.Equal(t, s(schema.EmailSK), migrated[schema.SortKey])
+
+	// Items that are already migrated, or aren't users or reservations, are skipped
+	_, _, ok = migratedItem(map[string]types.AttributeValue{schema.PartitionKey: s("user/12345"), schema.SortKey: s(schema.UserSK)})
+	assert.False(t, ok)
+	_, _, ok = migratedItem(map[string]types.AttributeValue{schema.PartitionKey: s("something/else")})
+	assert.False(t, ok)
+}
@@ -0,0 +1,99 @@
+/*
+repair-email-index rewrites users whose email index key or reservation doesn't match their email, such as users
+written by the old Put, which indexed them under user/<email>. Run it with -dry-run first to see what it would
+change. Users that share an email are reported as collisions and left alone, and need resolving by hand.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+)
+
+func main() {
+	tableName := flag.String("table", schema.TableName, "the user table to repair")
+	endpoint := flag.String("endpoint", "", "the DynamoDB endpoint, for running against DynamoDB Local")
+	dryRun := flag.Bool("dry-run", false, "report what would be repaired without writing anything")
+	rate := flag.Float64("rate", 10, "the maximum number of users to repair per second, or 0 for no limit")
+	releaseDeleted := flag.Bool("release-deleted-emails", false, "whether the table's deleted users have had their emails released")
+	flag.Parse()
+
+	err := run(context.Background(), *tableName, *endpoint, *dryRun, *rate, *releaseDeleted)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, tableName string, endpoint string, dryRun bool, rate float64, releaseDeleted bool) error {
+	sdkConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialise SDK config: %w", err)
+	}
+
+	client := dynamodb.NewFromConfig(sdkConfig, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = &endpoint
+		}
+	})
+
+	opts := []userstore.Option{}
+	if releaseDeleted {
+		opts = append(opts, userstore.WithDeletedEmailPolicy(userstore.ReleaseEmail))
+	}
+	store := userstore.NewUserStore(client, tableName, opts...)
+
+	report, err := store.RepairEmailIndex(ctx, userstore.EmailRepairOptions{
+		DryRun:          dryRun,
+		WritesPerSecond: rate,
+	})
+	if err != nil {
+		return fmt.Errorf("repair failed after scanning %d users: %w", report.Scanned, err)
+	}
+
+	fmt.Printf("Scanned %d users\n", report.Scanned)
+
+	verb := "Repaired"
+	if dryRun {
+		verb = "Would repair"
+	}
+	for _, r := range report.Repairs {
+		// Users are identified by partition key, as ids are only unique within a tenant
+		user := schema.UserPK(r.TenantID, r.UserID)
+		if _, failed := report.Failed[user]; failed {
+			continue
+		}
+		changes := []string{}
+		if r.StoredKey != r.CanonicalKey {
+			changes = append(changes, fmt.Sprintf("index key %q -> %q", r.StoredKey, r.CanonicalKey))
+		}
+		if r.MissingReservation {
+			changes = append(changes, "missing reservation")
+		}
+		fmt.Printf("%s %s: %s\n", verb, user, strings.Join(changes, ", "))
+	}
+
+	for _, c := range report.Collisions {
+		fmt.Printf("Collision on %s between users %s\n", schema.EmailKey(c.TenantID, c.NormalizedEmail), strings.Join(c.UserIDs, ", "))
+	}
+
+	for id, err := range report.Failed {
+		fmt.Printf("Failed to repair %s: %v\n", id, err)
+	}
+
+	fmt.Printf("%d users repaired, %d need repairing, %d collisions, %d failures\n",
+		report.Repaired, len(report.Repairs), len(report.Collisions), len(report.Failed))
+
+	if len(report.Failed) > 0 {
+		return fmt.Errorf("%d users could not be repaired", len(report.Failed))
+	}
+	return nil
+}
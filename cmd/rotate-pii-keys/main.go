@@ -0,0 +1,94 @@
+/*
+rotate-pii-keys re-encrypts the personal data in the user table that is unencrypted or encrypted under a retired
+master key, so that the key can be retired. Run it after enabling encryption on an existing table, which also
+moves users from their plaintext email keys to the blind index, and after switching to a new master key. The
+keys are configured through the same environment variables as the lambdas. Run it with -dry-run first to see
+what it would change.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/db/storeconfig"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+)
+
+func main() {
+	tableName := flag.String("table", schema.TableName, "the user table to rotate")
+	endpoint := flag.String("endpoint", "", "the DynamoDB endpoint, for running against DynamoDB Local")
+	dryRun := flag.Bool("dry-run", false, "report what would be re-encrypted without writing anything")
+	rate := flag.Float64("rate", 10, "the maximum number of items to rewrite per second, or 0 for no limit")
+	releaseDeleted := flag.Bool("release-deleted-emails", false, "whether the table's deleted users have had their emails released")
+	flag.Parse()
+
+	err := run(context.Background(), *tableName, *endpoint, *dryRun, *rate, *releaseDeleted)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, tableName string, endpoint string, dryRun bool, rate float64, releaseDeleted bool) error {
+	sdkConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialise SDK config: %w", err)
+	}
+
+	client := dynamodb.NewFromConfig(sdkConfig, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = &endpoint
+		}
+	})
+
+	c := storeconfig.FromEnv()
+	if c.PIIKeyARN == "" && c.PIIKeyFile == "" {
+		return fmt.Errorf("either %s or %s must be set", storeconfig.PIIKeyARNEnv, storeconfig.PIIKeyFileEnv)
+	}
+	// Only the encryption options matter, as nothing is written through the store's usual writes
+	c.Outbox = false
+	opts, err := storeconfig.StoreOptions(ctx, c, sdkConfig)
+	if err != nil {
+		return err
+	}
+	if releaseDeleted {
+		opts = append(opts, userstore.WithDeletedEmailPolicy(userstore.ReleaseEmail))
+	}
+	store := userstore.NewUserStore(client, tableName, opts...)
+
+	report, err := store.RotateKeys(ctx, userstore.KeyRotationOptions{
+		DryRun:          dryRun,
+		WritesPerSecond: rate,
+	})
+	if err != nil {
+		return fmt.Errorf("rotation failed after scanning %d items: %w", report.Scanned, err)
+	}
+
+	repair := report.EmailRepair
+	for _, c := range repair.Collisions {
+		fmt.Printf("Collision on %s between users %s, left on their plaintext email key\n", schema.EmailKey(c.TenantID, c.NormalizedEmail), strings.Join(c.UserIDs, ", "))
+	}
+	for id, err := range repair.Failed {
+		fmt.Printf("Failed to move %s to the blind index: %v\n", id, err)
+	}
+	for id, err := range report.Failed {
+		fmt.Printf("Failed to re-encrypt %s: %v\n", id, err)
+	}
+
+	fmt.Printf("%d users moved to the blind index, %d need moving, %d collisions\n",
+		repair.Repaired, len(repair.Repairs), len(repair.Collisions))
+	fmt.Printf("Scanned %d items, %d re-encrypted, %d need re-encrypting, %d failures\n",
+		report.Scanned, report.Rotated, report.Stale, len(report.Failed))
+
+	if len(report.Failed) > 0 || len(repair.Failed) > 0 {
+		return fmt.Errorf("%d items could not be rotated", len(report.Failed)+len(repair.Failed))
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+/*
+Package schema declares the layout of the DynamoDB user table: its keys, indexes and TTL attribute, along with
+the builders for the key values stored in them. The CDK stack, the local tables used in tests and the
+create-table command are all generated from here, so that they can't drift apart from the store.
+*/
+package schema
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	/*
+		TableName is the name of the deployed table. Adding the sort key meant replacing the original table, as
+		DynamoDB can't change the key schema of an existing table. Deploying retains the original table, and the
+		migrate-table command copies its users and reservations across, in the cutover order it documents.
+	*/
+	TableName = "userTableV2"
+	// LegacyTableName is the name of the original table, which had no sort key
+	LegacyTableName = "userTable"
+
+	PartitionKey = "_pk"
+	SortKey      = "_sk"
+	GSI1Key      = "_gsi1"
+	// GSI2Key and GSI2SortKey are only set on outbox items that are still to be delivered, so GSI2 is sparse
+	GSI2Key     = "_gsi2"
+	GSI2SortKey = "_gsi2sk"
+	/*
+		GSI3Key and GSI3SortKey index users by when they were created, and are only set on users that haven't been
+		deleted, so GSI3 is sparse. Users written before GSI3 was added are indexed by backfill-created-index.
+	*/
+	GSI3Key     = "_gsi3"
+	GSI3SortKey = "_gsi3sk"
+	// TTLAttribute holds the epoch second after which DynamoDB purges an item
+	TTLAttribute = "_ttl"
+
+	GSI1 = "gsi1"
+	GSI2 = "gsi2"
+	GSI3 = "gsi3"
+)
+
+const (
+	// TenantPrefix starts the keys of every item belonging to a tenant other than the default tenant
+	TenantPrefix = "tenant/"
+	// UserPrefix is the prefix shared by every user's partition key, after the tenant prefix
+	UserPrefix = "user/"
+	// EmailPrefix is the prefix shared by every email key
+	EmailPrefix = "email/"
+	// HistoryPrefix is the prefix shared by the sort keys of every history item
+	HistoryPrefix = "history/"
+	// OutboxPrefix is the prefix shared by the sort keys of every outbox item, and their shards' GSI2 keys
+	OutboxPrefix = "outbox/"
+	// CreatedPrefix is the prefix shared by the GSI3 keys of every creation bucket, after the tenant prefix
+	CreatedPrefix = "created/"
+
+	// UserSK is the sort key of the user item in a user's partition, alongside their history items
+	UserSK = "user"
+	// EmailSK is the sort key of an email reservation
+	EmailSK = "email"
+)
+
+/*
+OutboxShards is the number of GSI2 partitions pending outbox items are spread across, so that a busy outbox
+doesn't concentrate its writes on a single partition. Each user's items all land in the same shard.
+*/
+const OutboxShards = 8
+
+// historyTimeFormat is fixed width, so that history sort keys order by time
+const historyTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+/*
+createdBucketFormat buckets users by the month they were created in. A month keeps the number of buckets a
+range query visits small, while spreading each tenant's users across partitions over time.
+*/
+const createdBucketFormat = "2006-01"
+
+// Index is a global secondary index, projecting all attributes
+type Index struct {
+	Name         string
+	PartitionKey string
+	SortKey      string
+}
+
+// Table describes a table's key schema. All key attributes are strings.
+type Table struct {
+	PartitionKey string
+	SortKey      string
+	Indexes      []Index
+	TTLAttribute string
+}
+
+// UserTable is the layout of the user table
+var UserTable = Table{
+	PartitionKey: PartitionKey,
+	SortKey:      SortKey,
+	Indexes: []Index{
+		{Name: GSI1, PartitionKey: GSI1Key},
+		{Name: GSI2, PartitionKey: GSI2Key, SortKey: GSI2SortKey},
+		{Name: GSI3, PartitionKey: GSI3Key, SortKey: GSI3SortKey},
+	},
+	TTLAttribute: TTLAttribute,
+}
+
+/*
+tenantPrefix returns the prefix of the keys of the tenant's items. Keys of the default tenant have no prefix,
+so that the items written before tenants were introduced belong to it.
+*/
+func tenantPrefix(tenant string) string {
+	if tenant == "" {
+		return ""
+	}
+	return TenantPrefix + tenant + "/"
+}
+
+// UserPK returns the partition key of the tenant's user with the given id
+func UserPK(tenant string, userID string) string {
+	return tenantPrefix(tenant) + UserPrefix + userID
+}
+
+// ParseUserPK returns the tenant and user id a user's partition key was built from
+func ParseUserPK(pk string) (tenant string, userID string, ok bool) {
+	if rest, found := strings.CutPrefix(pk, TenantPrefix); found {
+		tenant, pk, found = strings.Cut(rest, "/")
+		if !found || tenant == "" {
+			return "", "", false
+		}
+	}
+	userID, ok = strings.CutPrefix(pk, UserPrefix)
+	if !ok {
+		return "", "", false
+	}
+	return tenant, userID, true
+}
+
+/*
+EmailKey returns the key for the given email in the tenant, which is both the partition key of the email's
+reservation and the GSI1 key the user it belongs to is indexed by. The email should already be normalised, or
+replaced by its blind index key if the store encrypts.
+*/
+func EmailKey(tenant string, email string) string {
+	return tenantPrefix(tenant) + EmailPrefix + email
+}
+
+/*
+HistorySK returns the sort key of the history item for a user's change to the given version at the given time.
+The version breaks ties between changes made at the same instant.
+*/
+func HistorySK(at time.Time, version int64) string {
+	return fmt.Sprintf("%s%s/%020d", HistoryPrefix, at.UTC().Format(historyTimeFormat), version)
+}
+
+// OutboxSK returns the sort key of the outbox item for a user's change to the given version at the given time
+func OutboxSK(at time.Time, version int64) string {
+	return fmt.Sprintf("%s%s/%020d", OutboxPrefix, at.UTC().Format(historyTimeFormat), version)
+}
+
+// OutboxShard returns the GSI2 key of the shard holding the given user's pending outbox items
+func OutboxShard(tenant string, userID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(tenantPrefix(tenant) + userID))
+	return fmt.Sprintf("%s%d", OutboxPrefix, h.Sum32()%OutboxShards)
+}
+
+// OutboxShardKeys returns the GSI2 keys of every outbox shard
+func OutboxShardKeys() []string {
+	keys := make([]string, OutboxShards)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s%d", OutboxPrefix, i)
+	}
+	return keys
+}
+
+/*
+OutboxOrderKey returns the GSI2 sort key of a pending outbox item, which orders each user's items by version
+within their shard. Versions are used rather than timestamps as they can't be skewed by clocks.
+*/
+func OutboxOrderKey(tenant string, userID string, version int64) string {
+	return fmt.Sprintf("%s%s/%020d", tenantPrefix(tenant), userID, version)
+}
+
+// CreatedBucket returns the GSI3 key of the bucket holding the tenant's users created at the given time
+func CreatedBucket(tenant string, at time.Time) string {
+	return tenantPrefix(tenant) + CreatedPrefix + at.UTC().Format(createdBucketFormat)
+}
+
+// CreatedBuckets returns the GSI3 keys of the tenant's buckets holding users created from from to to, in order
+func CreatedBuckets(tenant string, from time.Time, to time.Time) []string {
+	buckets := []string{}
+	last := CreatedBucket(tenant, to)
+	for month := time.Date(from.UTC().Year(), from.UTC().Month(), 1, 0, 0, 0, 0, time.UTC); ; month = month.AddDate(0, 1, 0) {
+		bucket := CreatedBucket(tenant, month)
+		buckets = append(buckets, bucket)
+		if bucket >= last {
+			return buckets
+		}
+	}
+}
+
+/*
+CreatedOrderKey returns the GSI3 sort key of a user, which orders users by when they were created, with the id
+breaking ties. With an empty id it is a bound, sorting before every user created at or after the given time and
+after every user created before it.
+*/
+func CreatedOrderKey(at time.Time, userID string) string {
+	return at.UTC().Format(historyTimeFormat) + "/" + userID
+}
+
+// UserKey returns the primary key of the user item for the tenant's user with the given id
+func UserKey(tenant string, userID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		PartitionKey: &types.AttributeValueMemberS{Value: UserPK(tenant, userID)},
+		SortKey:      &types.AttributeValueMemberS{Value: UserSK},
+	}
+}
+
+// EmailReservationKey returns the primary key of the reservation item for the given, normalised, email in the tenant
+func EmailReservationKey(tenant string, email string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		PartitionKey: &types.AttributeValueMemberS{Value: EmailKey(tenant, email)},
+		SortKey:      &types.AttributeValueMemberS{Value: EmailSK},
+	}
+}
+
+// CreateTableInput returns the input for creating the table with the given name, on demand billing
+func (t Table) CreateTableInput(name string) *dynamodb.CreateTableInput {
+	definitions := []types.AttributeDefinition{}
+	defined := map[string]bool{}
+	define := func(attribute string) {
+		if attribute == "" || defined[attribute] {
+			return
+		}
+		defined[attribute] = true
+		definitions = append(definitions, types.AttributeDefinition{
+			AttributeName: aws.String(attribute),
+			AttributeType: types.ScalarAttributeTypeS,
+		})
+	}
+
+	define(t.PartitionKey)
+	define(t.SortKey)
+
+	indexes := []types.GlobalSecondaryIndex{}
+	for _, index := range t.Indexes {
+		define(index.PartitionKey)
+		define(index.SortKey)
+		indexes = append(indexes, types.GlobalSecondaryIndex{
+			IndexName:  aws.String(index.Name),
+			KeySchema:  keySchema(index.PartitionKey, index.SortKey),
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		})
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName:            aws.String(name),
+		AttributeDefinitions: definitions,
+		KeySchema:            keySchema(t.PartitionKey, t.SortKey),
+		BillingMode:          types.BillingModePayPerRequest,
+	}
+	if len(indexes) > 0 {
+		input.GlobalSecondaryIndexes = indexes
+	}
+	return input
+}
+
+func keySchema(partitionKey string, sortKey string) []types.KeySchemaElement {
+	elements := []types.KeySchemaElement{
+		{AttributeName: aws.String(partitionKey), KeyType: types.KeyTypeHash},
+	}
+	if sortKey != "" {
+		elements = append(elements, types.KeySchemaElement{AttributeName: aws.String(sortKey), KeyType: types.KeyTypeRange})
+	}
+	return elements
+}
+
+// Create creates the table with the given name, waits for it to become active and then enables its TTL
+func (t Table) Create(ctx context.Context, client *dynamodb.Client, name string) error {
+	_, err := client.CreateTable(ctx, t.CreateTableInput(name))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create table %s", name)
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(client, func(o *dynamodb.TableExistsWaiterOptions) {
+		o.MinDelay = 100 * time.Millisecond
+	})
+	err = waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(name)}, time.Minute)
+	if err != nil {
+		return errors.Wrapf(err, "table %s did not become active", name)
+	}
+
+	if t.TTLAttribute == "" {
+		return nil
+	}
+	_, err = client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(name),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(t.TTLAttribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to enable TTL on table %s", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package schema
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTableInput(t *testing.T) {
+	input := UserTable.CreateTableInput("users")
+
+	assert.Equal(t, "users", aws.ToString(input.TableName))
+	assert.Equal(t, []types.KeySchemaElement{
+		{AttributeName: aws.String(PartitionKey), KeyType: types.KeyTypeHash},
+		{AttributeName: aws.String(SortKey), KeyType: types.KeyTypeRange},
+	}, input.KeySchema)
+
+	// Every key attribute must be defined exactly once
+	defined := map[string]int{}
+	for _, d := range input.AttributeDefinitions {
+		defined[aws.ToString(d.AttributeName)]++
+	}
+	assert.Equal(t, map[string]int{PartitionKey: 1, SortKey: 1, GSI1Key: 1, GSI2Key: 1, GSI2SortKey: 1, GSI3Key: 1, GSI3SortKey: 1}, defined)
+
+	require.Len(t, input.GlobalSecondaryIndexes, 3)
+	assert.Equal(t, GSI1, aws.ToString(input.GlobalSecondaryIndexes[0].IndexName))
+	assert.Equal(t, []types.KeySchemaElement{
+		{AttributeName: aws.String(GSI1Key), KeyType: types.KeyTypeHash},
+	}, input.GlobalSecondaryIndexes[0].KeySchema)
+	assert.Equal(t, GSI2, aws.ToString(input.GlobalSecondaryIndexes[1].IndexName))
+	assert.Equal(t, []types.KeySchemaElement{
+		{AttributeName: aws.String(GSI2Key), KeyType: types.KeyTypeHash},
+		{AttributeName: aws.String(GSI2SortKey), KeyType: types.KeyTypeRange},
+	}, input.GlobalSecondaryIndexes[1].KeySchema)
+	assert.Equal(t, GSI3, aws.ToString(input.GlobalSecondaryIndexes[2].IndexName))
+}
+
+func TestKeys(t *testing.T) {
+	assert.Equal(t, "user/12345", UserPK("", "12345"))
+	assert.Equal(t, "email/benk13@gmail.com", EmailKey("", "benk13@gmail.com"))
+	assert.Equal(t, "tenant/acme/user/12345", UserPK("acme", "12345"))
+	assert.Equal(t, "tenant/acme/email/benk13@gmail.com", EmailKey("acme", "benk13@gmail.com"))
+
+	at := time.Date(2024, 10, 1, 12, 0, 0, 500, time.UTC)
+	assert.Equal(t, "history/2024-10-01T12:00:00.000000500Z/00000000000000000003", HistorySK(at, 3))
+	// Sort keys order by time, whatever the precision of the timestamps
+	assert.Less(t, HistorySK(at, 3), HistorySK(at.Add(time.Second-500), 4))
+	assert.Less(t, HistorySK(at.Add(-500), 2), HistorySK(at, 3))
+}
+
+func TestOutboxKeys(t *testing.T) {
+	at := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "outbox/2024-10-01T12:00:00.000000000Z/00000000000000000003", OutboxSK(at, 3))
+	assert.Less(t, OutboxOrderKey("", "12345", 9), OutboxOrderKey("", "12345", 10))
+	assert.NotEqual(t, OutboxOrderKey("", "12345", 9), OutboxOrderKey("acme", "12345", 9))
+
+	shards := OutboxShardKeys()
+	assert.Len(t, shards, OutboxShards)
+	assert.Contains(t, shards, OutboxShard("", "12345"))
+	assert.Contains(t, shards, OutboxShard("acme", "12345"))
+	// A user's items always land in the same shard
+	assert.Equal(t, OutboxShard("", "12345"), OutboxShard("", "12345"))
+}
+
+func TestCreatedKeys(t *testing.T) {
+	at := time.Date(2024, 10, 1, 12, 0, 0, 500, time.UTC)
+	assert.Equal(t, "created/2024-10", CreatedBucket("", at))
+	assert.Equal(t, "tenant/acme/created/2024-10", CreatedBucket("acme", at))
+	assert.Equal(t, "2024-10-01T12:00:00.000000500Z/12345", CreatedOrderKey(at, "12345"))
+
+	// Bounds sort between the users created either side of them
+	assert.LessOrEqual(t, CreatedOrderKey(at, ""), CreatedOrderKey(at, "12345"))
+	assert.Less(t, CreatedOrderKey(at.Add(-1), "12345"), CreatedOrderKey(at, ""))
+
+	assert.Equal(t, []string{"created/2024-11", "created/2024-12", "created/2025-01"},
+		CreatedBuckets("", time.Date(2024, 11, 30, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, []string{"tenant/acme/created/2024-10"}, CreatedBuckets("acme", at, at.Add(time.Hour)))
+}
+
+func TestParseUserPK(t *testing.T) {
+	for _, tenant := range []string{"", "acme"} {
+		parsedTenant, userID, ok := ParseUserPK(UserPK(tenant, "12345"))
+		assert.True(t, ok)
+		assert.Equal(t, tenant, parsedTenant)
+		assert.Equal(t, "12345", userID)
+	}
+
+	for _, pk := range []string{"email/benk13@gmail.com", "tenant/acme/email/benk13@gmail.com", "tenant/acme", "tenant//user/12345"} {
+		_, _, ok := ParseUserPK(pk)
+		assert.False(t, ok, pk)
+	}
+}
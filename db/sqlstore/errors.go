@@ -0,0 +1,69 @@
+package sqlstore
+
+import (
+	"context"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/pkg/errors"
+)
+
+// wrappedError pairs one of the userstore sentinel errors with the underlying driver error
+type wrappedError struct {
+	kind error
+	err  error
+}
+
+func (e wrappedError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e wrappedError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+/*
+classifyError wraps driver errors in the matching userstore sentinel error, returning any other errors
+unchanged. The drivers' error types are matched by their methods rather than imported, so the store doesn't
+depend on either driver.
+*/
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return wrappedError{kind: userstore.ErrTimeout, err: err}
+	}
+
+	// Postgres, via pgconn.PgError
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		switch pgErr.SQLState() {
+		case "40001", "40P01", "23505":
+			// Serialization failures, deadlocks and unique violations all mean a concurrent write won
+			return wrappedError{kind: userstore.ErrConflict, err: err}
+		case "53300", "55P03":
+			// Too many connections, lock not available
+			return wrappedError{kind: userstore.ErrThrottled, err: err}
+		case "57014":
+			// Query canceled, by a statement timeout
+			return wrappedError{kind: userstore.ErrTimeout, err: err}
+		}
+		return err
+	}
+
+	// SQLite, via sqlite.Error, whose codes are extended result codes
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case 5, 6:
+			// SQLITE_BUSY, SQLITE_LOCKED
+			return wrappedError{kind: userstore.ErrThrottled, err: err}
+		case 19:
+			// SQLITE_CONSTRAINT
+			return wrappedError{kind: userstore.ErrConflict, err: err}
+		}
+	}
+
+	return err
+}
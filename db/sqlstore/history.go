@@ -0,0 +1,114 @@
+package sqlstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/pkg/errors"
+)
+
+/*
+History returns a page of at most limit of the user's history entries, newest first. History is kept for
+deleted users, even once they have been purged. Cursors are signed with the store's cursor key, and are only
+accepted for the user they were issued for.
+*/
+func (s *Store) History(ctx context.Context, id string, limit int32, cursor string) (models.HistoryPage, error) {
+	if len(s.opts.CursorKey) == 0 {
+		return models.HistoryPage{}, errors.New("a cursor key is required to list history")
+	}
+
+	if limit <= 0 {
+		limit = userstore.DefaultPageSize
+	}
+	if limit > userstore.MaxPageSize {
+		limit = userstore.MaxPageSize
+	}
+
+	before, err := s.decodeHistoryCursor(id, cursor)
+	if err != nil {
+		return models.HistoryPage{}, err
+	}
+
+	// Fetching one extra entry tells us whether there's another page
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(
+		`SELECT seq, action, version, recorded_at, actor, request_id, changes FROM user_history
+		WHERE tenant_id = ? AND user_id = ? AND seq < ? ORDER BY seq DESC LIMIT ?`),
+		tenant.FromContext(ctx), id, before, limit+1,
+	)
+	if err != nil {
+		return models.HistoryPage{}, classifyError(err)
+	}
+	defer rows.Close()
+
+	page := models.HistoryPage{Entries: []models.HistoryEntry{}}
+	var last int64
+	for rows.Next() {
+		if int32(len(page.Entries)) == limit {
+			page.Cursor = s.encodeHistoryCursor(id, last)
+			break
+		}
+
+		entry := models.HistoryEntry{UserID: id}
+		var changes string
+		err = rows.Scan(&last, &entry.Action, &entry.Version, &entry.Timestamp, &entry.Actor, &entry.RequestID, &changes)
+		if err != nil {
+			return models.HistoryPage{}, err
+		}
+		entry.Timestamp = entry.Timestamp.UTC()
+		err = json.Unmarshal([]byte(changes), &entry.Changes)
+		if err != nil {
+			return models.HistoryPage{}, errors.Wrap(err, "an error ocurred unmarshaling history changes")
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+
+	return page, classifyError(rows.Err())
+}
+
+// record appends the change from before to after to the user's history
+func (s *Store) record(ctx context.Context, q querier, action models.HistoryAction, before models.User, after models.User) error {
+	entry := userstore.NewHistoryEntry(ctx, action, before, after)
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return errors.Wrap(err, "an error ocurred marshaling history changes")
+	}
+
+	// Writers to the same user are serialised by their write to the users table, so the next seq can't be taken
+	_, err = s.exec(ctx, q,
+		`INSERT INTO user_history (tenant_id, user_id, seq, action, version, recorded_at, actor, request_id, changes)
+		SELECT ?, ?, COALESCE(MAX(seq), 0) + 1, ?, ?, ?, ?, ?, ? FROM user_history WHERE tenant_id = ? AND user_id = ?`,
+		after.TenantID, entry.UserID, string(entry.Action), entry.Version, entry.Timestamp, entry.Actor, entry.RequestID, string(changes),
+		after.TenantID, entry.UserID,
+	)
+	return err
+}
+
+func (s *Store) encodeHistoryCursor(id string, seq int64) string {
+	return userstore.SignCursor(s.opts.CursorKey, []byte(fmt.Sprintf("history/%s/%d", id, seq)))
+}
+
+// decodeHistoryCursor returns the seq entries must come before, which is unbounded for an empty cursor
+func (s *Store) decodeHistoryCursor(id string, cursor string) (int64, error) {
+	if cursor == "" {
+		return 1<<63 - 1, nil
+	}
+	b, err := userstore.VerifyCursor(s.opts.CursorKey, cursor)
+	if err != nil {
+		return 0, err
+	}
+	rest, ok := strings.CutPrefix(string(b), "history/"+id+"/")
+	if !ok {
+		return 0, userstore.ErrInvalidCursor
+	}
+	seq, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil {
+		return 0, userstore.ErrInvalidCursor
+	}
+	return seq, nil
+}
@@ -0,0 +1,164 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+/*
+migrations are applied in order, each in its own transaction, and recorded in the schema_migrations table.
+Existing migrations must never be edited once released; changes to the schema go in a new migration.
+*/
+func migrations(d Dialect) [][]string {
+	ts := d.timestampType()
+	return [][]string{
+		{
+			// email_key is the normalised email the user is indexed by, which is null for users deleted under the
+			// ReleaseEmail policy. expires_at is when a deleted user is purged, in epoch seconds.
+			fmt.Sprintf(`CREATE TABLE users (
+				user_id    TEXT PRIMARY KEY,
+				email      TEXT NOT NULL,
+				email_key  TEXT,
+				version    BIGINT NOT NULL,
+				created_at %[1]s NOT NULL,
+				updated_at %[1]s NOT NULL,
+				deleted_at %[1]s,
+				expires_at BIGINT
+			)`, ts),
+			`CREATE INDEX users_email_key ON users (email_key)`,
+			`CREATE INDEX users_expires_at ON users (expires_at)`,
+			// The primary key is what makes normalised emails unique
+			`CREATE TABLE email_reservations (
+				email_key  TEXT PRIMARY KEY,
+				user_id    TEXT NOT NULL,
+				expires_at BIGINT
+			)`,
+			`CREATE INDEX email_reservations_expires_at ON email_reservations (expires_at)`,
+		},
+		{
+			// seq orders a user's entries, and unlike the users table, history is never purged. changes is the
+			// entry's changes as JSON.
+			fmt.Sprintf(`CREATE TABLE user_history (
+				user_id     TEXT NOT NULL,
+				seq         BIGINT NOT NULL,
+				action      TEXT NOT NULL,
+				version     BIGINT NOT NULL,
+				recorded_at %[1]s NOT NULL,
+				actor       TEXT NOT NULL,
+				request_id  TEXT NOT NULL,
+				changes     TEXT NOT NULL,
+				PRIMARY KEY (user_id, seq)
+			)`, ts),
+		},
+		{
+			// Scopes ids and emails to tenants. Primary keys can't be altered in SQLite, so each table is rebuilt
+			// with tenant_id leading its key, and existing rows move to the default tenant, whose id is empty.
+			fmt.Sprintf(`CREATE TABLE users_v3 (
+				tenant_id  TEXT NOT NULL,
+				user_id    TEXT NOT NULL,
+				email      TEXT NOT NULL,
+				email_key  TEXT,
+				version    BIGINT NOT NULL,
+				created_at %[1]s NOT NULL,
+				updated_at %[1]s NOT NULL,
+				deleted_at %[1]s,
+				expires_at BIGINT,
+				PRIMARY KEY (tenant_id, user_id)
+			)`, ts),
+			`INSERT INTO users_v3 SELECT '', user_id, email, email_key, version, created_at, updated_at, deleted_at, expires_at FROM users`,
+			`DROP TABLE users`,
+			`ALTER TABLE users_v3 RENAME TO users`,
+			`CREATE INDEX users_email_key ON users (tenant_id, email_key)`,
+			`CREATE INDEX users_expires_at ON users (expires_at)`,
+			`CREATE TABLE email_reservations_v3 (
+				tenant_id  TEXT NOT NULL,
+				email_key  TEXT NOT NULL,
+				user_id    TEXT NOT NULL,
+				expires_at BIGINT,
+				PRIMARY KEY (tenant_id, email_key)
+			)`,
+			`INSERT INTO email_reservations_v3 SELECT '', email_key, user_id, expires_at FROM email_reservations`,
+			`DROP TABLE email_reservations`,
+			`ALTER TABLE email_reservations_v3 RENAME TO email_reservations`,
+			`CREATE INDEX email_reservations_expires_at ON email_reservations (expires_at)`,
+			fmt.Sprintf(`CREATE TABLE user_history_v3 (
+				tenant_id   TEXT NOT NULL,
+				user_id     TEXT NOT NULL,
+				seq         BIGINT NOT NULL,
+				action      TEXT NOT NULL,
+				version     BIGINT NOT NULL,
+				recorded_at %[1]s NOT NULL,
+				actor       TEXT NOT NULL,
+				request_id  TEXT NOT NULL,
+				changes     TEXT NOT NULL,
+				PRIMARY KEY (tenant_id, user_id, seq)
+			)`, ts),
+			`INSERT INTO user_history_v3 SELECT '', user_id, seq, action, version, recorded_at, actor, request_id, changes FROM user_history`,
+			`DROP TABLE user_history`,
+			`ALTER TABLE user_history_v3 RENAME TO user_history`,
+		},
+		{
+			`CREATE INDEX users_created_at ON users (tenant_id, created_at, user_id)`,
+		},
+	}
+}
+
+// Migrate brings the database schema up to date. It is safe to call on every startup.
+func (s *Store) Migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the migrations table")
+	}
+
+	for i, statements := range migrations(s.dialect) {
+		version := i + 1
+		err := s.migrate(ctx, version, statements)
+		if err != nil {
+			return errors.Wrapf(err, "failed to apply migration %d", version)
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) migrate(ctx context.Context, version int, statements []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Stops concurrent startups applying the same migration. SQLite transactions already hold the write lock,
+	// provided the connection is opened with _txlock=immediate.
+	if s.dialect == Postgres {
+		_, err = tx.ExecContext(ctx, `LOCK TABLE schema_migrations IN EXCLUSIVE MODE`)
+		if err != nil {
+			return err
+		}
+	}
+
+	var applied int
+	err = tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`), version).Scan(&applied)
+	if err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
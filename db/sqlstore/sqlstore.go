@@ -0,0 +1,711 @@
+/*
+Package sqlstore is a database/sql implementation of userstore.Store, for deployments that can't use DynamoDB.
+It supports SQLite, which is handy for running the API locally, and Postgres. Emails are kept unique through a
+reservations table keyed on the normalised email, mirroring the reservation items of the DynamoDB store, and
+writes are made conditional on the record version in the same way, so the two return the same errors. Every
+table is keyed by tenant first, and every query is filtered to the tenant the request is made on behalf of.
+
+The database/sql driver for the dialect must be registered by the caller, by importing modernc.org/sqlite or
+github.com/jackc/pgx/v5/stdlib. SQLite databases should be opened with _txlock=immediate so that transactions
+take the write lock up front rather than failing when they first write.
+*/
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/pkg/errors"
+)
+
+const userColumns = `tenant_id, user_id, email, version, created_at, updated_at, deleted_at`
+
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+	opts    userstore.Options
+}
+
+var _ userstore.Store = (*Store)(nil)
+
+func NewStore(db *sql.DB, dialect Dialect, opts ...userstore.Option) *Store {
+	return &Store{
+		db:      db,
+		dialect: dialect,
+		opts:    userstore.NewOptions(opts...),
+	}
+}
+
+// querier is the subset of *sql.DB and *sql.Tx used by the store
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *Store) GetByID(ctx context.Context, id string, opts ...userstore.ReadOption) (models.User, error) {
+	user, err := s.getUser(ctx, s.db, id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if user.DeletedAt != nil && !userstore.NewReadOptions(opts...).IncludeDeleted {
+		return models.User{}, userstore.ErrNotFound
+	}
+
+	return user, nil
+}
+
+func (s *Store) GetByEmail(ctx context.Context, email string, opts ...userstore.ReadOption) (models.User, error) {
+	users, err := s.queryUsers(ctx, s.db,
+		`SELECT `+userColumns+` FROM users WHERE tenant_id = ? AND email_key = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		tenant.FromContext(ctx), s.emailKey(email), s.now().Unix(),
+	)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if len(users) > 1 {
+		return models.User{}, fmt.Errorf("expected maximum of 1 records, found %d", len(users))
+	}
+
+	if len(users) == 0 {
+		return models.User{}, userstore.ErrNotFound
+	}
+
+	if users[0].DeletedAt != nil && !userstore.NewReadOptions(opts...).IncludeDeleted {
+		return models.User{}, userstore.ErrNotFound
+	}
+
+	return users[0], nil
+}
+
+/*
+List returns a page of at most limit users, excluding soft-deleted users, in order of id. Cursors are signed
+with the store's cursor key, and anything that isn't a cursor issued by a store with the same key is rejected
+with ErrInvalidCursor.
+*/
+func (s *Store) List(ctx context.Context, limit int32, cursor string) (models.UserPage, error) {
+	if len(s.opts.CursorKey) == 0 {
+		return models.UserPage{}, errors.New("a cursor key is required to list users")
+	}
+
+	if limit <= 0 {
+		limit = userstore.DefaultPageSize
+	}
+	if limit > userstore.MaxPageSize {
+		limit = userstore.MaxPageSize
+	}
+
+	after, err := s.decodeCursor(cursor)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+
+	// Fetching one extra user tells us whether there's another page
+	users, err := s.queryUsers(ctx, s.db,
+		`SELECT `+userColumns+` FROM users WHERE tenant_id = ? AND deleted_at IS NULL AND user_id > ? ORDER BY user_id LIMIT ?`,
+		tenant.FromContext(ctx), after, limit+1,
+	)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+
+	page := models.UserPage{Users: []models.User{}}
+	page.Users = append(page.Users, users[:min(int(limit), len(users))]...)
+	if len(users) > int(limit) {
+		page.Cursor = s.encodeCursor(page.Users[limit-1].UserID)
+	}
+
+	return page, nil
+}
+
+/*
+ListCreatedBetween returns a page of at most limit users created at or after from and before to, excluding
+soft-deleted users, in order of creation. Cursors are signed as List's are.
+*/
+func (s *Store) ListCreatedBetween(ctx context.Context, from time.Time, to time.Time, limit int32, cursor string) (models.UserPage, error) {
+	if len(s.opts.CursorKey) == 0 {
+		return models.UserPage{}, errors.New("a cursor key is required to list users")
+	}
+
+	if limit <= 0 {
+		limit = userstore.DefaultPageSize
+	}
+	if limit > userstore.MaxPageSize {
+		limit = userstore.MaxPageSize
+	}
+
+	afterTime, afterID, err := s.decodeCreatedCursor(cursor)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+	if cursor == "" {
+		// Every id sorts after the empty one, so this starts from the beginning of the range
+		afterTime = truncate(from)
+	}
+
+	users, err := s.queryUsers(ctx, s.db,
+		`SELECT `+userColumns+` FROM users WHERE tenant_id = ? AND deleted_at IS NULL AND created_at >= ? AND created_at < ?
+		AND (created_at > ? OR (created_at = ? AND user_id > ?)) ORDER BY created_at, user_id LIMIT ?`,
+		tenant.FromContext(ctx), truncate(from), truncate(to), afterTime, afterTime, afterID, limit+1,
+	)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+
+	page := models.UserPage{Users: []models.User{}}
+	page.Users = append(page.Users, users[:min(int(limit), len(users))]...)
+	if len(users) > int(limit) {
+		last := page.Users[limit-1]
+		page.Cursor = s.encodeCreatedCursor(last.CreatedAt, last.UserID)
+	}
+
+	return page, nil
+}
+
+/*
+Put writes the user record, provided the stored record is still at record.Version, with a zero version only
+writing new users. Like the DynamoDB store, Put does not maintain the email reservation.
+*/
+func (s *Store) Put(ctx context.Context, record models.User) (models.User, error) {
+	record.TenantID = tenant.FromContext(ctx)
+	expectedVersion := record.Version
+	now := s.now()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = now
+	}
+	record.CreatedAt = truncate(record.CreatedAt)
+	record.UpdatedAt = now
+	record.Version = expectedVersion + 1
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		if expectedVersion == 0 {
+			err := s.insertUser(ctx, tx, record)
+			if err != nil {
+				return err
+			}
+			return s.record(ctx, tx, models.HistoryPut, models.User{}, record)
+		}
+
+		current, err := s.getUser(ctx, tx, record.UserID)
+		if errors.Is(err, userstore.ErrNotFound) {
+			return userstore.ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+		err = s.updateUser(ctx, tx, record, expectedVersion)
+		if err != nil {
+			return err
+		}
+		return s.record(ctx, tx, models.HistoryPut, current, record)
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return record, nil
+}
+
+func (s *Store) Create(ctx context.Context, record models.User) (models.User, error) {
+	record.TenantID = tenant.FromContext(ctx)
+	record.Email = strings.TrimSpace(record.Email)
+	now := s.now()
+	record.Version = 1
+	record.CreatedAt = now
+	record.UpdatedAt = now
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		// Checked in the same order as the DynamoDB store's transaction reports them
+		err := s.reserve(ctx, tx, record.Email, record.UserID, nil)
+		if err != nil {
+			return err
+		}
+		err = s.insertUser(ctx, tx, record)
+		if err != nil {
+			return err
+		}
+		return s.record(ctx, tx, models.HistoryCreate, models.User{}, record)
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return record, nil
+}
+
+func (s *Store) Update(ctx context.Context, id string, changes models.UserUpdate, expectedVersion int64) (models.User, error) {
+	var updated models.User
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := s.getUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if current.DeletedAt != nil {
+			return userstore.ErrNotFound
+		}
+
+		if expectedVersion != 0 && current.Version != expectedVersion {
+			return userstore.ErrConflict
+		}
+
+		updated = current
+		if changes.Email != nil {
+			updated.Email = strings.TrimSpace(*changes.Email)
+		}
+		if updated == current {
+			return nil
+		}
+		updated.Version = current.Version + 1
+		updated.UpdatedAt = s.now()
+
+		if s.emailKey(updated.Email) != s.emailKey(current.Email) {
+			err = s.release(ctx, tx, current.Email, id)
+			if err != nil {
+				return err
+			}
+			err = s.reserve(ctx, tx, updated.Email, id, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = s.updateUser(ctx, tx, updated, current.Version)
+		if err != nil {
+			return err
+		}
+		return s.record(ctx, tx, models.HistoryUpdate, current, updated)
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return updated, nil
+}
+
+func (s *Store) Delete(ctx context.Context, id string, expectedVersion int64) (string, error) {
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := s.getUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if current.DeletedAt != nil {
+			return userstore.ErrNotFound
+		}
+
+		if expectedVersion != 0 && current.Version != expectedVersion {
+			return userstore.ErrConflict
+		}
+
+		now := s.now()
+		deleted := current
+		deleted.DeletedAt = &now
+		deleted.Version = current.Version + 1
+		deleted.UpdatedAt = now
+
+		if s.opts.DeletedEmailPolicy == userstore.HoldEmail {
+			expires := s.expiry(now)
+			err = s.reserve(ctx, tx, current.Email, id, &expires)
+			if errors.Is(err, userstore.ErrEmailTaken) {
+				// The user's email being held by someone else means something has changed underneath us
+				return userstore.ErrConflict
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			err = s.release(ctx, tx, current.Email, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = s.updateUser(ctx, tx, deleted, current.Version)
+		if err != nil {
+			return err
+		}
+		return s.record(ctx, tx, models.HistoryDelete, current, deleted)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s *Store) Restore(ctx context.Context, id string, expectedVersion int64) (models.User, error) {
+	var restored models.User
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := s.getUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if current.DeletedAt == nil {
+			return userstore.ErrNotDeleted
+		}
+
+		if expectedVersion != 0 && current.Version != expectedVersion {
+			return userstore.ErrConflict
+		}
+
+		restored = current
+		restored.DeletedAt = nil
+		restored.Version = current.Version + 1
+		restored.UpdatedAt = s.now()
+
+		err = s.reserve(ctx, tx, restored.Email, id, nil)
+		if err != nil {
+			return err
+		}
+
+		err = s.updateUser(ctx, tx, restored, current.Version)
+		if err != nil {
+			return err
+		}
+		return s.record(ctx, tx, models.HistoryRestore, current, restored)
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return restored, nil
+}
+
+func (s *Store) BatchGetByIDs(ctx context.Context, ids []string, opts ...userstore.ReadOption) ([]userstore.BatchGetResult, error) {
+	o := userstore.NewReadOptions(opts...)
+
+	found := map[string]models.User{}
+	if len(ids) > 0 {
+		args := []any{tenant.FromContext(ctx), s.now().Unix()}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+		users, err := s.queryUsers(ctx, s.db,
+			`SELECT `+userColumns+` FROM users WHERE tenant_id = ? AND (expires_at IS NULL OR expires_at > ?) AND user_id IN (`+placeholders+`)`,
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			found[user.UserID] = user
+		}
+	}
+
+	results := make([]userstore.BatchGetResult, len(ids))
+	for i, id := range ids {
+		user, ok := found[id]
+		if !ok || (user.DeletedAt != nil && !o.IncludeDeleted) {
+			results[i].Err = userstore.ErrNotFound
+			continue
+		}
+		results[i].User = user
+	}
+
+	return results, nil
+}
+
+/*
+BatchPut creates the given users along with their email reservations, reporting users whose id already exists
+with ErrConflict in the same way as the DynamoDB store. Unlike the DynamoDB store, the whole batch is written in
+a single transaction.
+*/
+func (s *Store) BatchPut(ctx context.Context, records []models.User) ([]userstore.BatchPutResult, error) {
+	results := make([]userstore.BatchPutResult, len(records))
+	now := s.now()
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		ids := map[string]bool{}
+		emails := map[string]bool{}
+		for i, record := range records {
+			record.TenantID = tenant.FromContext(ctx)
+			record.Email = strings.TrimSpace(record.Email)
+			email := s.emailKey(record.Email)
+			if ids[record.UserID] || emails[email] {
+				results[i].Err = userstore.ErrConflict
+				continue
+			}
+			ids[record.UserID] = true
+			emails[email] = true
+
+			if record.CreatedAt.IsZero() {
+				record.CreatedAt = now
+			}
+			record.CreatedAt = truncate(record.CreatedAt)
+			record.UpdatedAt = now
+			record.Version = 1
+
+			// The user is looked for first, as a reservation made for a user that can't be inserted would have to be
+			// undone, and the user may already hold it
+			_, err := s.getUser(ctx, tx, record.UserID)
+			if err == nil {
+				err = userstore.ErrConflict
+			}
+			if !errors.Is(err, userstore.ErrNotFound) {
+				results[i].Err = err
+				continue
+			}
+
+			err = s.reserve(ctx, tx, record.Email, record.UserID, nil)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			err = s.insertUser(ctx, tx, record)
+			if err != nil {
+				return err
+			}
+			err = s.record(ctx, tx, models.HistoryCreate, models.User{}, record)
+			if err != nil {
+				return err
+			}
+			results[i].User = record
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+/*
+withTx runs fn in a transaction, committing it if fn succeeds. Users and reservations whose restore window has
+passed are purged first, which is the closest equivalent to DynamoDB's TTL.
+*/
+func (s *Store) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return classifyError(err)
+	}
+	defer tx.Rollback()
+
+	now := s.now().Unix()
+	for _, table := range []string{"users", "email_reservations"} {
+		_, err = s.exec(ctx, tx, `DELETE FROM `+table+` WHERE expires_at <= ?`, now)
+		if err != nil {
+			return classifyError(err)
+		}
+	}
+
+	err = fn(tx)
+	if err != nil {
+		return classifyError(err)
+	}
+
+	return classifyError(tx.Commit())
+}
+
+// getUser returns the user with the given id, including soft-deleted users that haven't yet expired
+func (s *Store) getUser(ctx context.Context, q querier, id string) (models.User, error) {
+	users, err := s.queryUsers(ctx, q,
+		`SELECT `+userColumns+` FROM users WHERE tenant_id = ? AND user_id = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		tenant.FromContext(ctx), id, s.now().Unix(),
+	)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if len(users) == 0 {
+		return models.User{}, userstore.ErrNotFound
+	}
+
+	return users[0], nil
+}
+
+func (s *Store) queryUsers(ctx context.Context, q querier, query string, args ...any) ([]models.User, error) {
+	rows, err := q.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		var deletedAt sql.NullTime
+		err = rows.Scan(&user.TenantID, &user.UserID, &user.Email, &user.Version, &user.CreatedAt, &user.UpdatedAt, &deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		user.CreatedAt = user.CreatedAt.UTC()
+		user.UpdatedAt = user.UpdatedAt.UTC()
+		if deletedAt.Valid {
+			t := deletedAt.Time.UTC()
+			user.DeletedAt = &t
+		}
+		users = append(users, user)
+	}
+
+	return users, classifyError(rows.Err())
+}
+
+// insertUser writes a new user, returning ErrConflict if a user with the same id exists
+func (s *Store) insertUser(ctx context.Context, q querier, user models.User) error {
+	res, err := s.exec(ctx, q,
+		`INSERT INTO users (tenant_id, user_id, email, email_key, version, created_at, updated_at, deleted_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (tenant_id, user_id) DO NOTHING`,
+		s.userArgs(user)...,
+	)
+	return requireRow(res, err, userstore.ErrConflict)
+}
+
+// updateUser overwrites the stored user, returning ErrConflict unless it is still at currentVersion
+func (s *Store) updateUser(ctx context.Context, q querier, user models.User, currentVersion int64) error {
+	args := s.userArgs(user)
+	res, err := s.exec(ctx, q,
+		`UPDATE users SET email = ?, email_key = ?, version = ?, created_at = ?, updated_at = ?, deleted_at = ?, expires_at = ?
+		WHERE tenant_id = ? AND user_id = ? AND version = ?`,
+		append(args[2:], user.TenantID, user.UserID, currentVersion)...,
+	)
+	return requireRow(res, err, userstore.ErrConflict)
+}
+
+// userArgs returns the column values for the user, in the order of the users table
+func (s *Store) userArgs(user models.User) []any {
+	// Users deleted under the release policy are no longer indexed by email
+	var emailKey, deletedAt, expiresAt any
+	if user.DeletedAt == nil || s.opts.DeletedEmailPolicy != userstore.ReleaseEmail {
+		emailKey = s.emailKey(user.Email)
+	}
+	if user.DeletedAt != nil {
+		deletedAt = *user.DeletedAt
+		expiresAt = s.expiry(*user.DeletedAt)
+	}
+	return []any{user.TenantID, user.UserID, user.Email, emailKey, user.Version, user.CreatedAt, user.UpdatedAt, deletedAt, expiresAt}
+}
+
+/*
+reserve reserves the email for the user, replacing any reservation they already hold. ErrEmailTaken is returned
+if the email is reserved by someone else.
+*/
+func (s *Store) reserve(ctx context.Context, q querier, email string, userID string, expires *int64) error {
+	var expiresAt any
+	if expires != nil {
+		expiresAt = *expires
+	}
+	res, err := s.exec(ctx, q,
+		`INSERT INTO email_reservations (tenant_id, email_key, user_id, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (tenant_id, email_key) DO UPDATE SET expires_at = excluded.expires_at
+		WHERE email_reservations.user_id = excluded.user_id`,
+		tenant.FromContext(ctx), s.emailKey(email), userID, expiresAt,
+	)
+	return requireRow(res, err, userstore.ErrEmailTaken)
+}
+
+// release frees the user's reservation of the email, returning ErrConflict if it is reserved by someone else
+func (s *Store) release(ctx context.Context, q querier, email string, userID string) error {
+	_, err := s.exec(ctx, q, `DELETE FROM email_reservations WHERE tenant_id = ? AND email_key = ? AND user_id = ?`,
+		tenant.FromContext(ctx), s.emailKey(email), userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	var held int
+	err = q.QueryRowContext(ctx, s.dialect.rebind(`SELECT COUNT(*) FROM email_reservations WHERE tenant_id = ? AND email_key = ?`),
+		tenant.FromContext(ctx), s.emailKey(email),
+	).Scan(&held)
+	if err != nil {
+		return err
+	}
+	if held > 0 {
+		return userstore.ErrConflict
+	}
+	return nil
+}
+
+func (s *Store) exec(ctx context.Context, q querier, query string, args ...any) (sql.Result, error) {
+	return q.ExecContext(ctx, s.dialect.rebind(query), args...)
+}
+
+// requireRow returns kind if the statement didn't affect any rows
+func requireRow(res sql.Result, err error, kind error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return kind
+	}
+	return nil
+}
+
+// now returns the store's current time, truncated to the microsecond precision Postgres stores
+func (s *Store) now() time.Time {
+	return truncate(s.opts.Now())
+}
+
+func truncate(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// expiry returns when a user deleted at the given time is purged, in epoch seconds
+func (s *Store) expiry(deletedAt time.Time) int64 {
+	return deletedAt.Add(s.opts.RestoreWindow).Unix()
+}
+
+func (s *Store) emailKey(email string) string {
+	return s.opts.EmailPolicy.Normalize(email)
+}
+
+func (s *Store) encodeCursor(lastID string) string {
+	return userstore.SignCursor(s.opts.CursorKey, []byte("user/"+lastID))
+}
+
+func (s *Store) decodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	b, err := userstore.VerifyCursor(s.opts.CursorKey, cursor)
+	if err != nil {
+		return "", err
+	}
+	id, ok := strings.CutPrefix(string(b), "user/")
+	if !ok {
+		return "", userstore.ErrInvalidCursor
+	}
+	return id, nil
+}
+
+func (s *Store) encodeCreatedCursor(createdAt time.Time, lastID string) string {
+	return userstore.SignCursor(s.opts.CursorKey, []byte("created/"+createdAt.UTC().Format(time.RFC3339Nano)+"/"+lastID))
+}
+
+func (s *Store) decodeCreatedCursor(cursor string) (time.Time, string, error) {
+	if cursor == "" {
+		return time.Time{}, "", nil
+	}
+	b, err := userstore.VerifyCursor(s.opts.CursorKey, cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	rest, ok := strings.CutPrefix(string(b), "created/")
+	if !ok {
+		return time.Time{}, "", userstore.ErrInvalidCursor
+	}
+	at, id, ok := strings.Cut(rest, "/")
+	if !ok {
+		return time.Time{}, "", userstore.ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return time.Time{}, "", userstore.ErrInvalidCursor
+	}
+	return truncate(createdAt), id, nil
+}
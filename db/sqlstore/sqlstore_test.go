@@ -0,0 +1,65 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/storetest"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func NewSQLiteStore(t *testing.T, opts ...userstore.Option) *Store {
+	opts = append([]userstore.Option{userstore.WithCursorKey([]byte("test-cursor-key"))}, opts...)
+	dsn := "file:" + filepath.Join(t.TempDir(), "users.db") + "?_txlock=immediate&_pragma=busy_timeout(5000)"
+	db, err := sql.Open(SQLite.DriverName(), dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	store := NewStore(db, SQLite, opts...)
+	require.NoError(t, store.Migrate(context.Background()))
+	return store
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, opts ...userstore.Option) userstore.Store {
+		return NewSQLiteStore(t, opts...)
+	})
+}
+
+// Runs against a real Postgres database when POSTGRES_DSN is set, which is wiped before each test
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN not set")
+	}
+
+	storetest.Run(t, func(t *testing.T, opts ...userstore.Option) userstore.Store {
+		db, err := sql.Open(Postgres.DriverName(), dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+
+		_, err = db.Exec(`DROP TABLE IF EXISTS users, email_reservations, user_history, schema_migrations`)
+		require.NoError(t, err)
+
+		opts = append([]userstore.Option{userstore.WithCursorKey([]byte("test-cursor-key"))}, opts...)
+		store := NewStore(db, Postgres, opts...)
+		require.NoError(t, store.Migrate(context.Background()))
+		return store
+	})
+}
+
+func TestMigrateTwice(t *testing.T) {
+	store := NewSQLiteStore(t)
+	require.NoError(t, store.Migrate(context.Background()))
+}
+
+func TestRebind(t *testing.T) {
+	require.Equal(t, "a = $1 AND b = $2", Postgres.rebind("a = ? AND b = ?"))
+	require.Equal(t, "a = ? AND b = ?", SQLite.rebind("a = ? AND b = ?"))
+}
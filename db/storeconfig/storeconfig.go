@@ -0,0 +1,216 @@
+/*
+Package storeconfig picks the user store backend at startup, so the same lambdas can run against DynamoDB or a
+SQL database depending on how they are deployed.
+*/
+package storeconfig
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/db/sqlstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/pii"
+	"github.com/pkg/errors"
+
+	// Registers the drivers for the SQL backends
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// BackendEnv names the environment variable holding the backend, one of dynamodb (the default), sqlite or postgres
+	BackendEnv = "USER_STORE_BACKEND"
+	// DSNEnv names the environment variable holding the data source name for the SQL backends
+	DSNEnv = "USER_STORE_DSN"
+	// TableNameEnv names the environment variable holding the DynamoDB table name
+	TableNameEnv = "USER_TABLE_NAME"
+	// OutboxEnv names the environment variable that, set to true, makes the DynamoDB store write an outbox
+	OutboxEnv = "USER_STORE_OUTBOX"
+	// PIIKeyARNEnv names the environment variable holding the ARN of the KMS key that personal data is encrypted
+	// under, which enables encryption in the DynamoDB store
+	PIIKeyARNEnv = "PII_KMS_KEY_ARN"
+	// PIIIndexKeySecretEnv names the environment variable holding the name of the secret keying the blind index,
+	// which is required alongside the KMS key
+	PIIIndexKeySecretEnv = "PII_INDEX_KEY_SECRET_NAME"
+	// PIIKeyFileEnv names the environment variable holding the path of a local key file to encrypt personal data
+	// with instead of KMS, for local development
+	PIIKeyFileEnv = "PII_KEY_FILE"
+
+	DefaultTableName = schema.TableName
+)
+
+type Config struct {
+	Backend           string
+	DSN               string
+	TableName         string
+	Outbox            bool
+	PIIKeyARN         string
+	PIIIndexKeySecret string
+	PIIKeyFile        string
+}
+
+// FromEnv reads the store configuration from the environment
+func FromEnv() Config {
+	c := Config{
+		Backend:   strings.ToLower(os.Getenv(BackendEnv)),
+		DSN:       os.Getenv(DSNEnv),
+		TableName: os.Getenv(TableNameEnv),
+		Outbox:    strings.EqualFold(os.Getenv(OutboxEnv), "true"),
+
+		PIIKeyARN:         os.Getenv(PIIKeyARNEnv),
+		PIIIndexKeySecret: os.Getenv(PIIIndexKeySecretEnv),
+		PIIKeyFile:        os.Getenv(PIIKeyFileEnv),
+	}
+	if c.Backend == "" {
+		c.Backend = "dynamodb"
+	}
+	if c.TableName == "" {
+		c.TableName = DefaultTableName
+	}
+	return c
+}
+
+var (
+	mu  sync.Mutex
+	dbs = map[string]*sql.DB{}
+
+	encryption = map[string]userstore.Option{}
+)
+
+/*
+Open returns the store described by the config. SQL databases are opened and migrated the first time they are
+used, and their connection pools are reused by later calls for the lifetime of the process.
+*/
+func Open(ctx context.Context, c Config, sdkConfig aws.Config, opts ...userstore.Option) (userstore.Store, error) {
+	if c.Backend == "dynamodb" {
+		storeOpts, err := StoreOptions(ctx, c, sdkConfig)
+		if err != nil {
+			return nil, err
+		}
+		return userstore.NewUserStore(dynamodb.NewFromConfig(sdkConfig), c.TableName, append(opts, storeOpts...)...), nil
+	}
+
+	dialect, err := sqlstore.ParseDialect(c.Backend)
+	if err != nil {
+		return nil, err
+	}
+	if c.DSN == "" {
+		return nil, fmt.Errorf("%s must be set for the %s backend", DSNEnv, dialect)
+	}
+	// The SQL stores neither encrypt personal data nor write an outbox, so refuse to quietly run without them
+	if unsupported := dynamoOnly(c); len(unsupported) > 0 {
+		return nil, fmt.Errorf("%s can't be set for the %s backend", strings.Join(unsupported, " and "), dialect)
+	}
+
+	db, err := openDB(ctx, dialect, c.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlstore.NewStore(db, dialect, opts...), nil
+}
+
+// dynamoOnly returns the environment variables the config sets that only the DynamoDB store supports
+func dynamoOnly(c Config) []string {
+	set := []string{}
+	if c.Outbox {
+		set = append(set, OutboxEnv)
+	}
+	if c.PIIKeyARN != "" {
+		set = append(set, PIIKeyARNEnv)
+	}
+	if c.PIIKeyFile != "" {
+		set = append(set, PIIKeyFileEnv)
+	}
+	return set
+}
+
+/*
+StoreOptions returns the options the config sets on the DynamoDB store, for code that builds the store itself.
+Ciphers are created the first time they are used, and are reused by later calls for the lifetime of the process
+so that their data keys are too.
+*/
+func StoreOptions(ctx context.Context, c Config, sdkConfig aws.Config) ([]userstore.Option, error) {
+	opts := []userstore.Option{}
+	if c.Outbox {
+		opts = append(opts, userstore.WithOutbox())
+	}
+
+	if c.PIIKeyARN != "" || c.PIIKeyFile != "" {
+		opt, err := encryptionOption(ctx, c, sdkConfig)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+
+	return opts, nil
+}
+
+func encryptionOption(ctx context.Context, c Config, sdkConfig aws.Config) (userstore.Option, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := c.PIIKeyARN + " " + c.PIIKeyFile
+	if opt, ok := encryption[key]; ok {
+		return opt, nil
+	}
+
+	var opt userstore.Option
+	if c.PIIKeyFile != "" {
+		f, err := pii.LoadKeyFile(c.PIIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opt = userstore.WithEncryption(pii.NewCipher(f.Provider()), pii.NewBlindIndex(f.IndexKey))
+	} else {
+		if c.PIIIndexKeySecret == "" {
+			return nil, fmt.Errorf("%s must be set alongside %s", PIIIndexKeySecretEnv, PIIKeyARNEnv)
+		}
+		out, err := secretsmanager.NewFromConfig(sdkConfig).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: &c.PIIIndexKeySecret,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get blind index key")
+		}
+		keys := pii.NewKMSKeyProvider(kms.NewFromConfig(sdkConfig), c.PIIKeyARN)
+		opt = userstore.WithEncryption(pii.NewCipher(keys), pii.NewBlindIndex([]byte(aws.ToString(out.SecretString))))
+	}
+
+	encryption[key] = opt
+	return opt, nil
+}
+
+func openDB(ctx context.Context, dialect sqlstore.Dialect, dsn string) (*sql.DB, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := dialect.String() + " " + dsn
+	if db, ok := dbs[key]; ok {
+		return db, nil
+	}
+
+	db, err := sql.Open(dialect.DriverName(), dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s database", dialect)
+	}
+
+	err = sqlstore.NewStore(db, dialect).Migrate(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	dbs[key] = db
+	return db, nil
+}
@@ -0,0 +1,47 @@
+package storeconfig
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRejectsDynamoOnlyConfig(t *testing.T) {
+	tests := map[string]struct {
+		config   Config
+		expected string
+	}{
+		"outbox": {
+			config:   Config{Outbox: true},
+			expected: OutboxEnv,
+		},
+		"kms key": {
+			config:   Config{PIIKeyARN: "arn:aws:kms:eu-west-2:123456789012:key/test"},
+			expected: PIIKeyARNEnv,
+		},
+		"key file": {
+			config:   Config{PIIKeyFile: "pii.key"},
+			expected: PIIKeyFileEnv,
+		},
+		"outbox and key file": {
+			config:   Config{Outbox: true, PIIKeyFile: "pii.key"},
+			expected: OutboxEnv + " and " + PIIKeyFileEnv,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := tt.config
+			c.Backend = "sqlite"
+			c.DSN = "file::memory:"
+
+			_, err := Open(context.Background(), c, aws.Config{})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+			assert.Contains(t, err.Error(), "sqlite")
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package userstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/pkg/errors"
+)
+
+type CreatedIndexOptions struct {
+	// DryRun reports how many users would be indexed without writing anything
+	DryRun bool
+	// WritesPerSecond limits how quickly users are indexed, to leave capacity for live traffic. Zero means no
+	// limit.
+	WritesPerSecond float64
+}
+
+type CreatedIndexReport struct {
+	// Scanned is the number of users examined
+	Scanned int
+	// Unindexed is the number of users missing from the creation index, whether or not they were indexed
+	Unindexed int
+	// Indexed is the number of users added to the creation index, which is always zero for a dry run
+	Indexed int
+	// Failed holds the users that couldn't be indexed, by partition key
+	Failed map[string]error
+}
+
+/*
+BackfillCreatedIndex scans the table, across every tenant, for users that haven't been deleted but are missing
+from the creation index, and adds them to it. Users are only indexed when they are written, so those written
+before the index was added can't be listed by creation date until they are backfilled.
+
+Indexing doesn't change the user, so users keep their versions and no history is recorded. Each user is updated
+on its own, conditional on it not having been deleted since it was scanned, so the backfill can be run
+alongside live traffic and safely re-run.
+*/
+func (store UserStore) BackfillCreatedIndex(ctx context.Context, opts CreatedIndexOptions) (CreatedIndexReport, error) {
+	report := CreatedIndexReport{Failed: map[string]error{}}
+
+	var throttle <-chan time.Time
+	if opts.WritesPerSecond > 0 && !opts.DryRun {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.WritesPerSecond))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	err := store.scanItems(ctx, func(item map[string]types.AttributeValue) error {
+		pk, _ := item[PKKey].(*types.AttributeValueMemberS)
+		sk, _ := item[SKKey].(*types.AttributeValueMemberS)
+		if pk == nil || sk == nil || sk.Value != schema.UserSK {
+			return nil
+		}
+		tenantID, _, ok := schema.ParseUserPK(pk.Value)
+		if !ok {
+			return nil
+		}
+		report.Scanned++
+
+		// Only the fields the index is built from are read, so that the backfill doesn't need the PII keys
+		var user struct {
+			UserID    string     `dynamodbav:"userID"`
+			CreatedAt time.Time  `dynamodbav:"createdAt"`
+			DeletedAt *time.Time `dynamodbav:"deletedAt,omitempty"`
+		}
+		err := attributevalue.UnmarshalMap(item, &user)
+		if err != nil {
+			report.Failed[pk.Value] = errors.Wrap(err, "an error ocurred unmarshaling the user")
+			return nil
+		}
+
+		bucket := &types.AttributeValueMemberS{Value: schema.CreatedBucket(tenantID, user.CreatedAt)}
+		order := &types.AttributeValueMemberS{Value: schema.CreatedOrderKey(user.CreatedAt, user.UserID)}
+		if user.DeletedAt != nil || (equalS(item[GSI3Key], bucket) && equalS(item[schema.GSI3SortKey], order)) {
+			return nil
+		}
+		report.Unindexed++
+		if opts.DryRun {
+			return nil
+		}
+
+		if throttle != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-throttle:
+			}
+		}
+
+		_, err = store.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:           aws.String(store.tableName),
+			Key:                 map[string]types.AttributeValue{PKKey: pk, SKKey: sk},
+			UpdateExpression:    aws.String("SET #gsi3 = :bucket, #gsi3sk = :order"),
+			ConditionExpression: aws.String("attribute_exists(#pk) AND attribute_not_exists(#deletedAt)"),
+			ExpressionAttributeNames: map[string]string{
+				"#pk":        PKKey,
+				"#gsi3":      GSI3Key,
+				"#gsi3sk":    schema.GSI3SortKey,
+				"#deletedAt": "deletedAt",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":bucket": bucket,
+				":order":  order,
+			},
+		})
+		// A failed condition means the user was deleted since it was scanned, so shouldn't be indexed
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil
+		}
+		if err != nil {
+			report.Failed[pk.Value] = classifyError(err)
+			return nil
+		}
+		report.Indexed++
+		return nil
+	})
+	return report, err
+}
+
+// equalS reports whether the attribute is the given string
+func equalS(v types.AttributeValue, s *types.AttributeValueMemberS) bool {
+	actual, ok := v.(*types.AttributeValueMemberS)
+	return ok && actual.Value == s.Value
+}
@@ -0,0 +1,205 @@
+package userstore
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/models"
+)
+
+const (
+	// DynamoDB's limit on the number of items in a single BatchGetItem request
+	batchGetLimit = 100
+	// The number of users BatchPut writes at once, each in a transaction of its own
+	batchWriteConcurrency = 10
+)
+
+// BatchGetResult is the outcome of fetching a single user in a batch
+type BatchGetResult struct {
+	User models.User
+	Err  error
+}
+
+// BatchPutResult is the outcome of writing a single user in a batch
+type BatchPutResult struct {
+	User models.User
+	Err  error
+}
+
+/*
+BatchGetByIDs fetches the users with the given ids, returning a result for each id in the same order. Missing
+users have ErrNotFound as their result's error, as do soft-deleted users unless the IncludeDeleted option is
+given. Keys that DynamoDB leaves unprocessed are retried with backoff, and are reported with ErrThrottled if
+they still can't be fetched. The returned error is only set if the batch as a whole failed.
+*/
+func (store UserStore) BatchGetByIDs(ctx context.Context, ids []string, opts ...ReadOption) ([]BatchGetResult, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	o := NewReadOptions(opts...)
+
+	keys := make([]map[string]types.AttributeValue, 0, len(ids))
+	seen := map[string]bool{}
+	for _, id := range ids {
+		// Duplicate keys are rejected by DynamoDB
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		keys = append(keys, store.getUserKey(ctx, id))
+	}
+
+	items, err := store.batchGet(ctx, keys, true)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchGetResult, len(ids))
+	for i, id := range ids {
+		item, ok := items[store.getUserPK(ctx, id)]
+		if !ok {
+			results[i].Err = ErrThrottled
+			continue
+		}
+		if item == nil {
+			results[i].Err = ErrNotFound
+			continue
+		}
+
+		user, err := store.UnmarshalUser(ctx, item)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		if user.DeletedAt != nil && !o.IncludeDeleted {
+			results[i].Err = ErrNotFound
+			continue
+		}
+		results[i].User = user
+	}
+
+	return results, nil
+}
+
+/*
+batchGet fetches the given keys in chunks, retrying unprocessed keys. The returned map is keyed on the items'
+partition keys, with a nil item for keys that don't exist. Keys that were never processed are left out.
+*/
+func (store UserStore) batchGet(ctx context.Context, keys []map[string]types.AttributeValue, consistent bool) (map[string]map[string]types.AttributeValue, error) {
+	items := map[string]map[string]types.AttributeValue{}
+	for start := 0; start < len(keys); start += batchGetLimit {
+		chunk := keys[start:min(start+batchGetLimit, len(keys))]
+		for _, key := range chunk {
+			items[key[PKKey].(*types.AttributeValueMemberS).Value] = nil
+		}
+
+		pending := chunk
+		for attempt := 0; len(pending) > 0 && attempt < max(store.retry.MaxAttempts, 1); attempt++ {
+			if attempt > 0 {
+				err := sleep(ctx, store.retry.backoff(attempt))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			out, err := store.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: map[string]types.KeysAndAttributes{
+					store.tableName: {
+						Keys:           pending,
+						ConsistentRead: aws.Bool(consistent),
+					},
+				},
+			})
+			if isThrottlingError(err) {
+				continue
+			}
+			if err != nil {
+				return nil, classifyError(err)
+			}
+
+			for _, item := range out.Responses[store.tableName] {
+				items[item[PKKey].(*types.AttributeValueMemberS).Value] = item
+			}
+			pending = out.UnprocessedKeys[store.tableName].Keys
+		}
+
+		for _, key := range pending {
+			delete(items, key[PKKey].(*types.AttributeValueMemberS).Value)
+		}
+	}
+
+	return items, nil
+}
+
+/*
+BatchPut creates the given users along with their email reservations, returning a result for each user in the
+same order. It is intended for bulk imports, so users may be given with their existing ids and creation times.
+Each user is written in a transaction of its own, as with Create, so users whose id already exists, even if they
+have been deleted, have ErrConflict as their result's error rather than being overwritten, and users whose email
+is reserved by someone else have ErrEmailTaken. Users that repeat an id or email from earlier in the batch have
+ErrConflict as their error. Up to batchWriteConcurrency users are written at once.
+*/
+func (store UserStore) BatchPut(ctx context.Context, records []models.User) ([]BatchPutResult, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	results := make([]BatchPutResult, len(records))
+	now := store.now().UTC()
+
+	ids := map[string]bool{}
+	emails := map[string]bool{}
+	for i, record := range records {
+		email := store.getEmailPK(ctx, strings.TrimSpace(record.Email))
+		if ids[record.UserID] || emails[email] {
+			results[i].Err = ErrConflict
+			continue
+		}
+		ids[record.UserID] = true
+		emails[email] = true
+
+		if record.CreatedAt.IsZero() {
+			record.CreatedAt = now
+		}
+		record.UpdatedAt = now
+		results[i].User = record
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchWriteConcurrency)
+	for i := range results {
+		if results[i].Err != nil {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i].User, results[i].Err = store.create(ctx, results[i].User)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range results {
+		if results[i].Err != nil {
+			results[i].User = models.User{}
+		}
+	}
+
+	return results, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
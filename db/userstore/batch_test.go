@@ -0,0 +1,78 @@
+package userstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchPutAndGet(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	// Enough users to need two get requests
+	records := []models.User{}
+	ids := []string{}
+	for i := 0; i < 130; i++ {
+		id := uuid.New().String()
+		records = append(records, models.User{UserID: id, Email: fmt.Sprintf("batch%d@gmail.com", i)})
+		ids = append(ids, id)
+	}
+
+	putResults, err := store.BatchPut(ctx, records)
+	require.NoError(t, err)
+	require.Len(t, putResults, len(records))
+	for i, r := range putResults {
+		require.NoError(t, r.Err)
+		assert.Equal(t, records[i].UserID, r.User.UserID)
+		assert.Equal(t, int64(1), r.User.Version)
+	}
+
+	// Results should be in the order requested, including for missing users
+	ids = append([]string{"nonexistent"}, ids...)
+	getResults, err := store.BatchGetByIDs(ctx, ids)
+	require.NoError(t, err)
+	require.Len(t, getResults, len(ids))
+	assert.ErrorIs(t, getResults[0].Err, ErrNotFound)
+	for i, r := range getResults[1:] {
+		require.NoError(t, r.Err)
+		assert.Equal(t, records[i].Email, r.User.Email)
+	}
+}
+
+func TestBatchPutConflicts(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	id := uuid.New().String()
+
+	results, err := store.BatchPut(ctx, []models.User{
+		{UserID: id, Email: "first@gmail.com"},
+		{UserID: id, Email: "second@gmail.com"},
+		{UserID: uuid.New().String(), Email: "first@gmail.com"},
+		{UserID: uuid.New().String(), Email: "benk13@gmail.com"},
+		{UserID: "12345", Email: "replaced@gmail.com"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, ErrConflict)
+	assert.ErrorIs(t, results[2].Err, ErrConflict)
+	assert.ErrorIs(t, results[3].Err, ErrEmailTaken)
+	// The seeded user must not be overwritten
+	assert.ErrorIs(t, results[4].Err, ErrConflict)
+	r, err := store.GetByID(ctx, "12345")
+	require.NoError(t, err)
+	assert.Equal(t, "benk13@gmail.com", r.Email)
+	_, err = store.GetByEmail(ctx, "replaced@gmail.com")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	r, err = store.GetByEmail(ctx, "first@gmail.com")
+	require.NoError(t, err)
+	assert.Equal(t, id, r.UserID)
+}
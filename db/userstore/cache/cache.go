@@ -0,0 +1,427 @@
+/*
+Package cache is a read-through cache for user lookups, wrapping any userstore.Store. Users are cached by tenant
+and id for a fixed TTL, misses are cached too so that repeated lookups of missing users don't reach the store,
+and the least recently used entries are evicted once the cache is full.
+
+Writes made through a wrapped store invalidate the users they touch, but writes made elsewhere, such as by
+another Lambda instance, are only seen once the cached entry expires. That includes every write when each
+operation has a Lambda of its own, as the get Lambda never sees the others' writes, so the cache is only
+consistent with writes where reads and writes share a store, as in the router Lambda and the devserver. Reads
+that can't tolerate stale users should pass userstore.ConsistentRead, which bypasses the cache.
+*/
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTTL is short, as writes made elsewhere are only seen once entries expire
+	DefaultTTL        = 5 * time.Second
+	DefaultMaxEntries = 1000
+
+	// TTLEnv names the environment variable that enables caching, holding a duration such as 5s
+	TTLEnv = "USER_CACHE_TTL"
+	// NegativeTTLEnv names the environment variable holding how long misses are cached for
+	NegativeTTLEnv = "USER_CACHE_NEGATIVE_TTL"
+	// MaxEntriesEnv names the environment variable holding the maximum number of cached users
+	MaxEntriesEnv = "USER_CACHE_MAX_ENTRIES"
+)
+
+type Options struct {
+	// TTL is how long found users are cached for
+	TTL time.Duration
+	// NegativeTTL is how long misses are cached for
+	NegativeTTL time.Duration
+	// MaxEntries bounds the number of cached users and misses
+	MaxEntries int
+	Now        func() time.Time
+}
+
+type Option func(*Options)
+
+func WithTTL(d time.Duration) Option {
+	return func(o *Options) {
+		o.TTL = d
+	}
+}
+
+func WithNegativeTTL(d time.Duration) Option {
+	return func(o *Options) {
+		o.NegativeTTL = d
+	}
+}
+
+func WithMaxEntries(n int) Option {
+	return func(o *Options) {
+		o.MaxEntries = n
+	}
+}
+
+// WithClock sets the clock used to expire entries, which is mostly useful in tests
+func WithClock(now func() time.Time) Option {
+	return func(o *Options) {
+		o.Now = now
+	}
+}
+
+// Stats counts what the cache has done since it was created
+type Stats struct {
+	Hits         int64
+	NegativeHits int64
+	Misses       int64
+	Evictions    int64
+}
+
+type entry struct {
+	key     string
+	user    models.User
+	found   bool
+	expires time.Time
+}
+
+/*
+Cache holds cached users, and can be shared between any number of wrapped stores, which is how it outlives the
+store that is created for each Lambda invocation.
+*/
+type Cache struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// generation is bumped by every invalidation, so that reads which raced with a write don't cache what they
+	// read
+	generation uint64
+
+	hits         atomic.Int64
+	negativeHits atomic.Int64
+	misses       atomic.Int64
+	evictions    atomic.Int64
+}
+
+func New(opts ...Option) *Cache {
+	o := Options{
+		TTL:        DefaultTTL,
+		MaxEntries: DefaultMaxEntries,
+		Now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.NegativeTTL == 0 {
+		o.NegativeTTL = o.TTL
+	}
+
+	return &Cache{
+		opts:    o,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// NewFromEnv returns a cache configured from the environment, or nil if caching hasn't been enabled
+func NewFromEnv() (*Cache, error) {
+	if os.Getenv(TTLEnv) == "" {
+		return nil, nil
+	}
+
+	opts := []Option{}
+	ttl, err := time.ParseDuration(os.Getenv(TTLEnv))
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid %s %q", TTLEnv, os.Getenv(TTLEnv))
+	}
+	opts = append(opts, WithTTL(ttl))
+
+	if v := os.Getenv(NegativeTTLEnv); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", NegativeTTLEnv, v)
+		}
+		opts = append(opts, WithNegativeTTL(d))
+	}
+
+	if v := os.Getenv(MaxEntriesEnv); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", MaxEntriesEnv, v)
+		}
+		opts = append(opts, WithMaxEntries(n))
+	}
+
+	return New(opts...), nil
+}
+
+// Stats returns the cache's counters
+func (c *Cache) Stats() Stats {
+	return Stats{
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+	}
+}
+
+// Len returns the number of cached entries, including expired entries that haven't yet been evicted
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Invalidate removes the users with the given ids, in the tenant carried by the context, from the cache
+func (c *Cache) Invalidate(ctx context.Context, ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, id := range ids {
+		key := key(ctx, id)
+		if el, ok := c.entries[key]; ok {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// key returns what the user with the given id, in the tenant carried by the context, is cached under
+func key(ctx context.Context, id string) string {
+	return tenant.FromContext(ctx) + "/" + id
+}
+
+// Wrap returns a store that reads through the cache to the given store
+func (c *Cache) Wrap(store userstore.Store) *Store {
+	return &Store{store: store, cache: c}
+}
+
+// get returns the cached entry for the key, counting the lookup as a hit or a miss
+func (c *Cache) get(key string) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if ok && !c.opts.Now().Before(el.Value.(entry).expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		c.misses.Add(1)
+		return entry{}, false
+	}
+
+	c.lru.MoveToFront(el)
+	e := el.Value.(entry)
+	if e.found {
+		c.hits.Add(1)
+	} else {
+		c.negativeHits.Add(1)
+	}
+	return e, true
+}
+
+func (c *Cache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// set caches the user, or a miss if found is false, unless the cache has been invalidated since generation
+func (c *Cache) set(generation uint64, key string, user models.User, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	ttl := c.opts.TTL
+	if !found {
+		ttl = c.opts.NegativeTTL
+	}
+	e := entry{key: key, user: user, found: found, expires: c.opts.Now().Add(ttl)}
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.opts.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(entry).key)
+		c.evictions.Add(1)
+	}
+}
+
+// Store is a userstore.Store that reads users through a Cache
+type Store struct {
+	store userstore.Store
+	cache *Cache
+}
+
+var _ userstore.Store = (*Store)(nil)
+
+// GetByID returns the cached user if there is one, unless the ConsistentRead option is given
+func (s *Store) GetByID(ctx context.Context, id string, opts ...userstore.ReadOption) (models.User, error) {
+	o := userstore.NewReadOptions(opts...)
+
+	if !o.ConsistentRead {
+		if e, ok := s.cache.get(key(ctx, id)); ok {
+			return visible(e.user, e.found, o)
+		}
+	}
+
+	// Deleted users are cached too, and hidden on the way out, so the same entry serves every read
+	generation := s.cache.currentGeneration()
+	user, err := s.store.GetByID(ctx, id, withDeleted(opts)...)
+	if errors.Is(err, userstore.ErrNotFound) {
+		s.cache.set(generation, key(ctx, id), models.User{}, false)
+		return models.User{}, err
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	s.cache.set(generation, key(ctx, id), user, true)
+	return visible(user, true, o)
+}
+
+// GetByEmail always reads from the underlying store, but caches the user it finds by id
+func (s *Store) GetByEmail(ctx context.Context, email string, opts ...userstore.ReadOption) (models.User, error) {
+	generation := s.cache.currentGeneration()
+	user, err := s.store.GetByEmail(ctx, email, opts...)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	s.cache.set(generation, key(ctx, user.UserID), user, true)
+	return user, nil
+}
+
+func (s *Store) List(ctx context.Context, limit int32, cursor string) (models.UserPage, error) {
+	return s.store.List(ctx, limit, cursor)
+}
+
+func (s *Store) ListCreatedBetween(ctx context.Context, from time.Time, to time.Time, limit int32, cursor string) (models.UserPage, error) {
+	return s.store.ListCreatedBetween(ctx, from, to, limit, cursor)
+}
+
+// History is not cached, as entries are only of interest to the occasional audit
+func (s *Store) History(ctx context.Context, id string, limit int32, cursor string) (models.HistoryPage, error) {
+	return s.store.History(ctx, id, limit, cursor)
+}
+
+// BatchGetByIDs returns cached users where it can, fetching the rest from the underlying store in one batch
+func (s *Store) BatchGetByIDs(ctx context.Context, ids []string, opts ...userstore.ReadOption) ([]userstore.BatchGetResult, error) {
+	o := userstore.NewReadOptions(opts...)
+
+	results := make([]userstore.BatchGetResult, len(ids))
+	pending := map[string][]int{}
+	missing := []string{}
+	for i, id := range ids {
+		if indexes, ok := pending[id]; ok {
+			pending[id] = append(indexes, i)
+			continue
+		}
+		if !o.ConsistentRead {
+			if e, ok := s.cache.get(key(ctx, id)); ok {
+				results[i].User, results[i].Err = visible(e.user, e.found, o)
+				continue
+			}
+		}
+		pending[id] = []int{i}
+		missing = append(missing, id)
+	}
+
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	generation := s.cache.currentGeneration()
+	fetched, err := s.store.BatchGetByIDs(ctx, missing, withDeleted(opts)...)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, id := range missing {
+		r := fetched[j]
+		switch {
+		case r.Err == nil:
+			s.cache.set(generation, key(ctx, id), r.User, true)
+		case errors.Is(r.Err, userstore.ErrNotFound):
+			s.cache.set(generation, key(ctx, id), models.User{}, false)
+		}
+
+		for _, i := range pending[id] {
+			if r.Err != nil {
+				results[i].Err = r.Err
+				continue
+			}
+			results[i].User, results[i].Err = visible(r.User, true, o)
+		}
+	}
+
+	return results, nil
+}
+
+func (s *Store) Put(ctx context.Context, record models.User) (models.User, error) {
+	defer s.cache.Invalidate(ctx, record.UserID)
+	return s.store.Put(ctx, record)
+}
+
+func (s *Store) Create(ctx context.Context, record models.User) (models.User, error) {
+	defer s.cache.Invalidate(ctx, record.UserID)
+	return s.store.Create(ctx, record)
+}
+
+func (s *Store) Update(ctx context.Context, id string, changes models.UserUpdate, expectedVersion int64) (models.User, error) {
+	defer s.cache.Invalidate(ctx, id)
+	return s.store.Update(ctx, id, changes, expectedVersion)
+}
+
+func (s *Store) Delete(ctx context.Context, id string, expectedVersion int64) (string, error) {
+	defer s.cache.Invalidate(ctx, id)
+	return s.store.Delete(ctx, id, expectedVersion)
+}
+
+func (s *Store) Restore(ctx context.Context, id string, expectedVersion int64) (models.User, error) {
+	defer s.cache.Invalidate(ctx, id)
+	return s.store.Restore(ctx, id, expectedVersion)
+}
+
+func (s *Store) BatchPut(ctx context.Context, records []models.User) ([]userstore.BatchPutResult, error) {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.UserID
+	}
+	defer s.cache.Invalidate(ctx, ids...)
+	return s.store.BatchPut(ctx, records)
+}
+
+// visible applies the read options to a cached or freshly read user
+func visible(user models.User, found bool, o userstore.ReadOptions) (models.User, error) {
+	if !found || (user.DeletedAt != nil && !o.IncludeDeleted) {
+		return models.User{}, userstore.ErrNotFound
+	}
+	return user, nil
+}
+
+// withDeleted adds IncludeDeleted to the options without modifying the caller's slice
+func withDeleted(opts []userstore.ReadOption) []userstore.ReadOption {
+	return append(append([]userstore.ReadOption{}, opts...), userstore.IncludeDeleted())
+}
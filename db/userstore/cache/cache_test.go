@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/memstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/storetest"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Caching must not change what the store returns
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, opts ...userstore.Option) userstore.Store {
+		opts = append([]userstore.Option{userstore.WithCursorKey([]byte("test-cursor-key"))}, opts...)
+		return New(WithTTL(time.Hour)).Wrap(memstore.NewStore(opts...))
+	})
+}
+
+func TestGetByIDCaches(t *testing.T) {
+	ctx := context.Background()
+	backing := memstore.NewStore()
+	c := New()
+	store := c.Wrap(backing)
+
+	u, err := store.Create(ctx, models.User{UserID: "1", Email: "cached@example.com"})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		r, err := store.GetByID(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, u, r)
+	}
+	assert.Equal(t, Stats{Hits: 2, Misses: 1}, c.Stats())
+
+	// Writes made behind the cache's back aren't seen until the entry expires, unless the read is consistent
+	email := "changed@example.com"
+	_, err = backing.Update(ctx, "1", models.UserUpdate{Email: &email}, 0)
+	require.NoError(t, err)
+
+	r, err := store.GetByID(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, u.Email, r.Email)
+
+	r, err = store.GetByID(ctx, "1", userstore.ConsistentRead())
+	require.NoError(t, err)
+	assert.Equal(t, email, r.Email)
+
+	// The consistent read refreshed the entry
+	r, err = store.GetByID(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, email, r.Email)
+}
+
+func TestNegativeCaching(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	backing := memstore.NewStore()
+	c := New(WithTTL(time.Minute), WithNegativeTTL(time.Second), WithClock(func() time.Time { return now }))
+	store := c.Wrap(backing)
+
+	_, err := store.GetByID(ctx, "1")
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	_, err = backing.Create(ctx, models.User{UserID: "1", Email: "late@example.com"})
+	require.NoError(t, err)
+
+	_, err = store.GetByID(ctx, "1")
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	now = now.Add(time.Second)
+	_, err = store.GetByID(ctx, "1")
+	require.NoError(t, err)
+
+	assert.Equal(t, Stats{NegativeHits: 1, Misses: 2}, c.Stats())
+}
+
+func TestWritesInvalidate(t *testing.T) {
+	ctx := context.Background()
+	c := New()
+	store := c.Wrap(memstore.NewStore())
+
+	_, err := store.GetByID(ctx, "1")
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	u, err := store.Create(ctx, models.User{UserID: "1", Email: "created@example.com"})
+	require.NoError(t, err)
+
+	r, err := store.GetByID(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, u, r)
+
+	_, err = store.Delete(ctx, "1", 0)
+	require.NoError(t, err)
+
+	_, err = store.GetByID(ctx, "1")
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	// Deleted users are cached once, and served to reads that include them
+	r, err = store.GetByID(ctx, "1", userstore.IncludeDeleted())
+	require.NoError(t, err)
+	assert.NotNil(t, r.DeletedAt)
+	assert.Equal(t, int64(1), c.Stats().Hits)
+}
+
+func TestEviction(t *testing.T) {
+	ctx := context.Background()
+	c := New(WithMaxEntries(2))
+	store := c.Wrap(memstore.NewStore())
+
+	for _, id := range []string{"1", "2", "1", "3"} {
+		_, err := store.GetByID(ctx, id)
+		require.ErrorIs(t, err, userstore.ErrNotFound)
+	}
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, int64(1), c.Stats().Evictions)
+
+	// 2 was the least recently used
+	_, _ = store.GetByID(ctx, "1")
+	_, _ = store.GetByID(ctx, "2")
+	assert.Equal(t, Stats{NegativeHits: 2, Misses: 4, Evictions: 2}, c.Stats())
+}
+
+func TestBatchGetByIDs(t *testing.T) {
+	ctx := context.Background()
+	c := New()
+	store := c.Wrap(memstore.NewStore())
+
+	a, err := store.Create(ctx, models.User{UserID: "a", Email: "a@example.com"})
+	require.NoError(t, err)
+	_, err = store.GetByID(ctx, "a")
+	require.NoError(t, err)
+
+	results, err := store.BatchGetByIDs(ctx, []string{"a", "missing", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, userstore.BatchGetResult{User: a}, results[0])
+	assert.ErrorIs(t, results[1].Err, userstore.ErrNotFound)
+	assert.ErrorIs(t, results[2].Err, userstore.ErrNotFound)
+	assert.Equal(t, Stats{Hits: 1, Misses: 2}, c.Stats())
+
+	_, err = store.GetByID(ctx, "missing")
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+	assert.Equal(t, int64(1), c.Stats().NegativeHits)
+}
@@ -0,0 +1,24 @@
+package userstore_test
+
+import (
+	"testing"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/storetest"
+	"github.com/benjaminkitson/bk-user-api/pii"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, opts ...userstore.Option) userstore.Store {
+		return userstore.NewStore(t, opts...)
+	})
+}
+
+// The conformance tests again, with personal data encrypted
+func TestConformanceEncrypted(t *testing.T) {
+	keys := pii.NewKeyFile("test")
+	storetest.Run(t, func(t *testing.T, opts ...userstore.Option) userstore.Store {
+		opts = append(opts, userstore.WithEncryption(pii.NewCipher(keys.Provider()), pii.NewBlindIndex(keys.IndexKey)))
+		return userstore.NewStore(t, opts...)
+	})
+}
@@ -0,0 +1,99 @@
+package userstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor is malformed or has been tampered with
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+var encoding = base64.RawURLEncoding
+
+/*
+SignCursor returns an opaque cursor carrying the payload, in the form <payload>.<signature>. The payload is base64
+encoded, and the signature is an HMAC of it, so that cursors can be handed to API callers without them being
+able to construct or modify them. Every store signs its cursors this way.
+*/
+func SignCursor(signingKey []byte, payload []byte) string {
+	p := encoding.EncodeToString(payload)
+	return p + "." + encoding.EncodeToString(sign(signingKey, p))
+}
+
+// VerifyCursor returns the payload of a cursor created by SignCursor with the same key, or ErrInvalidCursor
+func VerifyCursor(signingKey []byte, cursor string) ([]byte, error) {
+	payload, signature, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	s, err := encoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(s, sign(signingKey, payload)) {
+		return nil, ErrInvalidCursor
+	}
+
+	b, err := encoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return b, nil
+}
+
+// Encodes a LastEvaluatedKey as a signed cursor, whose payload is the key as JSON
+func encodeCursor(signingKey []byte, key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+
+	values := make(map[string]string, len(key))
+	for name, v := range key {
+		s, ok := v.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", errors.Errorf("unsupported key attribute type for %s", name)
+		}
+		values[name] = s.Value
+	}
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", errors.Wrap(err, "an error ocurred marshaling the cursor")
+	}
+
+	return SignCursor(signingKey, b), nil
+}
+
+// decodeCursor verifies and decodes a cursor created by encodeCursor
+func decodeCursor(signingKey []byte, cursor string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	b, err := VerifyCursor(signingKey, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	err = json.Unmarshal(b, &values)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	key := make(map[string]types.AttributeValue, len(values))
+	for name, v := range values {
+		key[name] = &types.AttributeValueMemberS{Value: v}
+	}
+	return key, nil
+}
+
+func sign(signingKey []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,48 @@
+package userstore
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := map[string]types.AttributeValue{
+		PKKey: &types.AttributeValueMemberS{Value: "user/12345"},
+	}
+
+	c, err := encodeCursor([]byte("secret"), key)
+	require.NoError(t, err)
+
+	decoded, err := decodeCursor([]byte("secret"), c)
+	require.NoError(t, err)
+	assert.Equal(t, key, decoded)
+}
+
+func TestCursorTampering(t *testing.T) {
+	key := map[string]types.AttributeValue{
+		PKKey: &types.AttributeValueMemberS{Value: "user/12345"},
+	}
+
+	c, err := encodeCursor([]byte("secret"), key)
+	require.NoError(t, err)
+
+	_, err = decodeCursor([]byte("another secret"), c)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	forged, err := encodeCursor([]byte("forged"), map[string]types.AttributeValue{
+		PKKey: &types.AttributeValueMemberS{Value: "user/99999"},
+	})
+	require.NoError(t, err)
+	// A modified payload shouldn't be accepted with the original signature
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	_, signature, _ := strings.Cut(c, ".")
+	_, err = decodeCursor([]byte("secret"), forgedPayload+"."+signature)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	_, err = decodeCursor([]byte("secret"), "not a cursor")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
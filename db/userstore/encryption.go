@@ -0,0 +1,313 @@
+package userstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/pii"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/pkg/errors"
+)
+
+const (
+	emailField   = "email"
+	changesField = "changes"
+)
+
+// piiFields are the user's fields that are encrypted at rest, which covers their values in history entries too
+var piiFields = map[string]bool{
+	emailField: true,
+}
+
+/*
+binding identifies where an encrypted value belongs, so that it can't be decrypted anywhere else. It includes
+the user's tenant, so values can't be moved between tenants either.
+*/
+func (store UserStore) binding(ctx context.Context, userID string, field string) string {
+	return store.getUserPK(ctx, userID) + "/" + field
+}
+
+// seal encrypts a non-empty value of one of the user's fields, if the store encrypts
+func (store UserStore) seal(ctx context.Context, userID string, field string, value string) (string, error) {
+	if store.cipher == nil || value == "" {
+		return value, nil
+	}
+	return store.cipher.Encrypt(ctx, value, store.binding(ctx, userID, field))
+}
+
+// open decrypts a value sealed by seal. Values written before encryption was enabled are returned as they are.
+func (store UserStore) open(ctx context.Context, userID string, field string, value string) (string, error) {
+	if !pii.IsEncrypted(value) {
+		return value, nil
+	}
+	if store.cipher == nil {
+		return "", errors.New("found an encrypted value, but the store has no cipher")
+	}
+	return store.cipher.Decrypt(ctx, value, store.binding(ctx, userID, field))
+}
+
+/*
+UnmarshalUser reads a user from a stored user item, decrypting its encrypted fields. The user's tenant is taken
+from the item's partition key. It is exported for consumers of the table's stream, which see items as they are
+stored.
+*/
+func (store UserStore) UnmarshalUser(ctx context.Context, item map[string]types.AttributeValue) (models.User, error) {
+	var user models.User
+	err := attributevalue.UnmarshalMap(item, &user)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if pk, ok := item[PKKey].(*types.AttributeValueMemberS); ok {
+		tenantID, _, ok := schema.ParseUserPK(pk.Value)
+		if !ok {
+			return models.User{}, fmt.Errorf("%q is not a user's partition key", pk.Value)
+		}
+		user.TenantID = tenantID
+	}
+
+	user.Email, err = store.open(tenant.WithTenant(ctx, user.TenantID), user.UserID, emailField, user.Email)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// sealChanges encrypts the values of the changes to encrypted fields
+func (store UserStore) sealChanges(ctx context.Context, userID string, changes []models.UserChange) ([]models.UserChange, error) {
+	return mapChanges(changes, func(field string, value string) (string, error) {
+		return store.seal(ctx, userID, field, value)
+	})
+}
+
+// openChanges decrypts the values of the changes to encrypted fields
+func (store UserStore) openChanges(ctx context.Context, userID string, changes []models.UserChange) ([]models.UserChange, error) {
+	return mapChanges(changes, func(field string, value string) (string, error) {
+		return store.open(ctx, userID, field, value)
+	})
+}
+
+// mapChanges returns a copy of the changes with fn applied to the values of changes to encrypted fields
+func mapChanges(changes []models.UserChange, fn func(field string, value string) (string, error)) ([]models.UserChange, error) {
+	mapped := make([]models.UserChange, len(changes))
+	for i, change := range changes {
+		mapped[i] = change
+		if !piiFields[change.Field] {
+			continue
+		}
+
+		var err error
+		mapped[i].From, err = fn(change.Field, change.From)
+		if err != nil {
+			return nil, err
+		}
+		mapped[i].To, err = fn(change.Field, change.To)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mapped, nil
+}
+
+type KeyRotationOptions struct {
+	// DryRun reports what would be re-encrypted without writing anything
+	DryRun bool
+	// WritesPerSecond limits how quickly items are rewritten, to leave capacity for live traffic. Zero means no
+	// limit.
+	WritesPerSecond float64
+}
+
+type KeyRotationReport struct {
+	// EmailRepair reports the repair of the email index, which moves users indexed by their plaintext email to
+	// the blind index
+	EmailRepair EmailRepairReport
+	// Scanned is the number of items examined
+	Scanned int
+	// Stale is the number of items holding values that were unencrypted or under an old master key, whether or
+	// not they were re-encrypted
+	Stale int
+	// Rotated is the number of items re-encrypted, which is always zero for a dry run
+	Rotated int
+	// Failed holds the items that couldn't be re-encrypted, by partition and sort key
+	Failed map[string]error
+}
+
+/*
+RotateKeys re-encrypts every value in the table, across every tenant, that is unencrypted or encrypted under a master key other than
+the cipher's current one, so that old master keys can be retired. It also encrypts the tables written before
+encryption was enabled: RepairEmailIndex is run first to move users from plaintext email keys to the blind
+index, and then users, history and pending outbox items are re-encrypted in place.
+
+Re-encrypting doesn't change any values, so items keep their versions and no history is recorded. Each item is
+rewritten on its own, conditional on the value not having changed since it was scanned, so the rotation can be
+run alongside live traffic and safely re-run.
+*/
+func (store UserStore) RotateKeys(ctx context.Context, opts KeyRotationOptions) (KeyRotationReport, error) {
+	report := KeyRotationReport{Failed: map[string]error{}}
+	if store.cipher == nil {
+		return report, errors.New("a cipher is required to rotate keys")
+	}
+
+	var err error
+	report.EmailRepair, err = store.RepairEmailIndex(ctx, EmailRepairOptions{
+		DryRun:          opts.DryRun,
+		WritesPerSecond: opts.WritesPerSecond,
+	})
+	if err != nil {
+		return report, err
+	}
+
+	var throttle <-chan time.Time
+	if opts.WritesPerSecond > 0 && !opts.DryRun {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.WritesPerSecond))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	err = store.scanItems(ctx, func(item map[string]types.AttributeValue) error {
+		pk, _ := item[PKKey].(*types.AttributeValueMemberS)
+		sk, _ := item[SKKey].(*types.AttributeValueMemberS)
+		if pk == nil || sk == nil {
+			return nil
+		}
+		tenantID, userID, ok := schema.ParseUserPK(pk.Value)
+		if !ok {
+			return nil
+		}
+		report.Scanned++
+
+		id := pk.Value + " " + sk.Value
+		update, err := store.reencrypt(tenant.WithTenant(ctx, tenantID), item, userID, sk.Value)
+		if err != nil {
+			report.Failed[id] = err
+			return nil
+		}
+		if update == nil {
+			return nil
+		}
+		report.Stale++
+		if opts.DryRun {
+			return nil
+		}
+
+		if throttle != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-throttle:
+			}
+		}
+
+		_, err = store.client.UpdateItem(ctx, update)
+		// A failed condition means the item was rewritten since it was scanned, so is already under the current key
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil
+		}
+		if err != nil {
+			report.Failed[id] = classifyError(err)
+			return nil
+		}
+		report.Rotated++
+		return nil
+	})
+	return report, err
+}
+
+/*
+reencrypt builds an update re-encrypting the item's stale values, or returns nil if it has none. Users are
+conditional on their email not having changed, while history and outbox events are never changed once written.
+*/
+func (store UserStore) reencrypt(ctx context.Context, item map[string]types.AttributeValue, userID string, sk string) (*dynamodb.UpdateItemInput, error) {
+	var attribute string
+	var value types.AttributeValue
+	condition := "attribute_exists(#pk)"
+	values := map[string]types.AttributeValue{}
+
+	switch {
+	case sk == schema.UserSK:
+		v, ok := item[emailField].(*types.AttributeValueMemberS)
+		if !ok || v.Value == "" || !store.cipher.NeedsRotation(v.Value) {
+			return nil, nil
+		}
+		sealed, err := store.rotate(ctx, userID, emailField, v.Value)
+		if err != nil {
+			return nil, err
+		}
+		attribute = emailField
+		value = &types.AttributeValueMemberS{Value: sealed}
+		condition = "attribute_exists(#pk) AND #attribute = :old"
+		values[":old"] = v
+
+	case strings.HasPrefix(sk, schema.HistoryPrefix):
+		var changes []models.UserChange
+		err := attributevalue.Unmarshal(item[changesField], &changes)
+		if err != nil {
+			return nil, err
+		}
+		stale := false
+		changes, err = mapChanges(changes, func(field string, value string) (string, error) {
+			if value == "" || !store.cipher.NeedsRotation(value) {
+				return value, nil
+			}
+			stale = true
+			return store.rotate(ctx, userID, field, value)
+		})
+		if err != nil || !stale {
+			return nil, err
+		}
+		attribute = changesField
+		value, err = attributevalue.Marshal(changes)
+		if err != nil {
+			return nil, err
+		}
+
+	case strings.HasPrefix(sk, schema.OutboxPrefix):
+		v, ok := item[outboxEventKey].(*types.AttributeValueMemberS)
+		if !ok || !store.cipher.NeedsRotation(v.Value) {
+			return nil, nil
+		}
+		sealed, err := store.rotate(ctx, userID, outboxEventKey, v.Value)
+		if err != nil {
+			return nil, err
+		}
+		attribute = outboxEventKey
+		value = &types.AttributeValueMemberS{Value: sealed}
+
+	default:
+		return nil, nil
+	}
+
+	values[":value"] = value
+	return &dynamodb.UpdateItemInput{
+		TableName: aws.String(store.tableName),
+		Key: map[string]types.AttributeValue{
+			PKKey: item[PKKey],
+			SKKey: item[SKKey],
+		},
+		UpdateExpression:    aws.String("SET #attribute = :value"),
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeNames: map[string]string{
+			"#pk":        PKKey,
+			"#attribute": attribute,
+		},
+		ExpressionAttributeValues: values,
+	}, nil
+}
+
+// rotate decrypts the value, if it is encrypted, and encrypts it under the current master key
+func (store UserStore) rotate(ctx context.Context, userID string, field string, value string) (string, error) {
+	plaintext, err := store.open(ctx, userID, field, value)
+	if err != nil {
+		return "", err
+	}
+	return store.seal(ctx, userID, field, plaintext)
+}
@@ -0,0 +1,211 @@
+package userstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/pii"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withKeys(keys pii.KeyFile) Option {
+	return WithEncryption(pii.NewCipher(keys.Provider()), pii.NewBlindIndex(keys.IndexKey))
+}
+
+func TestMarshalEncryptedUser(t *testing.T) {
+	ctx := context.Background()
+	store := NewUserStore(nil, "userTable", withKeys(pii.NewKeyFile("k1")))
+	user := models.User{UserID: "12345", Email: "Abc@Gmail.com", Version: 1}
+
+	item, err := store.marshalUser(ctx, user)
+	require.NoError(t, err)
+	email := item[emailField].(*types.AttributeValueMemberS).Value
+	assert.True(t, pii.IsEncrypted(email))
+	assert.NotContains(t, item[GSI1Key].(*types.AttributeValueMemberS).Value, "gmail")
+	assert.Equal(t, store.getUserGSI1(ctx, "abc@gmail.com"), item[GSI1Key].(*types.AttributeValueMemberS).Value)
+
+	read, err := store.UnmarshalUser(ctx, item)
+	require.NoError(t, err)
+	assert.Equal(t, user, read)
+
+	// Encrypted values can't be moved to another tenant
+	item[PKKey] = &types.AttributeValueMemberS{Value: schema.UserPK("acme", "12345")}
+	_, err = store.UnmarshalUser(ctx, item)
+	assert.Error(t, err)
+
+	// Or another user
+	item[PKKey] = &types.AttributeValueMemberS{Value: schema.UserPK(tenant.Default, "67890")}
+	item["userID"] = &types.AttributeValueMemberS{Value: "67890"}
+	_, err = store.UnmarshalUser(ctx, item)
+	assert.Error(t, err)
+
+	// Nor read without the keys
+	_, err = NewUserStore(nil, "userTable").UnmarshalUser(ctx, item)
+	assert.Error(t, err)
+}
+
+func TestSealChanges(t *testing.T) {
+	ctx := context.Background()
+	store := NewUserStore(nil, "userTable", withKeys(pii.NewKeyFile("k1")))
+	changes := []models.UserChange{
+		{Field: "email", From: "abc@gmail.com", To: "def@gmail.com"},
+		{Field: "deletedAt", To: "2024-01-01T00:00:00Z"},
+	}
+
+	sealed, err := store.sealChanges(ctx, "12345", changes)
+	require.NoError(t, err)
+	assert.True(t, pii.IsEncrypted(sealed[0].From))
+	assert.True(t, pii.IsEncrypted(sealed[0].To))
+	assert.Equal(t, changes[1], sealed[1])
+
+	opened, err := store.openChanges(ctx, "12345", sealed)
+	require.NoError(t, err)
+	assert.Equal(t, changes, opened)
+}
+
+func TestReencryptExpressions(t *testing.T) {
+	ctx := context.Background()
+	store := NewUserStore(nil, "userTable", withKeys(pii.NewKeyFile("k1")))
+	changes, err := attributevalue.Marshal([]models.UserChange{{Field: "email", To: "abc@gmail.com"}})
+	require.NoError(t, err)
+
+	items := map[string]map[string]types.AttributeValue{
+		schema.UserSK: {
+			emailField: &types.AttributeValueMemberS{Value: "abc@gmail.com"},
+		},
+		schema.HistoryPrefix + "1": {
+			changesField: changes,
+		},
+		schema.OutboxPrefix + "1": {
+			outboxEventKey: &types.AttributeValueMemberS{Value: "{}"},
+		},
+	}
+	for sk, item := range items {
+		item[PKKey] = &types.AttributeValueMemberS{Value: "user/12345"}
+		item[SKKey] = &types.AttributeValueMemberS{Value: sk}
+
+		update, err := store.reencrypt(ctx, item, "12345", sk)
+		require.NoError(t, err)
+		require.NotNil(t, update, sk)
+
+		// DynamoDB rejects names and values that the expressions don't use
+		expressions := *update.UpdateExpression + " " + *update.ConditionExpression
+		for name := range update.ExpressionAttributeNames {
+			assert.Contains(t, expressions, name, sk)
+		}
+		for value := range update.ExpressionAttributeValues {
+			assert.Contains(t, expressions, value, sk)
+		}
+	}
+}
+
+func rawItem(t *testing.T, store UserStore, key map[string]types.AttributeValue) map[string]types.AttributeValue {
+	out, err := store.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: &store.tableName,
+		Key:       key,
+	})
+	require.NoError(t, err)
+	return out.Item
+}
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t, withKeys(pii.NewKeyFile("k1")), WithOutbox())
+
+	created, err := store.Create(ctx, models.User{Email: "secret@example.com", UserID: uuid.New().String()})
+	require.NoError(t, err)
+	email := "private@example.com"
+	_, err = store.Update(ctx, created.UserID, models.UserUpdate{Email: &email}, created.Version)
+	require.NoError(t, err)
+
+	r, err := store.GetByEmail(ctx, "PRIVATE@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, email, r.Email)
+
+	_, err = store.Create(ctx, models.User{Email: "private@example.com", UserID: uuid.New().String()})
+	require.ErrorIs(t, err, ErrEmailTaken)
+
+	history, err := store.History(ctx, created.UserID, 10, "")
+	require.NoError(t, err)
+	require.Len(t, history.Entries, 2)
+	assert.Equal(t, []models.UserChange{{Field: "email", From: "secret@example.com", To: email}}, history.Entries[0].Changes)
+
+	// No trace of either email is stored in plaintext
+	err = store.scanItems(ctx, func(item map[string]types.AttributeValue) error {
+		for name, v := range item {
+			s, ok := v.(*types.AttributeValueMemberS)
+			if ok && (strings.Contains(s.Value, "secret") || strings.Contains(s.Value, "private")) {
+				t.Errorf("found plaintext email in %s of %v", name, item[PKKey])
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestRotateKeys(t *testing.T) {
+	ctx := context.Background()
+	keys := pii.NewKeyFile("k1")
+	store := NewStore(t, withKeys(keys), WithClock(func() time.Time { return time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC) }))
+
+	// The seeded user was written before encryption was enabled, so can only be found by id
+	_, err := store.GetByEmail(ctx, "benk13@gmail.com")
+	require.ErrorIs(t, err, ErrNotFound)
+	r, err := store.GetByID(ctx, "12345")
+	require.NoError(t, err)
+	assert.Equal(t, "benk13@gmail.com", r.Email)
+
+	report, err := store.RotateKeys(ctx, KeyRotationOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Len(t, report.EmailRepair.Repairs, 1)
+	assert.Equal(t, 1, report.Stale)
+	assert.Zero(t, report.Rotated)
+
+	report, err = store.RotateKeys(ctx, KeyRotationOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.EmailRepair.Repaired)
+	assert.Empty(t, report.Failed)
+
+	r, err = store.GetByEmail(ctx, "benk13@gmail.com")
+	require.NoError(t, err)
+	assert.Equal(t, "12345", r.UserID)
+	item := rawItem(t, store, schema.UserKey(tenant.Default, "12345"))
+	assert.True(t, pii.IsEncrypted(item[emailField].(*types.AttributeValueMemberS).Value))
+	assert.Empty(t, rawItem(t, store, schema.EmailReservationKey(tenant.Default, "benk13@gmail.com")))
+
+	// After a new master key is introduced, everything under the old one is re-encrypted
+	email := "rotated@example.com"
+	_, err = store.Update(ctx, "12345", models.UserUpdate{Email: &email}, 0)
+	require.NoError(t, err)
+
+	keys.Rotate("k2")
+	rotated := NewUserStore(store.client, store.tableName, withKeys(keys), WithCursorKey(store.cursorKey))
+	report, err = rotated.RotateKeys(ctx, KeyRotationOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, report.EmailRepair.Repairs)
+	assert.Equal(t, 2, report.Stale)
+	assert.Equal(t, 2, report.Rotated)
+
+	delete(keys.Keys, "k1")
+	retired := NewUserStore(store.client, store.tableName, withKeys(keys), WithCursorKey(store.cursorKey))
+	r, err = retired.GetByID(ctx, "12345")
+	require.NoError(t, err)
+	assert.Equal(t, email, r.Email)
+	history, err := retired.History(ctx, "12345", 10, "")
+	require.NoError(t, err)
+	assert.Equal(t, email, history.Entries[0].Changes[0].To)
+
+	report, err = retired.RotateKeys(ctx, KeyRotationOptions{})
+	require.NoError(t, err)
+	assert.Zero(t, report.Stale)
+}
@@ -0,0 +1,135 @@
+package userstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound is returned when the requested user does not exist
+	ErrNotFound = errors.New("user not found")
+	// ErrEmailTaken is returned when attempting to create a user with an email that is already reserved
+	ErrEmailTaken = errors.New("email address is already in use")
+	// ErrConflict is returned when a conditional write fails because the stored record has changed
+	ErrConflict = errors.New("conflicting write")
+	// ErrNotDeleted is returned when attempting to restore a user that hasn't been deleted
+	ErrNotDeleted = errors.New("user is not deleted")
+	// ErrThrottled is returned when DynamoDB rejects a request due to insufficient capacity
+	ErrThrottled = errors.New("request throttled")
+	// ErrTimeout is returned when an operation runs out of time, including any retries
+	ErrTimeout = errors.New("request timed out")
+	// ErrValidation is returned when DynamoDB rejects a request as invalid, such as a user too large to store
+	ErrValidation = errors.New("invalid request")
+)
+
+/*
+Pairs one of the sentinel errors above with the underlying DynamoDB error, so that callers can use errors.Is
+to check the kind of failure and errors.As to get at the original SDK error if they need to
+*/
+type storeError struct {
+	kind error
+	err  error
+}
+
+func (e storeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.err)
+}
+
+func (e storeError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+func wrapError(kind error, err error) error {
+	if err == nil {
+		return kind
+	}
+	return storeError{kind: kind, err: err}
+}
+
+// classifyError wraps DynamoDB errors in the matching sentinel error, returning any other errors unchanged
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return wrapError(ErrConflict, err)
+	}
+
+	var tcx *types.TransactionConflictException
+	if errors.As(err, &tcx) {
+		return wrapError(ErrConflict, err)
+	}
+
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		for _, reason := range tce.CancellationReasons {
+			switch aws.ToString(reason.Code) {
+			case "ThrottlingError", "ProvisionedThroughputExceeded", "RequestLimitExceeded":
+				return wrapError(ErrThrottled, err)
+			case "ConditionalCheckFailed", "TransactionConflict":
+				return wrapError(ErrConflict, err)
+			}
+		}
+		return err
+	}
+
+	if isThrottlingError(err) {
+		return wrapError(ErrThrottled, err)
+	}
+
+	// Raised by the SDK when it has spent its budget for retries, which only happens while requests keep failing
+	var qee *ratelimit.QuotaExceededError
+	if errors.As(err, &qee) {
+		return wrapError(ErrThrottled, err)
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return wrapError(ErrTimeout, err)
+	}
+
+	var ae smithy.APIError
+	if errors.As(err, &ae) && ae.ErrorCode() == "ValidationException" {
+		return wrapError(ErrValidation, err)
+	}
+
+	return err
+}
+
+func isThrottlingError(err error) bool {
+	var pte *types.ProvisionedThroughputExceededException
+	if errors.As(err, &pte) {
+		return true
+	}
+
+	var rle *types.RequestLimitExceeded
+	if errors.As(err, &rle) {
+		return true
+	}
+
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "ThrottlingException", "Throttling", "ThrottledException":
+			return true
+		}
+	}
+
+	return false
+}
+
+// cancellationReason returns the code of the transaction cancellation reason for the item at index i, if any
+func cancellationReason(err error, i int) string {
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) || i >= len(tce.CancellationReasons) {
+		return ""
+	}
+	return aws.ToString(tce.CancellationReasons[i].Code)
+}
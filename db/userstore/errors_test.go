@@ -0,0 +1,82 @@
+package userstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	type test struct {
+		Name         string
+		Err          error
+		ExpectedKind error
+	}
+
+	tests := []test{
+		{
+			Name:         "Conditional check failure",
+			Err:          &types.ConditionalCheckFailedException{},
+			ExpectedKind: ErrConflict,
+		},
+		{
+			Name:         "Provisioned throughput exceeded",
+			Err:          &types.ProvisionedThroughputExceededException{},
+			ExpectedKind: ErrThrottled,
+		},
+		{
+			Name:         "Request limit exceeded",
+			Err:          &types.RequestLimitExceeded{},
+			ExpectedKind: ErrThrottled,
+		},
+		{
+			Name: "Throttled transaction",
+			Err: &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{
+				{Code: aws.String("None")},
+				{Code: aws.String("ThrottlingError")},
+			}},
+			ExpectedKind: ErrThrottled,
+		},
+		{
+			Name: "Conflicting transaction",
+			Err: &types.TransactionCanceledException{CancellationReasons: []types.CancellationReason{
+				{Code: aws.String("ConditionalCheckFailed")},
+			}},
+			ExpectedKind: ErrConflict,
+		},
+		{
+			Name:         "Retry quota exceeded",
+			Err:          &ratelimit.QuotaExceededError{Available: 0, Requested: 5},
+			ExpectedKind: ErrThrottled,
+		},
+		{
+			Name:         "Deadline exceeded",
+			Err:          context.DeadlineExceeded,
+			ExpectedKind: ErrTimeout,
+		},
+		{
+			Name:         "Validation",
+			Err:          &smithy.GenericAPIError{Code: "ValidationException", Message: "Item size has exceeded the maximum allowed size"},
+			ExpectedKind: ErrValidation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := classifyError(errors.Wrap(tt.Err, "operation error"))
+			assert.ErrorIs(t, err, tt.ExpectedKind)
+			assert.ErrorIs(t, err, tt.Err)
+		})
+	}
+
+	t.Run("Other errors are unchanged", func(t *testing.T) {
+		err := errors.New("something else")
+		assert.Equal(t, err, classifyError(err))
+	})
+}
@@ -0,0 +1,162 @@
+package userstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/audit"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/pkg/errors"
+)
+
+// Fields recorded in the entry itself, or by where the entry is stored, rather than as changes
+var unchangedFields = map[string]bool{
+	"userID":    true,
+	"tenantID":  true,
+	"version":   true,
+	"updatedAt": true,
+}
+
+/*
+NewHistoryEntry describes the change from before to after, made by the actor and request carried by the
+context. before is the zero user for a create. It is shared by the store implementations so that they all
+record changes the same way.
+*/
+func NewHistoryEntry(ctx context.Context, action models.HistoryAction, before models.User, after models.User) models.HistoryEntry {
+	return models.HistoryEntry{
+		UserID:    after.UserID,
+		Action:    action,
+		Version:   after.Version,
+		Timestamp: after.UpdatedAt,
+		Actor:     audit.Actor(ctx),
+		RequestID: audit.RequestID(ctx),
+		Changes:   Diff(before, after),
+	}
+}
+
+// Diff returns the changes to the user's fields from before to after, in order of field name
+func Diff(before models.User, after models.User) []models.UserChange {
+	from := userFields(before)
+	to := userFields(after)
+
+	names := []string{}
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []models.UserChange{}
+	for _, name := range names {
+		if unchangedFields[name] || from[name] == to[name] {
+			continue
+		}
+		changes = append(changes, models.UserChange{Field: name, From: from[name], To: to[name]})
+	}
+	return changes
+}
+
+// userFields returns the user's fields as they appear in JSON, leaving out unset fields
+func userFields(user models.User) map[string]string {
+	if user == (models.User{}) {
+		return map[string]string{}
+	}
+
+	b, err := json.Marshal(user)
+	if err != nil {
+		// A user is always marshalable
+		panic(err)
+	}
+	raw := map[string]any{}
+	err = json.Unmarshal(b, &raw)
+	if err != nil {
+		panic(err)
+	}
+
+	fields := map[string]string{}
+	for name, v := range raw {
+		if v == nil {
+			continue
+		}
+		fields[name] = fmt.Sprint(v)
+	}
+	return fields
+}
+
+/*
+History returns a page of at most limit of the user's history entries, newest first, starting after the given
+cursor. History is kept for deleted users, and a user that never existed has an empty history rather than
+ErrNotFound. ErrInvalidCursor is returned if the cursor was not issued for the same user by a store with the
+same cursor key.
+*/
+func (store UserStore) History(ctx context.Context, id string, limit int32, cursor string) (models.HistoryPage, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	if len(store.cursorKey) == 0 {
+		return models.HistoryPage{}, errors.New("a cursor key is required to list history")
+	}
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	startKey, err := decodeCursor(store.cursorKey, cursor)
+	if err != nil {
+		return models.HistoryPage{}, err
+	}
+	if pk, ok := startKey[PKKey].(*types.AttributeValueMemberS); startKey != nil && (!ok || pk.Value != store.getUserPK(ctx, id)) {
+		return models.HistoryPage{}, ErrInvalidCursor
+	}
+
+	out, err := store.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(store.tableName),
+		KeyConditionExpression: aws.String("#pk = :pk AND begins_with(#sk, :prefix)"),
+		ExpressionAttributeNames: map[string]string{
+			"#pk": PKKey,
+			"#sk": SKKey,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":     &types.AttributeValueMemberS{Value: store.getUserPK(ctx, id)},
+			":prefix": &types.AttributeValueMemberS{Value: schema.HistoryPrefix},
+		},
+		ScanIndexForward:  aws.Bool(false),
+		Limit:             aws.Int32(limit),
+		ExclusiveStartKey: startKey,
+	})
+	if err != nil {
+		return models.HistoryPage{}, classifyError(err)
+	}
+
+	page := models.HistoryPage{Entries: []models.HistoryEntry{}}
+	err = attributevalue.UnmarshalListOfMaps(out.Items, &page.Entries)
+	if err != nil {
+		return models.HistoryPage{}, err
+	}
+	for i, entry := range page.Entries {
+		page.Entries[i].Changes, err = store.openChanges(ctx, id, entry.Changes)
+		if err != nil {
+			return models.HistoryPage{}, err
+		}
+	}
+
+	page.Cursor, err = encodeCursor(store.cursorKey, out.LastEvaluatedKey)
+	if err != nil {
+		return models.HistoryPage{}, err
+	}
+
+	return page, nil
+}
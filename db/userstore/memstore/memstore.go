@@ -0,0 +1,589 @@
+/*
+Package memstore is an in-memory implementation of userstore.Store, for tests and local development. It
+reproduces the DynamoDB store's semantics, including email uniqueness, soft deletion, conditional writes and
+the separation of tenants, without needing DynamoDB Local. Soft-deleted users and held emails are purged as soon
+as the restore window passes, where DynamoDB's TTL would purge them some time after.
+*/
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/pkg/errors"
+)
+
+// reservation records which user an email belongs to, and when it expires if the user has been deleted
+type reservation struct {
+	userID  string
+	expires *time.Time
+}
+
+// partition holds a single tenant's users, which are kept apart from every other tenant's
+type partition struct {
+	users        map[string]models.User
+	reservations map[string]reservation
+	// history holds each user's history entries, oldest first
+	history map[string][]models.HistoryEntry
+}
+
+type Store struct {
+	mu   sync.Mutex
+	opts userstore.Options
+	// partitions holds each tenant's partition by tenant id
+	partitions map[string]*partition
+}
+
+var _ userstore.Store = (*Store)(nil)
+
+func NewStore(opts ...userstore.Option) *Store {
+	return &Store{
+		opts:       userstore.NewOptions(opts...),
+		partitions: map[string]*partition{},
+	}
+}
+
+// partition returns the partition of the tenant the request is made on behalf of, creating it if it's new
+func (s *Store) partition(ctx context.Context) *partition {
+	id := tenant.FromContext(ctx)
+	p, ok := s.partitions[id]
+	if !ok {
+		p = &partition{
+			users:        map[string]models.User{},
+			reservations: map[string]reservation{},
+			history:      map[string][]models.HistoryEntry{},
+		}
+		s.partitions[id] = p
+	}
+	return p
+}
+
+func (s *Store) GetByID(ctx context.Context, id string, opts ...userstore.ReadOption) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+
+	user, ok := s.getUser(p, id)
+	if !ok {
+		return models.User{}, userstore.ErrNotFound
+	}
+
+	if user.DeletedAt != nil && !userstore.NewReadOptions(opts...).IncludeDeleted {
+		return models.User{}, userstore.ErrNotFound
+	}
+
+	return user, nil
+}
+
+func (s *Store) GetByEmail(ctx context.Context, email string, opts ...userstore.ReadOption) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+	s.purge(p)
+
+	key := s.emailKey(email)
+	matches := []models.User{}
+	for _, user := range p.users {
+		// Users deleted under the release policy are no longer indexed by email
+		if user.DeletedAt != nil && s.opts.DeletedEmailPolicy == userstore.ReleaseEmail {
+			continue
+		}
+		if s.emailKey(user.Email) == key {
+			matches = append(matches, user)
+		}
+	}
+
+	if len(matches) > 1 {
+		return models.User{}, fmt.Errorf("expected maximum of 1 records, found %d", len(matches))
+	}
+
+	if len(matches) == 0 {
+		return models.User{}, userstore.ErrNotFound
+	}
+
+	if matches[0].DeletedAt != nil && !userstore.NewReadOptions(opts...).IncludeDeleted {
+		return models.User{}, userstore.ErrNotFound
+	}
+
+	return matches[0], nil
+}
+
+/*
+List returns a page of at most limit users, excluding soft-deleted users, in order of id. Cursors are signed
+with the store's cursor key, and anything that isn't a cursor issued by a store with the same key is rejected
+with ErrInvalidCursor.
+*/
+func (s *Store) List(ctx context.Context, limit int32, cursor string) (models.UserPage, error) {
+	if len(s.opts.CursorKey) == 0 {
+		return models.UserPage{}, errors.New("a cursor key is required to list users")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+	s.purge(p)
+
+	if limit <= 0 {
+		limit = userstore.DefaultPageSize
+	}
+	if limit > userstore.MaxPageSize {
+		limit = userstore.MaxPageSize
+	}
+
+	after, err := s.decodeCursor(cursor)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+
+	ids := make([]string, 0, len(p.users))
+	for id, user := range p.users {
+		if user.DeletedAt == nil && id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	page := models.UserPage{Users: []models.User{}}
+	for _, id := range ids[:min(int(limit), len(ids))] {
+		page.Users = append(page.Users, p.users[id])
+	}
+	if len(ids) > int(limit) {
+		page.Cursor = s.encodeCursor(ids[limit-1])
+	}
+
+	return page, nil
+}
+
+/*
+ListCreatedBetween returns a page of at most limit users created at or after from and before to, excluding
+soft-deleted users, in order of creation. Cursors are signed as List's are.
+*/
+func (s *Store) ListCreatedBetween(ctx context.Context, from time.Time, to time.Time, limit int32, cursor string) (models.UserPage, error) {
+	if len(s.opts.CursorKey) == 0 {
+		return models.UserPage{}, errors.New("a cursor key is required to list users")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+	s.purge(p)
+
+	if limit <= 0 {
+		limit = userstore.DefaultPageSize
+	}
+	if limit > userstore.MaxPageSize {
+		limit = userstore.MaxPageSize
+	}
+
+	afterTime, afterID, err := s.decodeCreatedCursor(cursor)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+
+	users := []models.User{}
+	for _, user := range p.users {
+		if user.DeletedAt != nil || user.CreatedAt.Before(from) || !user.CreatedAt.Before(to) {
+			continue
+		}
+		if cursor != "" && (user.CreatedAt.Before(afterTime) || (user.CreatedAt.Equal(afterTime) && user.UserID <= afterID)) {
+			continue
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].UserID < users[j].UserID
+	})
+
+	page := models.UserPage{Users: []models.User{}}
+	page.Users = append(page.Users, users[:min(int(limit), len(users))]...)
+	if len(users) > int(limit) {
+		last := page.Users[limit-1]
+		page.Cursor = s.encodeCreatedCursor(last.CreatedAt, last.UserID)
+	}
+
+	return page, nil
+}
+
+func (s *Store) Put(ctx context.Context, record models.User) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+
+	record.TenantID = tenant.FromContext(ctx)
+	expectedVersion := record.Version
+	current, ok := s.getUser(p, record.UserID)
+	if expectedVersion == 0 && ok {
+		return models.User{}, userstore.ErrConflict
+	}
+	if expectedVersion != 0 && (!ok || current.Version != expectedVersion) {
+		return models.User{}, userstore.ErrConflict
+	}
+
+	now := s.opts.Now().UTC()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = now
+	}
+	record.UpdatedAt = now
+	record.Version = expectedVersion + 1
+
+	p.users[record.UserID] = record
+	s.record(ctx, p, models.HistoryPut, current, record)
+	return record, nil
+}
+
+func (s *Store) Create(ctx context.Context, record models.User) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+
+	record.TenantID = tenant.FromContext(ctx)
+	record.Email = strings.TrimSpace(record.Email)
+	// Checked in the same order as the DynamoDB store's transaction reports them
+	if !s.canReserve(p, record.Email, record.UserID) {
+		return models.User{}, userstore.ErrEmailTaken
+	}
+	if _, ok := s.getUser(p, record.UserID); ok {
+		return models.User{}, userstore.ErrConflict
+	}
+
+	now := s.opts.Now().UTC()
+	record.Version = 1
+	record.CreatedAt = now
+	record.UpdatedAt = now
+
+	p.users[record.UserID] = record
+	s.reserve(p, record.Email, record.UserID, nil)
+	s.record(ctx, p, models.HistoryCreate, models.User{}, record)
+	return record, nil
+}
+
+func (s *Store) Update(ctx context.Context, id string, changes models.UserUpdate, expectedVersion int64) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+
+	current, ok := s.getUser(p, id)
+	if !ok || current.DeletedAt != nil {
+		return models.User{}, userstore.ErrNotFound
+	}
+
+	if expectedVersion != 0 && current.Version != expectedVersion {
+		return models.User{}, userstore.ErrConflict
+	}
+
+	updated := current
+	if changes.Email != nil {
+		updated.Email = strings.TrimSpace(*changes.Email)
+	}
+	if updated == current {
+		return current, nil
+	}
+	updated.Version = current.Version + 1
+	updated.UpdatedAt = s.opts.Now().UTC()
+
+	if s.emailKey(updated.Email) != s.emailKey(current.Email) {
+		if !s.canReserve(p, current.Email, id) {
+			return models.User{}, userstore.ErrConflict
+		}
+		if !s.canReserve(p, updated.Email, id) {
+			return models.User{}, userstore.ErrEmailTaken
+		}
+		delete(p.reservations, s.emailKey(current.Email))
+		s.reserve(p, updated.Email, id, nil)
+	}
+
+	p.users[id] = updated
+	s.record(ctx, p, models.HistoryUpdate, current, updated)
+	return updated, nil
+}
+
+func (s *Store) Delete(ctx context.Context, id string, expectedVersion int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+
+	current, ok := s.getUser(p, id)
+	if !ok || current.DeletedAt != nil {
+		return "", userstore.ErrNotFound
+	}
+
+	if expectedVersion != 0 && current.Version != expectedVersion {
+		return "", userstore.ErrConflict
+	}
+
+	if !s.canReserve(p, current.Email, id) {
+		return "", userstore.ErrConflict
+	}
+
+	now := s.opts.Now().UTC()
+	deleted := current
+	deleted.DeletedAt = &now
+	deleted.Version = current.Version + 1
+	deleted.UpdatedAt = now
+
+	if s.opts.DeletedEmailPolicy == userstore.HoldEmail {
+		expires := now.Add(s.opts.RestoreWindow)
+		s.reserve(p, current.Email, id, &expires)
+	} else {
+		delete(p.reservations, s.emailKey(current.Email))
+	}
+
+	p.users[id] = deleted
+	s.record(ctx, p, models.HistoryDelete, current, deleted)
+	return id, nil
+}
+
+func (s *Store) Restore(ctx context.Context, id string, expectedVersion int64) (models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+
+	current, ok := s.getUser(p, id)
+	if !ok {
+		return models.User{}, userstore.ErrNotFound
+	}
+
+	if current.DeletedAt == nil {
+		return models.User{}, userstore.ErrNotDeleted
+	}
+
+	if expectedVersion != 0 && current.Version != expectedVersion {
+		return models.User{}, userstore.ErrConflict
+	}
+
+	if !s.canReserve(p, current.Email, id) {
+		return models.User{}, userstore.ErrEmailTaken
+	}
+
+	restored := current
+	restored.DeletedAt = nil
+	restored.Version = current.Version + 1
+	restored.UpdatedAt = s.opts.Now().UTC()
+
+	s.reserve(p, restored.Email, id, nil)
+	p.users[id] = restored
+	s.record(ctx, p, models.HistoryRestore, current, restored)
+	return restored, nil
+}
+
+func (s *Store) BatchGetByIDs(ctx context.Context, ids []string, opts ...userstore.ReadOption) ([]userstore.BatchGetResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+
+	o := userstore.NewReadOptions(opts...)
+	results := make([]userstore.BatchGetResult, len(ids))
+	for i, id := range ids {
+		user, ok := s.getUser(p, id)
+		if !ok || (user.DeletedAt != nil && !o.IncludeDeleted) {
+			results[i].Err = userstore.ErrNotFound
+			continue
+		}
+		results[i].User = user
+	}
+
+	return results, nil
+}
+
+// BatchPut creates the given users along with their email reservations, reporting users whose id already exists
+// with ErrConflict in the same way as the DynamoDB store
+func (s *Store) BatchPut(ctx context.Context, records []models.User) ([]userstore.BatchPutResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+	s.purge(p)
+
+	results := make([]userstore.BatchPutResult, len(records))
+	now := s.opts.Now().UTC()
+
+	ids := map[string]bool{}
+	emails := map[string]bool{}
+	for i, record := range records {
+		record.TenantID = tenant.FromContext(ctx)
+		record.Email = strings.TrimSpace(record.Email)
+		email := s.emailKey(record.Email)
+		if ids[record.UserID] || emails[email] {
+			results[i].Err = userstore.ErrConflict
+			continue
+		}
+		ids[record.UserID] = true
+		emails[email] = true
+
+		// Checked in the same order as the DynamoDB store's transaction reports them
+		if !s.canReserve(p, record.Email, record.UserID) {
+			results[i].Err = userstore.ErrEmailTaken
+			continue
+		}
+		if _, ok := p.users[record.UserID]; ok {
+			results[i].Err = userstore.ErrConflict
+			continue
+		}
+
+		if record.CreatedAt.IsZero() {
+			record.CreatedAt = now
+		}
+		record.UpdatedAt = now
+		record.Version = 1
+		results[i].User = record
+	}
+
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		p.users[result.User.UserID] = result.User
+		s.reserve(p, result.User.Email, result.User.UserID, nil)
+		s.record(ctx, p, models.HistoryCreate, models.User{}, result.User)
+	}
+
+	return results, nil
+}
+
+/*
+History returns a page of at most limit of the user's history entries, newest first. History is kept for
+deleted users, even once they have been purged. Cursors are signed with the store's cursor key, and are only
+accepted for the user they were issued for.
+*/
+func (s *Store) History(ctx context.Context, id string, limit int32, cursor string) (models.HistoryPage, error) {
+	if len(s.opts.CursorKey) == 0 {
+		return models.HistoryPage{}, errors.New("a cursor key is required to list history")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.partition(ctx)
+
+	if limit <= 0 {
+		limit = userstore.DefaultPageSize
+	}
+	if limit > userstore.MaxPageSize {
+		limit = userstore.MaxPageSize
+	}
+
+	history := p.history[id]
+	// Entries are only ever appended, so the index of the last entry returned stays valid between pages
+	before := len(history)
+	if cursor != "" {
+		b, err := userstore.VerifyCursor(s.opts.CursorKey, cursor)
+		if err != nil {
+			return models.HistoryPage{}, err
+		}
+		rest, ok := strings.CutPrefix(string(b), "history/"+id+"/")
+		if !ok {
+			return models.HistoryPage{}, userstore.ErrInvalidCursor
+		}
+		before, err = strconv.Atoi(rest)
+		if err != nil || before < 0 || before > len(history) {
+			return models.HistoryPage{}, userstore.ErrInvalidCursor
+		}
+	}
+
+	page := models.HistoryPage{Entries: []models.HistoryEntry{}}
+	i := before - 1
+	for ; i >= 0 && int32(len(page.Entries)) < limit; i-- {
+		page.Entries = append(page.Entries, history[i])
+	}
+	if i >= 0 {
+		page.Cursor = userstore.SignCursor(s.opts.CursorKey, []byte(fmt.Sprintf("history/%s/%d", id, i+1)))
+	}
+
+	return page, nil
+}
+
+// record appends the change from before to after to the user's history
+func (s *Store) record(ctx context.Context, p *partition, action models.HistoryAction, before models.User, after models.User) {
+	p.history[after.UserID] = append(p.history[after.UserID], userstore.NewHistoryEntry(ctx, action, before, after))
+}
+
+// getUser returns the user with the given id, including soft-deleted users that haven't yet expired
+func (s *Store) getUser(p *partition, id string) (models.User, bool) {
+	s.purge(p)
+	user, ok := p.users[id]
+	return user, ok
+}
+
+// purge removes the partition's soft-deleted users and held emails whose restore window has passed
+func (s *Store) purge(p *partition) {
+	now := s.opts.Now()
+	for id, user := range p.users {
+		if user.DeletedAt != nil && !now.Before(user.DeletedAt.Add(s.opts.RestoreWindow)) {
+			delete(p.users, id)
+		}
+	}
+	for key, r := range p.reservations {
+		if r.expires != nil && !now.Before(*r.expires) {
+			delete(p.reservations, key)
+		}
+	}
+}
+
+// canReserve reports whether the email is free or already belongs to the given user
+func (s *Store) canReserve(p *partition, email string, userID string) bool {
+	r, ok := p.reservations[s.emailKey(email)]
+	return !ok || r.userID == userID
+}
+
+func (s *Store) reserve(p *partition, email string, userID string, expires *time.Time) {
+	p.reservations[s.emailKey(email)] = reservation{userID: userID, expires: expires}
+}
+
+func (s *Store) emailKey(email string) string {
+	return s.opts.EmailPolicy.Normalize(email)
+}
+
+func (s *Store) encodeCursor(lastID string) string {
+	return userstore.SignCursor(s.opts.CursorKey, []byte("user/"+lastID))
+}
+
+func (s *Store) decodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	b, err := userstore.VerifyCursor(s.opts.CursorKey, cursor)
+	if err != nil {
+		return "", err
+	}
+	id, ok := strings.CutPrefix(string(b), "user/")
+	if !ok {
+		return "", userstore.ErrInvalidCursor
+	}
+	return id, nil
+}
+
+func (s *Store) encodeCreatedCursor(createdAt time.Time, lastID string) string {
+	return userstore.SignCursor(s.opts.CursorKey, []byte("created/"+createdAt.UTC().Format(time.RFC3339Nano)+"/"+lastID))
+}
+
+func (s *Store) decodeCreatedCursor(cursor string) (time.Time, string, error) {
+	if cursor == "" {
+		return time.Time{}, "", nil
+	}
+	b, err := userstore.VerifyCursor(s.opts.CursorKey, cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	rest, ok := strings.CutPrefix(string(b), "created/")
+	if !ok {
+		return time.Time{}, "", userstore.ErrInvalidCursor
+	}
+	at, id, ok := strings.Cut(rest, "/")
+	if !ok {
+		return time.Time{}, "", userstore.ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return time.Time{}, "", userstore.ErrInvalidCursor
+	}
+	return createdAt, id, nil
+}
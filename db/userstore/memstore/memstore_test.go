@@ -0,0 +1,46 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/storetest"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, opts ...userstore.Option) userstore.Store {
+		opts = append([]userstore.Option{userstore.WithCursorKey([]byte("test-cursor-key"))}, opts...)
+		return NewStore(opts...)
+	})
+}
+
+func TestConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = store.Create(ctx, models.User{UserID: fmt.Sprint(i), Email: "race@example.com"})
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+		assert.ErrorIs(t, err, userstore.ErrEmailTaken)
+	}
+	assert.Equal(t, 1, created)
+}
@@ -0,0 +1,35 @@
+package userstore
+
+import (
+	"context"
+)
+
+type EmailMigrationReport struct {
+	// Scanned is the number of user items examined
+	Scanned int
+	// Rekeyed is the number of users whose email keys were rewritten
+	Rekeyed int
+	// Collisions are groups of users that were left untouched because they share a normalised email
+	Collisions []EmailCollision
+	// Failed holds the users that couldn't be rekeyed, by partition key
+	Failed map[string]error
+}
+
+/*
+MigrateEmailKeys rewrites the email index key and reservation of every user whose keys weren't built from
+their normalised email, for instance because they were written before normalisation was introduced or under a
+different email policy. Users whose emails normalise to the same address can't all hold a reservation, so they
+are reported as collisions and left for someone to resolve by hand. The migration can be safely re-run.
+
+Deprecated: MigrateEmailKeys is RepairEmailIndex without a dry run or rate limit, which also repairs the keys
+written by the old Put. Use RepairEmailIndex instead.
+*/
+func (store UserStore) MigrateEmailKeys(ctx context.Context) (EmailMigrationReport, error) {
+	report, err := store.RepairEmailIndex(ctx, EmailRepairOptions{})
+	return EmailMigrationReport{
+		Scanned:    report.Scanned,
+		Rekeyed:    report.Repaired,
+		Collisions: report.Collisions,
+		Failed:     report.Failed,
+	}, err
+}
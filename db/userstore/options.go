@@ -0,0 +1,157 @@
+package userstore
+
+import (
+	"time"
+
+	"github.com/benjaminkitson/bk-user-api/emailaddr"
+	"github.com/benjaminkitson/bk-user-api/pii"
+)
+
+// DefaultRestoreWindow is how long soft-deleted users can be restored for before they are purged
+const DefaultRestoreWindow = 30 * 24 * time.Hour
+
+// DeletedEmailPolicy determines what happens to a user's email reservation when the user is soft-deleted
+type DeletedEmailPolicy int
+
+const (
+	// HoldEmail keeps the email reserved until the user is purged, so that restoring the user can't fail
+	HoldEmail DeletedEmailPolicy = iota
+	// ReleaseEmail frees the email for other users as soon as the user is deleted. Restoring the user will
+	// fail with ErrEmailTaken if the email has since been reused.
+	ReleaseEmail
+)
+
+// Option configures optional behaviour of a store
+type Option func(*Options)
+
+/*
+Options holds the behaviour that can be configured on a store. It is exported so that every Store
+implementation can be configured with the same options and behave the same way.
+*/
+type Options struct {
+	// Now is the clock used to timestamp records and decide when deleted users expire
+	Now                func() time.Time
+	CursorKey          []byte
+	RestoreWindow      time.Duration
+	DeletedEmailPolicy DeletedEmailPolicy
+	EmailPolicy        emailaddr.Policy
+	// Outbox makes every write also append the event it causes to the outbox, for RelayOutbox to publish. Only
+	// the DynamoDB store has an outbox.
+	Outbox bool
+	// Cipher encrypts users' personal data at rest, with emails keyed by BlindIndex rather than in plaintext. Only
+	// the DynamoDB store encrypts.
+	Cipher     *pii.Cipher
+	BlindIndex pii.BlindIndex
+	// Retry controls retries and operation deadlines. Only the DynamoDB store retries.
+	Retry RetryPolicy
+}
+
+// NewOptions resolves a set of options on top of the defaults
+func NewOptions(opts ...Option) Options {
+	o := Options{
+		Now:                time.Now,
+		RestoreWindow:      DefaultRestoreWindow,
+		DeletedEmailPolicy: HoldEmail,
+		EmailPolicy:        emailaddr.DefaultPolicy,
+		Retry:              DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithClock sets the clock used by the store, which is mostly useful in tests
+func WithClock(now func() time.Time) Option {
+	return func(o *Options) {
+		o.Now = now
+	}
+}
+
+// WithCursorKey sets the key used to sign pagination cursors, which is required for listing users
+func WithCursorKey(key []byte) Option {
+	return func(o *Options) {
+		o.CursorKey = key
+	}
+}
+
+// WithRestoreWindow sets how long soft-deleted users are kept before they are purged
+func WithRestoreWindow(d time.Duration) Option {
+	return func(o *Options) {
+		o.RestoreWindow = d
+	}
+}
+
+// WithDeletedEmailPolicy sets whether deleted users' emails are held or released
+func WithDeletedEmailPolicy(p DeletedEmailPolicy) Option {
+	return func(o *Options) {
+		o.DeletedEmailPolicy = p
+	}
+}
+
+// WithEmailPolicy sets how emails are normalised when building the keys used for lookups and uniqueness
+func WithEmailPolicy(p emailaddr.Policy) Option {
+	return func(o *Options) {
+		o.EmailPolicy = p
+	}
+}
+
+// WithOutbox makes the store append an event to the outbox in the same transaction as each write
+func WithOutbox() Option {
+	return func(o *Options) {
+		o.Outbox = true
+	}
+}
+
+/*
+WithEncryption makes the store encrypt users' personal data, and key their emails by the blind index. Once a
+table holds encrypted data every store reading it needs the cipher, and existing tables need RotateKeys run to
+encrypt the users already in them.
+*/
+func WithEncryption(cipher *pii.Cipher, index pii.BlindIndex) Option {
+	return func(o *Options) {
+		o.Cipher = cipher
+		o.BlindIndex = index
+	}
+}
+
+// WithRetryPolicy sets how failed requests are retried, and how long each operation can take
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *Options) {
+		o.Retry = p
+	}
+}
+
+// ReadOption configures a single read from the store
+type ReadOption func(*ReadOptions)
+
+type ReadOptions struct {
+	// IncludeDeleted returns soft-deleted users rather than treating them as not found
+	IncludeDeleted bool
+	// ConsistentRead requires the read to reflect every write that completed before it, bypassing any cache
+	ConsistentRead bool
+}
+
+// IncludeDeleted makes a read return soft-deleted users
+func IncludeDeleted() ReadOption {
+	return func(o *ReadOptions) {
+		o.IncludeDeleted = true
+	}
+}
+
+// ConsistentRead makes a read strongly consistent. The stores' own reads already are, so this only matters to
+// stores that cache or replicate, such as the cache package's.
+func ConsistentRead() ReadOption {
+	return func(o *ReadOptions) {
+		o.ConsistentRead = true
+	}
+}
+
+// NewReadOptions resolves a set of read options
+func NewReadOptions(opts ...ReadOption) ReadOptions {
+	o := ReadOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
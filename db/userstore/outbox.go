@@ -0,0 +1,293 @@
+package userstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/benjaminkitson/bk-user-api/userevents"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	outboxEventKey     = "event"
+	outboxAttemptsKey  = "attempts"
+	outboxNextKey      = "nextAttemptAt"
+	outboxLastErrorKey = "lastError"
+
+	DefaultRelayBaseDelay = time.Second
+	DefaultRelayMaxDelay  = 15 * time.Minute
+	// DefaultDeliveredRetention is how long delivered outbox items are kept before DynamoDB's TTL purges them
+	DefaultDeliveredRetention = 7 * 24 * time.Hour
+)
+
+type RelayOptions struct {
+	// MaxItems bounds the number of items published by a single run, so that it finishes within its Lambda's
+	// timeout. Zero means no limit.
+	MaxItems int
+	// BaseDelay and MaxDelay bound the exponential backoff between attempts to publish an item
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// DeliveredRetention is how long delivered items are kept for
+	DeliveredRetention time.Duration
+}
+
+type RelayReport struct {
+	// Published is the number of events published
+	Published int
+	// Failed is the number of events that couldn't be published, which will be retried after a backoff
+	Failed int
+	// Deferred is the number of events left for a later run, either because they are backing off or because
+	// an earlier event for the same user hasn't been published yet
+	Deferred int
+}
+
+// outboxEventType returns the type of event announcing the change made by the action
+func outboxEventType(action models.HistoryAction, before models.User) userevents.Type {
+	switch action {
+	case models.HistoryCreate:
+		return userevents.UserCreated
+	case models.HistoryDelete:
+		return userevents.UserDeleted
+	case models.HistoryPut:
+		if before == (models.User{}) {
+			return userevents.UserCreated
+		}
+	}
+	return userevents.UserUpdated
+}
+
+/*
+outboxItem builds the outbox item for the change from before to after. It lives in the user's partition, and
+is indexed in GSI2 under the user's outbox shard until it has been delivered.
+*/
+func (store UserStore) outboxItem(ctx context.Context, action models.HistoryAction, before models.User, after models.User) (map[string]types.AttributeValue, error) {
+	var previous *models.User
+	if before != (models.User{}) {
+		previous = &before
+	}
+	event := userevents.New(uuid.New().String(), outboxEventType(action, before), after.UpdatedAt, after, previous)
+	b, err := json.Marshal(event)
+	if err != nil {
+		return nil, errors.Wrap(err, "an error ocurred marshaling the event")
+	}
+	sealed, err := store.seal(ctx, after.UserID, outboxEventKey, string(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]types.AttributeValue{
+		PKKey:              &types.AttributeValueMemberS{Value: store.getUserPK(ctx, after.UserID)},
+		SKKey:              &types.AttributeValueMemberS{Value: schema.OutboxSK(after.UpdatedAt, after.Version)},
+		schema.GSI2Key:     &types.AttributeValueMemberS{Value: schema.OutboxShard(after.TenantID, after.UserID)},
+		schema.GSI2SortKey: &types.AttributeValueMemberS{Value: schema.OutboxOrderKey(after.TenantID, after.UserID, after.Version)},
+		outboxEventKey:     &types.AttributeValueMemberS{Value: sealed},
+		outboxAttemptsKey:  &types.AttributeValueMemberN{Value: "0"},
+	}, nil
+}
+
+/*
+RelayOutbox publishes the events waiting in the outbox, marking each one delivered once it has been published.
+Events are delivered at least once: an event is published again if it can't be marked delivered, and consumers
+should use the event ids to drop duplicates.
+
+Each user's events are published in the order they happened. An event that fails to publish is retried by a
+later run after an exponential backoff, and until it succeeds the user's later events are held back. Events are
+never given up on, so an event that can't be published holds up its user indefinitely, and shows up as a
+failure in every run's report.
+*/
+func (store UserStore) RelayOutbox(ctx context.Context, publisher userevents.Publisher, opts RelayOptions) (RelayReport, error) {
+	if opts.BaseDelay == 0 {
+		opts.BaseDelay = DefaultRelayBaseDelay
+	}
+	if opts.MaxDelay == 0 {
+		opts.MaxDelay = DefaultRelayMaxDelay
+	}
+	if opts.DeliveredRetention == 0 {
+		opts.DeliveredRetention = DefaultDeliveredRetention
+	}
+
+	report := RelayReport{}
+	for _, shard := range schema.OutboxShardKeys() {
+		err := store.relayShard(ctx, shard, publisher, opts, &report)
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func (store UserStore) relayShard(ctx context.Context, shard string, publisher userevents.Publisher, opts RelayOptions, report *RelayReport) error {
+	// Users whose remaining events must wait for an earlier one. Shards hold the users of every tenant, so users are
+	// keyed by partition key rather than id.
+	blocked := map[string]bool{}
+
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := store.client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(store.tableName),
+			IndexName:              aws.String(schema.GSI2),
+			KeyConditionExpression: aws.String("#shard = :shard"),
+			ExpressionAttributeNames: map[string]string{
+				"#shard": schema.GSI2Key,
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":shard": &types.AttributeValueMemberS{Value: shard},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return classifyError(err)
+		}
+
+		for _, item := range out.Items {
+			event, attempts, next, err := store.unmarshalOutboxItem(ctx, item)
+			if err != nil {
+				return err
+			}
+			user := item[PKKey].(*types.AttributeValueMemberS).Value
+
+			if blocked[user] || store.now().Before(next) || (opts.MaxItems > 0 && report.Published+report.Failed >= opts.MaxItems) {
+				blocked[user] = true
+				report.Deferred++
+				continue
+			}
+
+			err = publisher.Publish(ctx, event)
+			if err != nil {
+				blocked[user] = true
+				report.Failed++
+				err = store.markOutboxFailed(ctx, item, attempts+1, err, opts)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			report.Published++
+			err = store.markOutboxDelivered(ctx, item, opts)
+			if err != nil {
+				return err
+			}
+		}
+
+		startKey = out.LastEvaluatedKey
+		if len(startKey) == 0 {
+			return nil
+		}
+	}
+}
+
+func (store UserStore) unmarshalOutboxItem(ctx context.Context, item map[string]types.AttributeValue) (userevents.Event, int, time.Time, error) {
+	var event userevents.Event
+	pk, _ := item[PKKey].(*types.AttributeValueMemberS)
+	s, _ := item[outboxEventKey].(*types.AttributeValueMemberS)
+	if pk == nil || s == nil {
+		return event, 0, time.Time{}, errors.New("outbox item has no event")
+	}
+	tenantID, userID, ok := schema.ParseUserPK(pk.Value)
+	if !ok {
+		return event, 0, time.Time{}, fmt.Errorf("%q is not a user's partition key", pk.Value)
+	}
+	// Items written before encryption was enabled hold the event as plain JSON
+	b, err := store.open(tenant.WithTenant(ctx, tenantID), userID, outboxEventKey, s.Value)
+	if err != nil {
+		return event, 0, time.Time{}, err
+	}
+	err = json.Unmarshal([]byte(b), &event)
+	if err != nil {
+		return event, 0, time.Time{}, errors.Wrap(err, "an error ocurred unmarshaling the event")
+	}
+
+	attempts := 0
+	if n, ok := item[outboxAttemptsKey].(*types.AttributeValueMemberN); ok {
+		attempts, _ = strconv.Atoi(n.Value)
+	}
+
+	var next time.Time
+	if n, ok := item[outboxNextKey].(*types.AttributeValueMemberN); ok {
+		unix, _ := strconv.ParseInt(n.Value, 10, 64)
+		next = time.Unix(unix, 0)
+	}
+
+	return event, attempts, next, nil
+}
+
+/*
+markOutboxDelivered removes the item from GSI2 and sets it to expire. It is conditional on the item still being
+pending, as the index is eventually consistent and so can return items that have already been delivered.
+*/
+func (store UserStore) markOutboxDelivered(ctx context.Context, item map[string]types.AttributeValue, opts RelayOptions) error {
+	now := store.now()
+	_, err := store.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(store.tableName),
+		Key:                 outboxKey(item),
+		UpdateExpression:    aws.String("SET #deliveredAt = :now, #ttl = :ttl REMOVE #shard, #order"),
+		ConditionExpression: aws.String("attribute_exists(#shard)"),
+		ExpressionAttributeNames: map[string]string{
+			"#deliveredAt": "deliveredAt",
+			"#ttl":         TTLKey,
+			"#shard":       schema.GSI2Key,
+			"#order":       schema.GSI2SortKey,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339Nano)},
+			":ttl": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(opts.DeliveredRetention).Unix(), 10)},
+		},
+	})
+	return ignoreConditionFailed(err)
+}
+
+// markOutboxFailed records the failed attempt, and when the item should next be attempted
+func (store UserStore) markOutboxFailed(ctx context.Context, item map[string]types.AttributeValue, attempts int, cause error, opts RelayOptions) error {
+	delay := opts.BaseDelay << min(attempts-1, 30)
+	if delay > opts.MaxDelay || delay <= 0 {
+		delay = opts.MaxDelay
+	}
+
+	_, err := store.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(store.tableName),
+		Key:                 outboxKey(item),
+		UpdateExpression:    aws.String("SET #attempts = :attempts, #next = :next, #lastError = :lastError"),
+		ConditionExpression: aws.String("attribute_exists(#shard)"),
+		ExpressionAttributeNames: map[string]string{
+			"#attempts":  outboxAttemptsKey,
+			"#next":      outboxNextKey,
+			"#lastError": outboxLastErrorKey,
+			"#shard":     schema.GSI2Key,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":attempts":  &types.AttributeValueMemberN{Value: strconv.Itoa(attempts)},
+			":next":      &types.AttributeValueMemberN{Value: strconv.FormatInt(store.now().Add(delay).Unix(), 10)},
+			":lastError": &types.AttributeValueMemberS{Value: cause.Error()},
+		},
+	})
+	return ignoreConditionFailed(err)
+}
+
+func outboxKey(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		PKKey: item[PKKey],
+		SKKey: item[SKKey],
+	}
+}
+
+func ignoreConditionFailed(err error) error {
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	if err != nil {
+		return classifyError(err)
+	}
+	return nil
+}
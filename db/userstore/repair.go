@@ -0,0 +1,276 @@
+package userstore
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+)
+
+type EmailRepairOptions struct {
+	// DryRun reports what would be repaired without writing anything
+	DryRun bool
+	// WritesPerSecond limits how quickly repairs are written, to leave capacity for live traffic. Zero means no
+	// limit.
+	WritesPerSecond float64
+}
+
+// EmailRepair describes a user whose email keys don't match their email
+type EmailRepair struct {
+	TenantID string
+	UserID   string
+	// StoredKey is the user's email index key as found, which is empty if it was missing
+	StoredKey string
+	// CanonicalKey is the key the user should be indexed by, which is empty for users that shouldn't be indexed
+	CanonicalKey string
+	// MissingReservation is set when the user's email wasn't reserved for them
+	MissingReservation bool
+}
+
+// EmailCollision is a set of users whose emails normalise to the same address
+type EmailCollision struct {
+	TenantID        string
+	NormalizedEmail string
+	UserIDs         []string
+}
+
+type EmailRepairReport struct {
+	// Scanned is the number of user items examined
+	Scanned int
+	// Repairs are the users whose keys needed repairing, whether or not they were repaired
+	Repairs []EmailRepair
+	// Repaired is the number of users whose keys were rewritten, which is always zero for a dry run
+	Repaired int
+	// Collisions are groups of users that were left untouched because they share a normalised email
+	Collisions []EmailCollision
+	// Failed holds the users that couldn't be repaired, by partition key
+	Failed map[string]error
+}
+
+/*
+RepairEmailIndex scans the table, across every tenant, for users whose email index key or reservation doesn't match their email, and
+rewrites them. That covers users written by Put before it built the index key properly, users written before
+emails were normalised or under a different email policy, and users that were never given a reservation.
+
+Users whose emails normalise to the same address, or whose email is reserved by someone else, can't all hold
+a reservation, so they are reported as collisions and left for someone to resolve by hand. Each user is
+repaired in its own transaction, conditional on the user not having changed since it was scanned, so the
+repair can be safely re-run.
+*/
+func (store UserStore) RepairEmailIndex(ctx context.Context, opts EmailRepairOptions) (EmailRepairReport, error) {
+	report := EmailRepairReport{Failed: map[string]error{}}
+
+	type scanned struct {
+		item map[string]types.AttributeValue
+		user models.User
+	}
+
+	groups := map[string][]scanned{}
+	unindexed := []scanned{}
+	reservations := map[string]string{}
+	err := store.scanItems(ctx, func(item map[string]types.AttributeValue) error {
+		pk, _ := item[PKKey].(*types.AttributeValueMemberS)
+		sk, _ := item[SKKey].(*types.AttributeValueMemberS)
+		if pk == nil || sk == nil {
+			return nil
+		}
+
+		if sk.Value == schema.EmailSK {
+			var r struct {
+				UserID string `dynamodbav:"userID"`
+			}
+			err := attributevalue.UnmarshalMap(item, &r)
+			if err != nil {
+				return err
+			}
+			reservations[pk.Value] = r.UserID
+			return nil
+		}
+
+		// History items share the user's partition
+		if sk.Value != schema.UserSK {
+			return nil
+		}
+
+		user, err := store.UnmarshalUser(ctx, item)
+		if err != nil {
+			return err
+		}
+		report.Scanned++
+
+		// Users deleted under the release policy no longer hold their email
+		if user.DeletedAt != nil && store.deletedEmailPolicy == ReleaseEmail {
+			unindexed = append(unindexed, scanned{item: item, user: user})
+			return nil
+		}
+		key := store.getEmailPK(tenant.WithTenant(ctx, user.TenantID), user.Email)
+		groups[key] = append(groups[key], scanned{item: item, user: user})
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pending := []scanned{}
+	for _, key := range keys {
+		group := groups[key]
+		ids := []string{}
+		for _, s := range group {
+			ids = append(ids, s.user.UserID)
+		}
+		// The email may also be reserved by someone else entirely, such as a user deleted under the release
+		// policy whose email has since been reused
+		if holder, ok := reservations[key]; ok && !slices.Contains(ids, holder) {
+			ids = append(ids, holder)
+		}
+		if len(ids) > 1 {
+			sort.Strings(ids)
+			report.Collisions = append(report.Collisions, EmailCollision{
+				TenantID:        group[0].user.TenantID,
+				NormalizedEmail: store.emailPolicy.Normalize(group[0].user.Email),
+				UserIDs:         ids,
+			})
+			continue
+		}
+		pending = append(pending, group[0])
+	}
+	pending = append(pending, unindexed...)
+
+	var throttle <-chan time.Time
+	if opts.WritesPerSecond > 0 && !opts.DryRun {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.WritesPerSecond))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	for _, s := range pending {
+		// Each user's keys are built for the tenant they belong to
+		ctx := tenant.WithTenant(ctx, s.user.TenantID)
+		repair := store.emailRepair(ctx, s.item, s.user, reservations)
+		if repair == nil {
+			continue
+		}
+		report.Repairs = append(report.Repairs, *repair)
+		if opts.DryRun {
+			continue
+		}
+
+		if throttle != nil {
+			select {
+			case <-ctx.Done():
+				return report, ctx.Err()
+			case <-throttle:
+			}
+		}
+
+		err := store.repairEmail(ctx, s.item, s.user, *repair, reservations)
+		if err != nil {
+			report.Failed[store.getUserPK(ctx, s.user.UserID)] = err
+			continue
+		}
+		report.Repaired++
+	}
+
+	return report, nil
+}
+
+// emailRepair works out what needs repairing for the user, returning nil if nothing does
+func (store UserStore) emailRepair(ctx context.Context, item map[string]types.AttributeValue, user models.User, reservations map[string]string) *EmailRepair {
+	repair := EmailRepair{TenantID: user.TenantID, UserID: user.UserID}
+	if v, ok := item[GSI1Key].(*types.AttributeValueMemberS); ok {
+		repair.StoredKey = v.Value
+	}
+	if user.DeletedAt == nil || store.deletedEmailPolicy == HoldEmail {
+		repair.CanonicalKey = store.getUserGSI1(ctx, user.Email)
+		repair.MissingReservation = reservations[store.getEmailPK(ctx, user.Email)] != user.UserID
+	}
+
+	if repair.StoredKey == repair.CanonicalKey && !repair.MissingReservation {
+		return nil
+	}
+	return &repair
+}
+
+/*
+repairEmail rewrites the user's email index key to the canonical one and reserves their email, releasing the
+reservation under their old key if they held one.
+*/
+func (store UserStore) repairEmail(ctx context.Context, item map[string]types.AttributeValue, user models.User, repair EmailRepair, reservations map[string]string) error {
+	writes := []types.TransactWriteItem{}
+	if repair.StoredKey != repair.CanonicalKey {
+		update, err := store.buildUpdate(ctx, item, user.Version, user)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, types.TransactWriteItem{Update: update})
+	}
+
+	reservationIndex := -1
+	if repair.CanonicalKey != "" {
+		var expiry *int64
+		if user.DeletedAt != nil {
+			e := store.expiry(*user.DeletedAt)
+			expiry = &e
+		}
+		reservationIndex = len(writes)
+		writes = append(writes, store.reserveEmail(ctx, user.Email, user.UserID, expiry))
+	}
+
+	// Reservations are written under the same key as the index, so release the old one if the user held it
+	old := repair.StoredKey
+	if old != "" && old != repair.CanonicalKey && reservations[old] == user.UserID {
+		writes = append(writes, store.releaseReservation(old, user.UserID))
+	}
+
+	_, err := store.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: writes,
+	})
+	if err != nil {
+		if reservationIndex >= 0 && cancellationReason(err, reservationIndex) == "ConditionalCheckFailed" {
+			return wrapError(ErrEmailTaken, err)
+		}
+		return classifyError(err)
+	}
+
+	return nil
+}
+
+// scanItems calls fn with every item in the table
+func (store UserStore) scanItems(ctx context.Context, fn func(item map[string]types.AttributeValue) error) error {
+	var startKey map[string]types.AttributeValue
+	for {
+		out, err := store.client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:         aws.String(store.tableName),
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return classifyError(err)
+		}
+
+		for _, item := range out.Items {
+			err = fn(item)
+			if err != nil {
+				return err
+			}
+		}
+
+		startKey = out.LastEvaluatedKey
+		if len(startKey) == 0 {
+			return nil
+		}
+	}
+}
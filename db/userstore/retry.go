@@ -0,0 +1,83 @@
+package userstore
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+/*
+RetryPolicy controls how hard the DynamoDB store tries before giving up on a request, and how long it lets each
+operation run. Requests that fail with a retryable error, such as throttling, are retried after an exponential
+backoff with full jitter, as are the items a batch request leaves unprocessed.
+*/
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is made before its error is returned, including the first
+	MaxAttempts int
+	// BaseDelay and MaxDelay bound the backoff between attempts
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// OperationTimeout bounds how long a single operation can take, including its retries. Zero means no limit.
+	OperationTimeout time.Duration
+	/*
+		DeadlineReserve is how much of the caller's remaining time is left over when an operation's deadline is
+		derived from the context, such as a Lambda's, so that a handler whose operation timed out still has time to
+		respond.
+	*/
+	DeadlineReserve time.Duration
+}
+
+// DefaultRetryPolicy is the policy stores use unless they are given another
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:      5,
+	BaseDelay:        50 * time.Millisecond,
+	MaxDelay:         2 * time.Second,
+	OperationTimeout: 10 * time.Second,
+	DeadlineReserve:  500 * time.Millisecond,
+}
+
+// backoff returns an exponentially increasing delay for the given retry attempt, with full jitter
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << min(attempt, 30)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// retryer returns the SDK retryer implementing the policy, which keeps the SDK's choice of retryable errors
+func (p RetryPolicy) retryer() aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = max(p.MaxAttempts, 1)
+		o.Backoff = retry.BackoffDelayerFunc(func(attempt int, err error) (time.Duration, error) {
+			return p.backoff(attempt), nil
+		})
+	})
+}
+
+/*
+withDeadline returns a context for a single operation, which expires after the policy's operation timeout or
+once all but the reserve of the caller's remaining time has passed, whichever is sooner. The operation fails
+with ErrTimeout if it runs out of time.
+*/
+func (p RetryPolicy) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := p.OperationTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		// A caller that is already out of time fails the operation straight away
+		remaining := max(time.Until(deadline)-p.DeadlineReserve, 0)
+		if timeout == 0 || remaining < timeout {
+			timeout = remaining
+		}
+		return context.WithTimeout(ctx, timeout)
+	}
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
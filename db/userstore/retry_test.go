@@ -0,0 +1,46 @@
+package userstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithDeadline(t *testing.T) {
+	p := RetryPolicy{OperationTimeout: time.Minute, DeadlineReserve: time.Second}
+
+	ctx, cancel := p.withDeadline(context.Background())
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	// A caller with less time left than the timeout leaves the reserve free
+	parent, cancelParent := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelParent()
+	ctx, cancel = p.withDeadline(parent)
+	defer cancel()
+	deadline, ok = ctx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(9*time.Second), deadline, time.Second)
+
+	// A caller within its reserve is out of time
+	parent, cancelParent = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancelParent()
+	ctx, cancel = p.withDeadline(parent)
+	defer cancel()
+	<-ctx.Done()
+	assert.ErrorIs(t, classifyError(ctx.Err()), ErrTimeout)
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+	for attempt := 0; attempt < 40; attempt++ {
+		d := p.backoff(attempt)
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.Less(t, d, p.MaxDelay)
+	}
+}
@@ -0,0 +1,30 @@
+package userstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/benjaminkitson/bk-user-api/models"
+)
+
+/*
+Store is the set of operations every user store supports. UserStore is the DynamoDB implementation, and any
+other implementation is expected to return the same errors in the same situations, which is checked by the
+conformance suite in the storetest package.
+*/
+type Store interface {
+	GetByID(ctx context.Context, id string, opts ...ReadOption) (models.User, error)
+	GetByEmail(ctx context.Context, email string, opts ...ReadOption) (models.User, error)
+	List(ctx context.Context, limit int32, cursor string) (models.UserPage, error)
+	ListCreatedBetween(ctx context.Context, from time.Time, to time.Time, limit int32, cursor string) (models.UserPage, error)
+	Put(ctx context.Context, record models.User) (models.User, error)
+	Create(ctx context.Context, record models.User) (models.User, error)
+	Update(ctx context.Context, id string, changes models.UserUpdate, expectedVersion int64) (models.User, error)
+	Delete(ctx context.Context, id string, expectedVersion int64) (string, error)
+	Restore(ctx context.Context, id string, expectedVersion int64) (models.User, error)
+	BatchGetByIDs(ctx context.Context, ids []string, opts ...ReadOption) ([]BatchGetResult, error)
+	BatchPut(ctx context.Context, records []models.User) ([]BatchPutResult, error)
+	History(ctx context.Context, id string, limit int32, cursor string) (models.HistoryPage, error)
+}
+
+var _ Store = UserStore{}
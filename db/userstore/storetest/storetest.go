@@ -0,0 +1,668 @@
+/*
+Package storetest is a conformance suite for userstore.Store implementations, checking that they all return the
+same results and errors for the same operations.
+*/
+package storetest
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benjaminkitson/bk-user-api/audit"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// NewStore returns an empty store configured with the given options. Stores must not share any state.
+type NewStore func(t *testing.T, opts ...userstore.Option) userstore.Store
+
+// Run runs the conformance suite against the stores returned by newStore
+func Run(t *testing.T, newStore NewStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, newStore NewStore)
+	}{
+		{"Create", testCreate},
+		{"CreateEmailTaken", testCreateEmailTaken},
+		{"CreateExistingID", testCreateExistingID},
+		{"NotFound", testNotFound},
+		{"Put", testPut},
+		{"Update", testUpdate},
+		{"UpdateEmailTaken", testUpdateEmailTaken},
+		{"UpdateConflict", testUpdateConflict},
+		{"Delete", testDelete},
+		{"DeleteReleasesEmail", testDeleteReleasesEmail},
+		{"Restore", testRestore},
+		{"RestoreAfterWindow", testRestoreAfterWindow},
+		{"List", testList},
+		{"ListCreatedBetween", testListCreatedBetween},
+		{"TamperedCursor", testTamperedCursor},
+		{"BatchGetByIDs", testBatchGetByIDs},
+		{"BatchPut", testBatchPut},
+		{"History", testHistory},
+		{"TenantIsolation", testTenantIsolation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore)
+		})
+	}
+}
+
+// clock is a manually advanced clock for testing restore windows
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func create(t *testing.T, store userstore.Store, email string) models.User {
+	t.Helper()
+	u, err := store.Create(context.Background(), models.User{UserID: uuid.New().String(), Email: email})
+	require.NoError(t, err)
+	return u
+}
+
+func testCreate(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	store := newStore(t, userstore.WithClock(func() time.Time { return now }))
+
+	id := uuid.New().String()
+	u, err := store.Create(ctx, models.User{UserID: id, Email: " Created@Example.com "})
+	require.NoError(t, err)
+	assert.Equal(t, models.User{UserID: id, Email: "Created@Example.com", Version: 1, CreatedAt: now, UpdatedAt: now}, u)
+
+	r, err := store.GetByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, u, r)
+
+	r, err = store.GetByEmail(ctx, "created@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, u, r)
+}
+
+func testCreateEmailTaken(t *testing.T, newStore NewStore) {
+	store := newStore(t)
+	create(t, store, "taken@example.com")
+
+	_, err := store.Create(context.Background(), models.User{UserID: uuid.New().String(), Email: "TAKEN@example.com"})
+	require.ErrorIs(t, err, userstore.ErrEmailTaken)
+}
+
+func testCreateExistingID(t *testing.T, newStore NewStore) {
+	store := newStore(t)
+	u := create(t, store, "first@example.com")
+
+	_, err := store.Create(context.Background(), models.User{UserID: u.UserID, Email: "second@example.com"})
+	require.ErrorIs(t, err, userstore.ErrConflict)
+
+	// The failed create mustn't have reserved the email
+	create(t, store, "second@example.com")
+}
+
+func testNotFound(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+
+	_, err := store.GetByID(ctx, "nonexistent")
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	_, err = store.GetByEmail(ctx, "nonexistent@example.com")
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	_, err = store.Update(ctx, "nonexistent", models.UserUpdate{}, 0)
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	_, err = store.Delete(ctx, "nonexistent", 0)
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	_, err = store.Restore(ctx, "nonexistent", 0)
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+}
+
+func testPut(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+	id := uuid.New().String()
+
+	u, err := store.Put(ctx, models.User{UserID: id, Email: "put@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), u.Version)
+
+	// A zero version only writes new users
+	_, err = store.Put(ctx, models.User{UserID: id, Email: "put@example.com"})
+	require.ErrorIs(t, err, userstore.ErrConflict)
+
+	u, err = store.Put(ctx, u)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), u.Version)
+
+	stale := u
+	stale.Version = 1
+	_, err = store.Put(ctx, stale)
+	require.ErrorIs(t, err, userstore.ErrConflict)
+
+	_, err = store.Put(ctx, models.User{UserID: uuid.New().String(), Email: "missing@example.com", Version: 1})
+	require.ErrorIs(t, err, userstore.ErrConflict)
+
+	r, err := store.GetByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, u, r)
+}
+
+func testUpdate(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+	u := create(t, store, "before@example.com")
+
+	email := "after@example.com"
+	updated, err := store.Update(ctx, u.UserID, models.UserUpdate{Email: &email}, u.Version)
+	require.NoError(t, err)
+	assert.Equal(t, email, updated.Email)
+	assert.Equal(t, u.Version+1, updated.Version)
+
+	r, err := store.GetByEmail(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, updated, r)
+
+	_, err = store.GetByEmail(ctx, "before@example.com")
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	// The old email is released
+	create(t, store, "before@example.com")
+
+	// Changing only the case keeps the reservation
+	email = "AFTER@example.com"
+	updated, err = store.Update(ctx, u.UserID, models.UserUpdate{Email: &email}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, email, updated.Email)
+	_, err = store.Create(ctx, models.User{UserID: uuid.New().String(), Email: "after@example.com"})
+	require.ErrorIs(t, err, userstore.ErrEmailTaken)
+
+	// An empty update changes nothing
+	r, err = store.Update(ctx, u.UserID, models.UserUpdate{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, updated, r)
+}
+
+func testUpdateEmailTaken(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+	create(t, store, "taken@example.com")
+	u := create(t, store, "mine@example.com")
+
+	email := "Taken@example.com"
+	_, err := store.Update(ctx, u.UserID, models.UserUpdate{Email: &email}, 0)
+	require.ErrorIs(t, err, userstore.ErrEmailTaken)
+
+	r, err := store.GetByID(ctx, u.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, u, r)
+}
+
+func testUpdateConflict(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+	u := create(t, store, "conflict@example.com")
+
+	email := "changed@example.com"
+	_, err := store.Update(ctx, u.UserID, models.UserUpdate{Email: &email}, u.Version+1)
+	require.ErrorIs(t, err, userstore.ErrConflict)
+}
+
+func testDelete(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+	u := create(t, store, "deleted@example.com")
+
+	_, err := store.Delete(ctx, u.UserID, u.Version+1)
+	require.ErrorIs(t, err, userstore.ErrConflict)
+
+	id, err := store.Delete(ctx, u.UserID, u.Version)
+	require.NoError(t, err)
+	assert.Equal(t, u.UserID, id)
+
+	_, err = store.GetByID(ctx, u.UserID)
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+	_, err = store.GetByEmail(ctx, u.Email)
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	r, err := store.GetByID(ctx, u.UserID, userstore.IncludeDeleted())
+	require.NoError(t, err)
+	require.NotNil(t, r.DeletedAt)
+	assert.Equal(t, u.Version+1, r.Version)
+
+	r, err = store.GetByEmail(ctx, u.Email, userstore.IncludeDeleted())
+	require.NoError(t, err)
+	assert.Equal(t, u.UserID, r.UserID)
+
+	_, err = store.Delete(ctx, u.UserID, 0)
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	_, err = store.Update(ctx, u.UserID, models.UserUpdate{}, 0)
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	// The email is held by default
+	_, err = store.Create(ctx, models.User{UserID: uuid.New().String(), Email: u.Email})
+	require.ErrorIs(t, err, userstore.ErrEmailTaken)
+}
+
+func testDeleteReleasesEmail(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t, userstore.WithDeletedEmailPolicy(userstore.ReleaseEmail))
+	u := create(t, store, "released@example.com")
+
+	_, err := store.Delete(ctx, u.UserID, 0)
+	require.NoError(t, err)
+
+	_, err = store.GetByEmail(ctx, u.Email, userstore.IncludeDeleted())
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	create(t, store, u.Email)
+
+	_, err = store.Restore(ctx, u.UserID, 0)
+	require.ErrorIs(t, err, userstore.ErrEmailTaken)
+}
+
+func testRestore(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+	u := create(t, store, "restored@example.com")
+
+	_, err := store.Restore(ctx, u.UserID, 0)
+	require.ErrorIs(t, err, userstore.ErrNotDeleted)
+	// Whether or not the version matches
+	_, err = store.Restore(ctx, u.UserID, u.Version)
+	require.ErrorIs(t, err, userstore.ErrNotDeleted)
+
+	_, err = store.Delete(ctx, u.UserID, 0)
+	require.NoError(t, err)
+
+	_, err = store.Restore(ctx, u.UserID, u.Version)
+	require.ErrorIs(t, err, userstore.ErrConflict)
+
+	r, err := store.Restore(ctx, u.UserID, u.Version+1)
+	require.NoError(t, err)
+	assert.Nil(t, r.DeletedAt)
+	assert.Equal(t, u.Version+2, r.Version)
+
+	got, err := store.GetByEmail(ctx, u.Email)
+	require.NoError(t, err)
+	assert.Equal(t, r, got)
+
+	// The email is reserved again rather than held until the old expiry
+	_, err = store.Create(ctx, models.User{UserID: uuid.New().String(), Email: u.Email})
+	require.ErrorIs(t, err, userstore.ErrEmailTaken)
+}
+
+func testRestoreAfterWindow(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	c := &clock{now: time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)}
+	store := newStore(t, userstore.WithClock(c.Now), userstore.WithRestoreWindow(time.Hour))
+	u := create(t, store, "expired@example.com")
+
+	_, err := store.Delete(ctx, u.UserID, 0)
+	require.NoError(t, err)
+
+	c.Advance(time.Hour)
+	_, err = store.Restore(ctx, u.UserID, 0)
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+}
+
+func testList(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+
+	expected := map[string]bool{}
+	for i := 0; i < 5; i++ {
+		u := create(t, store, uuid.New().String()+"@example.com")
+		expected[u.UserID] = true
+	}
+	deleted := create(t, store, "deleted@example.com")
+	_, err := store.Delete(ctx, deleted.UserID, 0)
+	require.NoError(t, err)
+
+	found := map[string]bool{}
+	cursor := ""
+	for {
+		page, err := store.List(ctx, 2, cursor)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Users), 2)
+		for _, u := range page.Users {
+			assert.False(t, found[u.UserID], "user %s returned more than once", u.UserID)
+			found[u.UserID] = true
+		}
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+
+	// Stores may be seeded with other users, so only check for the ones created here
+	for id := range expected {
+		assert.True(t, found[id], "user %s not listed", id)
+	}
+	assert.False(t, found[deleted.UserID], "deleted user listed")
+
+	_, err = store.List(ctx, 2, "not a cursor")
+	require.ErrorIs(t, err, userstore.ErrInvalidCursor)
+}
+
+func testListCreatedBetween(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	c := &clock{now: time.Date(2024, 10, 30, 12, 0, 0, 0, time.UTC)}
+	store := newStore(t, userstore.WithClock(c.Now))
+
+	create(t, store, "before@example.com")
+	c.Advance(24 * time.Hour)
+	from := c.Now()
+	// The range spans the end of a month, and two of its users are created at the same time
+	expected := []string{}
+	for _, advance := range []time.Duration{0, 24 * time.Hour, 0, 24 * time.Hour} {
+		c.Advance(advance)
+		expected = append(expected, create(t, store, uuid.New().String()+"@example.com").UserID)
+	}
+	// Users created at the same time are ordered by id
+	sort.Strings(expected[1:3])
+	deleted := create(t, store, "deleted@example.com")
+	_, err := store.Delete(ctx, deleted.UserID, 0)
+	require.NoError(t, err)
+	c.Advance(24 * time.Hour)
+	to := c.Now()
+	create(t, store, "after@example.com")
+
+	listed := []string{}
+	cursor := ""
+	for {
+		page, err := store.ListCreatedBetween(ctx, from, to, 2, cursor)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Users), 2)
+		for _, u := range page.Users {
+			listed = append(listed, u.UserID)
+		}
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+	assert.Equal(t, expected, listed)
+
+	page, err := store.ListCreatedBetween(tenant.WithTenant(ctx, "acme"), from, to, 0, "")
+	require.NoError(t, err)
+	assert.Empty(t, page.Users)
+
+	_, err = store.ListCreatedBetween(ctx, from, to, 2, "not a cursor")
+	require.ErrorIs(t, err, userstore.ErrInvalidCursor)
+}
+
+func testTamperedCursor(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+	u := create(t, store, "a@example.com")
+	create(t, store, "b@example.com")
+	email := "c@example.com"
+	_, err := store.Update(ctx, u.UserID, models.UserUpdate{Email: &email}, 0)
+	require.NoError(t, err)
+
+	page, err := store.List(ctx, 1, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, page.Cursor)
+	history, err := store.History(ctx, u.UserID, 1, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, history.Cursor)
+
+	// Cursors are only accepted as they were issued, and only by stores with the same cursor key
+	other := newStore(t, userstore.WithCursorKey([]byte("another-cursor-key")))
+	for name, cursor := range map[string]string{
+		"tampered": tamper(page.Cursor),
+		"unsigned": base64.RawURLEncoding.EncodeToString([]byte("user/" + u.UserID)),
+	} {
+		_, err = store.List(ctx, 1, cursor)
+		assert.ErrorIs(t, err, userstore.ErrInvalidCursor, name)
+	}
+	_, err = other.List(ctx, 1, page.Cursor)
+	assert.ErrorIs(t, err, userstore.ErrInvalidCursor)
+
+	_, err = store.History(ctx, u.UserID, 1, tamper(history.Cursor))
+	assert.ErrorIs(t, err, userstore.ErrInvalidCursor)
+	_, err = other.History(ctx, u.UserID, 1, history.Cursor)
+	assert.ErrorIs(t, err, userstore.ErrInvalidCursor)
+}
+
+// tamper changes the first character of a cursor, which changes what it decodes to
+func tamper(cursor string) string {
+	if cursor[0] == 'A' {
+		return "B" + cursor[1:]
+	}
+	return "A" + cursor[1:]
+}
+
+func testBatchGetByIDs(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+	a := create(t, store, "a@example.com")
+	b := create(t, store, "b@example.com")
+	deleted := create(t, store, "deleted@example.com")
+	_, err := store.Delete(ctx, deleted.UserID, 0)
+	require.NoError(t, err)
+
+	results, err := store.BatchGetByIDs(ctx, []string{b.UserID, "nonexistent", a.UserID, deleted.UserID, b.UserID})
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+	assert.Equal(t, userstore.BatchGetResult{User: b}, results[0])
+	assert.ErrorIs(t, results[1].Err, userstore.ErrNotFound)
+	assert.Equal(t, userstore.BatchGetResult{User: a}, results[2])
+	assert.ErrorIs(t, results[3].Err, userstore.ErrNotFound)
+	assert.Equal(t, userstore.BatchGetResult{User: b}, results[4])
+
+	results, err = store.BatchGetByIDs(ctx, []string{deleted.UserID}, userstore.IncludeDeleted())
+	require.NoError(t, err)
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, deleted.UserID, results[0].User.UserID)
+}
+
+func testBatchPut(t *testing.T, newStore NewStore) {
+	ctx := context.Background()
+	store := newStore(t)
+	taken := create(t, store, "taken@example.com")
+
+	id := uuid.New().String()
+	results, err := store.BatchPut(ctx, []models.User{
+		{UserID: id, Email: " batch@example.com"},
+		{UserID: id, Email: "other@example.com"},
+		{UserID: uuid.New().String(), Email: "Batch@example.com"},
+		{UserID: uuid.New().String(), Email: "TAKEN@example.com"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, "batch@example.com", results[0].User.Email)
+	assert.Equal(t, int64(1), results[0].User.Version)
+	assert.ErrorIs(t, results[1].Err, userstore.ErrConflict)
+	assert.ErrorIs(t, results[2].Err, userstore.ErrConflict)
+	assert.ErrorIs(t, results[3].Err, userstore.ErrEmailTaken)
+
+	r, err := store.GetByEmail(ctx, "batch@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, results[0].User, r)
+
+	_, err = store.Create(ctx, models.User{UserID: uuid.New().String(), Email: "batch@example.com"})
+	require.ErrorIs(t, err, userstore.ErrEmailTaken)
+
+	// Existing users, including deleted ones, are never overwritten
+	deleted := create(t, store, "deleted@example.com")
+	_, err = store.Delete(ctx, deleted.UserID, 0)
+	require.NoError(t, err)
+	results, err = store.BatchPut(ctx, []models.User{
+		{UserID: taken.UserID, Email: "replaced@example.com"},
+		{UserID: deleted.UserID, Email: "deleted@example.com"},
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, userstore.ErrConflict)
+	assert.ErrorIs(t, results[1].Err, userstore.ErrConflict)
+
+	r, err = store.GetByID(ctx, taken.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, taken, r)
+	_, err = store.GetByEmail(ctx, "replaced@example.com")
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+
+	history, err := store.History(ctx, taken.UserID, 10, "")
+	require.NoError(t, err)
+	require.Len(t, history.Entries, 1)
+	assert.Equal(t, models.HistoryCreate, history.Entries[0].Action)
+}
+
+func testHistory(t *testing.T, newStore NewStore) {
+	c := &clock{now: time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)}
+	store := newStore(t, userstore.WithClock(c.Now))
+	ctx := audit.WithRequestID(audit.WithActor(context.Background(), "admin"), "request-1")
+
+	u, err := store.Create(ctx, models.User{UserID: uuid.New().String(), Email: "history@example.com"})
+	require.NoError(t, err)
+	c.Advance(time.Second)
+	email := "changed@example.com"
+	updated, err := store.Update(ctx, u.UserID, models.UserUpdate{Email: &email}, 0)
+	require.NoError(t, err)
+	// Changes that change nothing aren't recorded
+	_, err = store.Update(ctx, u.UserID, models.UserUpdate{Email: &email}, 0)
+	require.NoError(t, err)
+	c.Advance(time.Second)
+	_, err = store.Delete(context.Background(), u.UserID, 0)
+	require.NoError(t, err)
+	c.Advance(time.Second)
+	restored, err := store.Restore(context.Background(), u.UserID, 0)
+	require.NoError(t, err)
+
+	entries := []models.HistoryEntry{}
+	cursor := ""
+	for {
+		page, err := store.History(context.Background(), u.UserID, 3, cursor)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Entries), 3)
+		entries = append(entries, page.Entries...)
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+
+	require.Len(t, entries, 4)
+	actions := []models.HistoryAction{}
+	for _, e := range entries {
+		assert.Equal(t, u.UserID, e.UserID)
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []models.HistoryAction{models.HistoryRestore, models.HistoryDelete, models.HistoryUpdate, models.HistoryCreate}, actions)
+
+	created := entries[3]
+	assert.Equal(t, int64(1), created.Version)
+	assert.Equal(t, u.CreatedAt, created.Timestamp)
+	assert.Equal(t, "admin", created.Actor)
+	assert.Equal(t, "request-1", created.RequestID)
+	assert.Contains(t, created.Changes, models.UserChange{Field: "email", To: "history@example.com"})
+
+	assert.Equal(t, updated.Version, entries[2].Version)
+	assert.Equal(t, updated.UpdatedAt, entries[2].Timestamp)
+	assert.Equal(t, []models.UserChange{{Field: "email", From: "history@example.com", To: email}}, entries[2].Changes)
+
+	assert.Empty(t, entries[1].Actor)
+	require.Len(t, entries[1].Changes, 1)
+	assert.Equal(t, "deletedAt", entries[1].Changes[0].Field)
+	assert.Equal(t, restored.Version, entries[0].Version)
+
+	page, err := store.History(context.Background(), uuid.New().String(), 0, "")
+	require.NoError(t, err)
+	assert.Empty(t, page.Entries)
+
+	// Cursors are only valid for the user they were issued for
+	page, err = store.History(context.Background(), u.UserID, 1, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, page.Cursor)
+	_, err = store.History(context.Background(), uuid.New().String(), 1, page.Cursor)
+	require.ErrorIs(t, err, userstore.ErrInvalidCursor)
+	_, err = store.History(context.Background(), u.UserID, 1, "not a cursor")
+	require.ErrorIs(t, err, userstore.ErrInvalidCursor)
+}
+
+func testTenantIsolation(t *testing.T, newStore NewStore) {
+	store := newStore(t)
+	acme := tenant.WithTenant(context.Background(), "acme")
+	globex := tenant.WithTenant(context.Background(), "globex")
+	id := uuid.New().String()
+
+	// The tenant comes from the context, whatever the record says
+	a, err := store.Create(acme, models.User{UserID: id, TenantID: "globex", Email: "shared@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "acme", a.TenantID)
+
+	for _, ctx := range []context.Context{globex, context.Background()} {
+		_, err = store.GetByID(ctx, id, userstore.IncludeDeleted())
+		require.ErrorIs(t, err, userstore.ErrNotFound)
+		_, err = store.GetByEmail(ctx, "shared@example.com", userstore.IncludeDeleted())
+		require.ErrorIs(t, err, userstore.ErrNotFound)
+		results, err := store.BatchGetByIDs(ctx, []string{id})
+		require.NoError(t, err)
+		require.ErrorIs(t, results[0].Err, userstore.ErrNotFound)
+		page, err := store.List(ctx, 0, "")
+		require.NoError(t, err)
+		assert.Empty(t, page.Users)
+		history, err := store.History(ctx, id, 0, "")
+		require.NoError(t, err)
+		assert.Empty(t, history.Entries)
+		_, err = store.Update(ctx, id, models.UserUpdate{}, 0)
+		require.ErrorIs(t, err, userstore.ErrNotFound)
+		_, err = store.Delete(ctx, id, 0)
+		require.ErrorIs(t, err, userstore.ErrNotFound)
+	}
+
+	// Ids and emails only need to be unique within a tenant
+	b, err := store.Create(globex, models.User{UserID: id, Email: "Shared@Example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "globex", b.TenantID)
+
+	r, err := store.GetByID(acme, id)
+	require.NoError(t, err)
+	assert.Equal(t, a, r)
+	r, err = store.GetByEmail(globex, "shared@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, b, r)
+
+	// Deleting one tenant's user leaves the other's alone, and only holds the email in its own tenant
+	_, err = store.Delete(acme, id, 0)
+	require.NoError(t, err)
+	_, err = store.GetByID(acme, id)
+	require.ErrorIs(t, err, userstore.ErrNotFound)
+	_, err = store.Create(acme, models.User{UserID: uuid.New().String(), Email: "shared@example.com"})
+	require.ErrorIs(t, err, userstore.ErrEmailTaken)
+
+	r, err = store.GetByID(globex, id)
+	require.NoError(t, err)
+	assert.Equal(t, b, r)
+	r, err = store.GetByEmail(globex, "shared@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, b, r)
+	_, err = store.Create(context.Background(), models.User{UserID: uuid.New().String(), Email: "shared@example.com"})
+	require.NoError(t, err)
+}
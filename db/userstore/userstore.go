@@ -3,76 +3,142 @@ package userstore
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/emailaddr"
 	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/pii"
+	"github.com/benjaminkitson/bk-user-api/tenant"
 	"github.com/pkg/errors"
 )
 
 const (
-	PKKey   string = "_pk"
-	GSI1Key string = "_gsi1"
-	// SKKey string = "_sk"
+	PKKey      string = schema.PartitionKey
+	GSI1Key    string = schema.GSI1Key
+	GSI3Key    string = schema.GSI3Key
+	SKKey      string = schema.SortKey
+	TTLKey     string = schema.TTLAttribute
+	VersionKey string = "version"
+	DeletedKey string = "deletedAt"
+)
+
+const (
+	DefaultPageSize int32 = 50
+	MaxPageSize     int32 = 100
 )
 
 type UserStore struct {
-	tableName string
-	client    *dynamodb.Client
+	tableName          string
+	client             *dynamodb.Client
+	now                func() time.Time
+	cursorKey          []byte
+	restoreWindow      time.Duration
+	deletedEmailPolicy DeletedEmailPolicy
+	emailPolicy        emailaddr.Policy
+	outbox             bool
+	cipher             *pii.Cipher
+	blindIndex         pii.BlindIndex
+	retry              RetryPolicy
 }
 
-func NewUserStore(client *dynamodb.Client, tableName string) UserStore {
+func NewUserStore(client *dynamodb.Client, tableName string, opts ...Option) UserStore {
+	o := NewOptions(opts...)
+	// Requests are made through a copy of the client, so that the store's retry policy doesn't change the caller's.
+	// Stores that only decode items have no client.
+	if client != nil {
+		client = dynamodb.New(client.Options(), func(do *dynamodb.Options) {
+			do.Retryer = o.Retry.retryer()
+			do.RetryMaxAttempts = 0
+		})
+	}
 	return UserStore{
-		tableName: tableName,
-		client:    client,
+		tableName:          tableName,
+		client:             client,
+		now:                o.Now,
+		cursorKey:          o.CursorKey,
+		restoreWindow:      o.RestoreWindow,
+		deletedEmailPolicy: o.DeletedEmailPolicy,
+		emailPolicy:        o.EmailPolicy,
+		outbox:             o.Outbox,
+		cipher:             o.Cipher,
+		blindIndex:         o.BlindIndex,
+		retry:              o.Retry,
 	}
 }
 
-func (store UserStore) GetByID(ctx context.Context, id string) (models.User, error) {
-	key := map[string]types.AttributeValue{
-		PKKey: &types.AttributeValueMemberS{Value: store.getUserPK(id)},
-		// SKKey: &types.AttributeValueMemberS{Value: store.getUserSK(id)},
+// GetByID returns the user with the given id. Soft-deleted users are treated as not found unless the
+// IncludeDeleted option is given.
+func (store UserStore) GetByID(ctx context.Context, id string, opts ...ReadOption) (models.User, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	_, user, err := store.getUser(ctx, id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if user.DeletedAt != nil && !NewReadOptions(opts...).IncludeDeleted {
+		return models.User{}, ErrNotFound
 	}
+
+	return user, nil
+}
+
+// getUser returns the stored user item along with the user it represents, including soft-deleted users
+func (store UserStore) getUser(ctx context.Context, id string) (map[string]types.AttributeValue, models.User, error) {
 	query := dynamodb.GetItemInput{
 		TableName:      &store.tableName,
-		Key:            key,
+		Key:            store.getUserKey(ctx, id),
 		ConsistentRead: aws.Bool(true),
 	}
 
 	item, err := store.client.GetItem(ctx, &query)
 	if err != nil {
-		return models.User{}, err
+		return nil, models.User{}, classifyError(err)
 	}
 
 	if len(item.Item) == 0 {
-		return models.User{}, err
+		return nil, models.User{}, ErrNotFound
 	}
 
-	var user models.User
-	err = attributevalue.UnmarshalMap(item.Item, &user)
+	user, err := store.UnmarshalUser(ctx, item.Item)
 	if err != nil {
-		return models.User{}, err
+		return nil, models.User{}, err
 	}
 
-	return user, nil
+	return item.Item, user, nil
 }
 
-func (store UserStore) GetByEmail(ctx context.Context, email string) (models.User, error) {
+/*
+GetByEmail returns the user with the given email. Soft-deleted users are treated as not found unless the
+IncludeDeleted option is given, though users deleted under the ReleaseEmail policy are no longer indexed by
+email and so can't be found this way.
+*/
+func (store UserStore) GetByEmail(ctx context.Context, email string, opts ...ReadOption) (models.User, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
 	out, err := store.client.Query(ctx, &dynamodb.QueryInput{
 		TableName:              &store.tableName,
-		IndexName:              aws.String("gsi1"),
+		IndexName:              aws.String(schema.GSI1),
 		KeyConditionExpression: aws.String("#gsi1 = :gsi1"),
 		ExpressionAttributeNames: map[string]string{
-			"#gsi1": "_gsi1",
+			"#gsi1": GSI1Key,
 		},
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":gsi1": &types.AttributeValueMemberS{Value: store.getUserGSI1(email)},
+			":gsi1": &types.AttributeValueMemberS{Value: store.getUserGSI1(ctx, email)},
 		},
 	})
 	if err != nil {
-		return models.User{}, err
+		return models.User{}, classifyError(err)
 	}
 
 	if len(out.Items) > 1 {
@@ -80,64 +146,772 @@ func (store UserStore) GetByEmail(ctx context.Context, email string) (models.Use
 	}
 
 	if len(out.Items) == 0 {
-		return models.User{}, nil
+		return models.User{}, ErrNotFound
 	}
 
-	var user models.User
-	err = attributevalue.UnmarshalMap(out.Items[0], &user)
+	user, err := store.UnmarshalUser(ctx, out.Items[0])
 	if err != nil {
 		return models.User{}, err
 	}
 
+	if user.DeletedAt != nil && !NewReadOptions(opts...).IncludeDeleted {
+		return models.User{}, ErrNotFound
+	}
+
 	return user, nil
 }
 
+/*
+List returns a page of at most limit users, excluding soft-deleted users, starting after the given cursor. An empty cursor starts from the
+beginning, and the returned page's cursor is empty once there are no more users. ErrInvalidCursor is returned
+if the cursor was not issued by a store with the same cursor key.
+*/
+func (store UserStore) List(ctx context.Context, limit int32, cursor string) (models.UserPage, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	if len(store.cursorKey) == 0 {
+		return models.UserPage{}, errors.New("a cursor key is required to list users")
+	}
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	startKey, err := decodeCursor(store.cursorKey, cursor)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+
+	page := models.UserPage{Users: []models.User{}}
+	for {
+		// Scan limits are applied before filtering, so keep scanning until the page is full. Limiting each scan to
+		// the remaining size of the page means the last evaluated key always lines up with the last user returned.
+		out, err := store.client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:         aws.String(store.tableName),
+			Limit:             aws.Int32(limit - int32(len(page.Users))),
+			ExclusiveStartKey: startKey,
+			FilterExpression:  aws.String("#sk = :sk AND begins_with(#pk, :prefix) AND attribute_not_exists(#deletedAt)"),
+			ExpressionAttributeNames: map[string]string{
+				"#pk":        PKKey,
+				"#sk":        SKKey,
+				"#deletedAt": DeletedKey,
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":sk":     &types.AttributeValueMemberS{Value: schema.UserSK},
+				":prefix": &types.AttributeValueMemberS{Value: store.getUserPK(ctx, "")},
+			},
+		})
+		if err != nil {
+			return models.UserPage{}, classifyError(err)
+		}
+
+		for _, item := range out.Items {
+			user, err := store.UnmarshalUser(ctx, item)
+			if err != nil {
+				return models.UserPage{}, err
+			}
+			page.Users = append(page.Users, user)
+		}
+
+		startKey = out.LastEvaluatedKey
+		if len(startKey) == 0 || int32(len(page.Users)) >= limit {
+			break
+		}
+	}
+
+	page.Cursor, err = encodeCursor(store.cursorKey, startKey)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+
+	return page, nil
+}
+
+/*
+ListCreatedBetween returns a page of at most limit users created at or after from and before to, excluding
+soft-deleted users, in order of creation. Users are indexed in a bucket per month, and each page queries the
+buckets in turn until it is full, so a range spanning many empty months costs a query for each of them.
+ErrInvalidCursor is returned if the cursor was not issued for the same range by a store with the same cursor
+key.
+*/
+func (store UserStore) ListCreatedBetween(ctx context.Context, from time.Time, to time.Time, limit int32, cursor string) (models.UserPage, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	if len(store.cursorKey) == 0 {
+		return models.UserPage{}, errors.New("a cursor key is required to list users")
+	}
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	page := models.UserPage{Users: []models.User{}}
+	if !from.Before(to) {
+		return page, nil
+	}
+	lower := schema.CreatedOrderKey(from, "")
+	upper := schema.CreatedOrderKey(to, "")
+	buckets := schema.CreatedBuckets(tenant.FromContext(ctx), from, to)
+
+	startKey, err := decodeCursor(store.cursorKey, cursor)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+	if startKey != nil {
+		bucket, _ := startKey[GSI3Key].(*types.AttributeValueMemberS)
+		order, _ := startKey[schema.GSI3SortKey].(*types.AttributeValueMemberS)
+		if bucket == nil || order == nil || order.Value < lower || order.Value >= upper {
+			return models.UserPage{}, ErrInvalidCursor
+		}
+		i := slices.Index(buckets, bucket.Value)
+		if i < 0 {
+			return models.UserPage{}, ErrInvalidCursor
+		}
+		buckets = buckets[i:]
+	}
+
+	var last map[string]types.AttributeValue
+	for i, bucket := range buckets {
+		for {
+			out, err := store.client.Query(ctx, &dynamodb.QueryInput{
+				TableName:              aws.String(store.tableName),
+				IndexName:              aws.String(schema.GSI3),
+				KeyConditionExpression: aws.String("#gsi3 = :bucket AND #gsi3sk BETWEEN :lower AND :upper"),
+				ExpressionAttributeNames: map[string]string{
+					"#gsi3":   GSI3Key,
+					"#gsi3sk": schema.GSI3SortKey,
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":bucket": &types.AttributeValueMemberS{Value: bucket},
+					":lower":  &types.AttributeValueMemberS{Value: lower},
+					":upper":  &types.AttributeValueMemberS{Value: upper},
+				},
+				Limit:             aws.Int32(limit - int32(len(page.Users))),
+				ExclusiveStartKey: startKey,
+			})
+			if err != nil {
+				return models.UserPage{}, classifyError(err)
+			}
+
+			for _, item := range out.Items {
+				user, err := store.UnmarshalUser(ctx, item)
+				if err != nil {
+					return models.UserPage{}, err
+				}
+				page.Users = append(page.Users, user)
+				last = item
+			}
+
+			startKey = out.LastEvaluatedKey
+			if len(startKey) == 0 {
+				startKey = nil
+			}
+			if int32(len(page.Users)) >= limit {
+				if startKey == nil && i == len(buckets)-1 {
+					return page, nil
+				}
+				// The next page carries on after the last user returned, which may be in this bucket or a later one
+				page.Cursor, err = encodeCursor(store.cursorKey, createdIndexKey(last))
+				if err != nil {
+					return models.UserPage{}, err
+				}
+				return page, nil
+			}
+			if startKey == nil {
+				break
+			}
+		}
+	}
+
+	return page, nil
+}
+
+// createdIndexKey returns the key of the item in GSI3, as used for the ExclusiveStartKey of a query on the index
+func createdIndexKey(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		PKKey:              item[PKKey],
+		SKKey:              item[SKKey],
+		GSI3Key:            item[GSI3Key],
+		schema.GSI3SortKey: item[schema.GSI3SortKey],
+	}
+}
+
+/*
+Put writes the user record, provided the stored record is still at record.Version. A record with a zero
+version is treated as new, and will only be written if no user with the same id exists or the stored user was
+written before versioning was introduced, and so has no version. ErrConflict is returned if the condition fails.
+The version and timestamps of the returned record are set by the store.
+
+Put does not maintain the email reservation, so changing a user's email should not be done with Put.
+*/
 func (store UserStore) Put(ctx context.Context, record models.User) (models.User, error) {
-	item, err := attributevalue.MarshalMap(record)
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	record.TenantID = tenant.FromContext(ctx)
+	expectedVersion := record.Version
+	now := store.now().UTC()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = now
+	}
+	record.UpdatedAt = now
+	record.Version = expectedVersion + 1
+
+	item, err := store.marshalUser(ctx, record)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// The previous record is only needed for the history and outbox, and the version condition guarantees it is still
+	// current when the write is made. It is read even for new records, as they may replace an unversioned user.
+	_, before, err := store.getUser(ctx, record.UserID)
+	if errors.Is(err, ErrNotFound) {
+		if expectedVersion != 0 {
+			return models.User{}, ErrConflict
+		}
+		err = nil
+	}
 	if err != nil {
-		return models.User{}, errors.Wrap(err, "an error ocurred marshaling the record")
+		return models.User{}, err
+	}
+	if before.Version != expectedVersion {
+		return models.User{}, ErrConflict
 	}
 
-	item[PKKey] = &types.AttributeValueMemberS{Value: store.getUserPK(record.UserID)}
-	item[GSI1Key] = &types.AttributeValueMemberS{Value: store.getUserPK(record.Email)}
-	// item[SKKey] = &types.AttributeValueMemberS{Value: store.getUserSK(id)}
+	changes, err := store.recordChange(ctx, models.HistoryPut, before, record)
+	if err != nil {
+		return models.User{}, err
+	}
 
-	_, err = store.client.PutItem(ctx, &dynamodb.PutItemInput{
-		Item:      item,
-		TableName: &store.tableName,
+	condition, names, values := store.versionCondition(expectedVersion)
+	_, err = store.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					Item:                      item,
+					TableName:                 &store.tableName,
+					ConditionExpression:       condition,
+					ExpressionAttributeNames:  names,
+					ExpressionAttributeValues: values,
+				},
+			},
+		}, changes...),
 	})
+	if err != nil {
+		return models.User{}, classifyError(err)
+	}
 
+	return record, nil
+}
+
+// Create writes a new user record alongside an email reservation item in a single transaction, so that
+// concurrent creates with the same email cannot both succeed. ErrEmailTaken is returned if the email is
+// already reserved by another user.
+func (store UserStore) Create(ctx context.Context, record models.User) (models.User, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	now := store.now().UTC()
+	record.CreatedAt = now
+	record.UpdatedAt = now
+	return store.create(ctx, record)
+}
+
+// create writes a new user and its email reservation as Create does, keeping the timestamps already set on it
+func (store UserStore) create(ctx context.Context, record models.User) (models.User, error) {
+	record.TenantID = tenant.FromContext(ctx)
+	record.Email = strings.TrimSpace(record.Email)
+	record.Version = 1
+
+	item, err := store.marshalUser(ctx, record)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	changes, err := store.recordChange(ctx, models.HistoryCreate, models.User{}, record)
 	if err != nil {
 		return models.User{}, err
 	}
 
-	return record, err
+	_, err = store.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:                aws.String(store.tableName),
+					Item:                     item,
+					ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+					ExpressionAttributeNames: map[string]string{"#pk": PKKey},
+				},
+			},
+			store.reserveEmail(ctx, record.Email, record.UserID, nil),
+		}, changes...),
+	})
+	if err != nil {
+		// Cancellation reasons are returned in the same order as the transact items
+		if cancellationReason(err, 1) == "ConditionalCheckFailed" {
+			return models.User{}, wrapError(ErrEmailTaken, err)
+		}
+		return models.User{}, classifyError(err)
+	}
+
+	return record, nil
 }
 
-func (store UserStore) Delete(ctx context.Context, id string) (string, error) {
-	// TODO: ascertain if these attributes are needed
-	_, err := store.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
-		TableName: aws.String(store.tableName),
-		Key: map[string]types.AttributeValue{
-			PKKey: &types.AttributeValueMemberS{Value: store.getUserPK(id)},
-			// SKKey: &types.AttributeValueMemberS{Value: store.getUserSK(id)},
+/*
+Update applies the given changes to the user and returns the updated record. If expectedVersion is non-zero,
+ErrConflict is returned unless the stored record is at that version. When the email changes, the user's
+email reservation is moved in the same transaction as the update, and ErrEmailTaken is returned if the new
+email is already reserved. ErrNotFound is returned if there is no user with the given id.
+*/
+func (store UserStore) Update(ctx context.Context, id string, changes models.UserUpdate, expectedVersion int64) (models.User, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	item, current, err := store.getUser(ctx, id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if current.DeletedAt != nil {
+		return models.User{}, ErrNotFound
+	}
+
+	if expectedVersion != 0 && current.Version != expectedVersion {
+		return models.User{}, ErrConflict
+	}
+
+	updated := applyUpdate(current, changes)
+	if updated == current {
+		// Nothing to change
+		return current, nil
+	}
+	updated.Version = current.Version + 1
+	updated.UpdatedAt = store.now().UTC()
+
+	update, err := store.buildUpdate(ctx, item, current.Version, updated)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	recorded, err := store.recordChange(ctx, models.HistoryUpdate, current, updated)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	writes := []types.TransactWriteItem{
+		{
+			Update: update,
 		},
+	}
+	// The reservation only needs to move if the email has changed to a different mailbox
+	moved := store.getEmailPK(ctx, updated.Email) != store.getEmailPK(ctx, current.Email)
+	if moved {
+		writes = append(writes, store.releaseEmail(ctx, current.Email, id), store.reserveEmail(ctx, updated.Email, id, nil))
+	}
+	writes = append(writes, recorded...)
+
+	_, err = store.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: writes,
 	})
+	if err != nil {
+		if moved && cancellationReason(err, 2) == "ConditionalCheckFailed" {
+			return models.User{}, wrapError(ErrEmailTaken, err)
+		}
+		return models.User{}, classifyError(err)
+	}
+
+	return updated, nil
+}
+
+/*
+Delete soft-deletes the user, marking it with a deletion time and a TTL after which DynamoDB will purge it.
+Until then the user can be brought back with Restore. Depending on the store's DeletedEmailPolicy, the email
+reservation is either held until the purge or released immediately. If expectedVersion is non-zero, ErrConflict
+is returned unless the stored record is at that version. ErrNotFound is returned if there is no user with the
+given id, or the user has already been deleted.
+*/
+func (store UserStore) Delete(ctx context.Context, id string, expectedVersion int64) (string, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	item, current, err := store.getUser(ctx, id)
 	if err != nil {
 		return "", err
 	}
 
+	if current.DeletedAt != nil {
+		return "", ErrNotFound
+	}
+
+	if expectedVersion != 0 && current.Version != expectedVersion {
+		return "", ErrConflict
+	}
+
+	now := store.now().UTC()
+	deleted := current
+	deleted.DeletedAt = &now
+	deleted.Version = current.Version + 1
+	deleted.UpdatedAt = now
+
+	update, err := store.buildUpdate(ctx, item, current.Version, deleted)
+	if err != nil {
+		return "", err
+	}
+
+	reservation := store.releaseEmail(ctx, current.Email, id)
+	if store.deletedEmailPolicy == HoldEmail {
+		expiry := store.expiry(now)
+		reservation = store.reserveEmail(ctx, current.Email, id, &expiry)
+	}
+
+	changes, err := store.recordChange(ctx, models.HistoryDelete, current, deleted)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = store.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{
+				Update: update,
+			},
+			reservation,
+		}, changes...),
+	})
+	if err != nil {
+		return "", classifyError(err)
+	}
+
 	return id, nil
 }
 
-func (store UserStore) getUserPK(userID string) (_pk string) {
-	return fmt.Sprintf("user/%s", userID)
+/*
+Restore brings back a soft-deleted user that has not yet been purged, and returns the restored record. If
+expectedVersion is non-zero, ErrConflict is returned unless the stored record is at that version. ErrConflict
+is also returned if the user has not been deleted. ErrNotFound is returned if there is no user with the given
+id or the restore window has passed, and ErrEmailTaken if the user's email was released and has since been
+reserved by another user.
+*/
+func (store UserStore) Restore(ctx context.Context, id string, expectedVersion int64) (models.User, error) {
+	ctx, cancel := store.retry.withDeadline(ctx)
+	defer cancel()
+	item, current, err := store.getUser(ctx, id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if current.DeletedAt == nil {
+		return models.User{}, ErrNotDeleted
+	}
+
+	now := store.now().UTC()
+	// TTL deletion isn't immediate, so the item can outlive its expiry
+	if now.Unix() >= store.expiry(*current.DeletedAt) {
+		return models.User{}, ErrNotFound
+	}
+
+	if expectedVersion != 0 && current.Version != expectedVersion {
+		return models.User{}, ErrConflict
+	}
+
+	restored := current
+	restored.DeletedAt = nil
+	restored.Version = current.Version + 1
+	restored.UpdatedAt = now
+
+	update, err := store.buildUpdate(ctx, item, current.Version, restored)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	changes, err := store.recordChange(ctx, models.HistoryRestore, current, restored)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	_, err = store.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{
+				Update: update,
+			},
+			// Replaces any held reservation, clearing its TTL
+			store.reserveEmail(ctx, restored.Email, id, nil),
+		}, changes...),
+	})
+	if err != nil {
+		if cancellationReason(err, 1) == "ConditionalCheckFailed" {
+			return models.User{}, wrapError(ErrEmailTaken, err)
+		}
+		return models.User{}, classifyError(err)
+	}
+
+	return restored, nil
+}
+
+/*
+Builds a write of the email reservation item for the given user, which fails if the email is reserved by
+another user. If expiry is set, the reservation will be purged by DynamoDB's TTL at that time.
+*/
+func (store UserStore) reserveEmail(ctx context.Context, email string, userID string, expiry *int64) types.TransactWriteItem {
+	item := schema.EmailReservationKey(tenant.FromContext(ctx), store.emailIndexValue(email))
+	item["userID"] = &types.AttributeValueMemberS{Value: userID}
+	if expiry != nil {
+		item[TTLKey] = &types.AttributeValueMemberN{Value: strconv.FormatInt(*expiry, 10)}
+	}
+
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:           aws.String(store.tableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(#pk) OR #userID = :userID"),
+			ExpressionAttributeNames: map[string]string{
+				"#pk":     PKKey,
+				"#userID": "userID",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":userID": &types.AttributeValueMemberS{Value: userID},
+			},
+		},
+	}
+}
+
+// Builds a delete of the email reservation item, provided it is held by the given user
+func (store UserStore) releaseEmail(ctx context.Context, email string, userID string) types.TransactWriteItem {
+	return store.releaseReservation(store.getEmailPK(ctx, email), userID)
+}
+
+// Builds a delete of the reservation item with the given key, provided it is held by the given user
+func (store UserStore) releaseReservation(pk string, userID string) types.TransactWriteItem {
+	return types.TransactWriteItem{
+		Delete: &types.Delete{
+			TableName: aws.String(store.tableName),
+			Key: map[string]types.AttributeValue{
+				PKKey: &types.AttributeValueMemberS{Value: pk},
+				SKKey: &types.AttributeValueMemberS{Value: schema.EmailSK},
+			},
+			// Users written before reservations existed won't have one to release
+			ConditionExpression: aws.String("attribute_not_exists(#pk) OR #userID = :userID"),
+			ExpressionAttributeNames: map[string]string{
+				"#pk":     PKKey,
+				"#userID": "userID",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":userID": &types.AttributeValueMemberS{Value: userID},
+			},
+		},
+	}
+}
+
+// expiry returns the TTL, in epoch seconds, of a user deleted at the given time
+func (store UserStore) expiry(deletedAt time.Time) int64 {
+	return deletedAt.Add(store.restoreWindow).Unix()
+}
+
+/*
+marshalUser builds the stored item for a user, including its keys. Encrypted fields are encrypted, deleted users
+are given a TTL and removed from the creation index, and are removed from the email index if their email has
+been released.
+*/
+func (store UserStore) marshalUser(ctx context.Context, user models.User) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(user)
+	if err != nil {
+		return nil, errors.Wrap(err, "an error ocurred marshaling the record")
+	}
+
+	email, err := store.seal(ctx, user.UserID, emailField, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	item[emailField] = &types.AttributeValueMemberS{Value: email}
+
+	for k, v := range store.getUserKey(ctx, user.UserID) {
+		item[k] = v
+	}
+	if user.DeletedAt == nil || store.deletedEmailPolicy == HoldEmail {
+		item[GSI1Key] = &types.AttributeValueMemberS{Value: store.getUserGSI1(ctx, user.Email)}
+	}
+	if user.DeletedAt == nil {
+		item[GSI3Key] = &types.AttributeValueMemberS{Value: store.getCreatedBucket(ctx, user.CreatedAt)}
+		item[schema.GSI3SortKey] = &types.AttributeValueMemberS{Value: schema.CreatedOrderKey(user.CreatedAt, user.UserID)}
+	}
+	if user.DeletedAt != nil {
+		item[TTLKey] = &types.AttributeValueMemberN{Value: strconv.FormatInt(store.expiry(*user.DeletedAt), 10)}
+	}
+
+	return item, nil
+}
+
+// applyUpdate returns a copy of the user with the given changes applied
+func applyUpdate(user models.User, changes models.UserUpdate) models.User {
+	if changes.Email != nil {
+		user.Email = strings.TrimSpace(*changes.Email)
+	}
+	return user
+}
+
+/*
+buildUpdate builds an update of the stored item to the given user, conditional on the stored record still
+being at currentVersion. A currentVersion of zero is the version of records written before versioning was
+introduced, which have no version attribute. Only the attributes that differ are set, and any that are no
+longer present are removed.
+*/
+func (store UserStore) buildUpdate(ctx context.Context, item map[string]types.AttributeValue, currentVersion int64, updated models.User) (*types.Update, error) {
+	after, err := store.marshalUser(ctx, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{"#pk": PKKey}
+	values := map[string]types.AttributeValue{}
+	sets := []string{}
+	removes := []string{}
+
+	// Sorted so that the expression is deterministic
+	attributes := make([]string, 0, len(after)+len(item))
+	for name := range after {
+		attributes = append(attributes, name)
+	}
+	for name := range item {
+		if _, ok := after[name]; !ok {
+			attributes = append(attributes, name)
+		}
+	}
+	sort.Strings(attributes)
+
+	for i, name := range attributes {
+		if name == PKKey || name == SKKey || reflect.DeepEqual(item[name], after[name]) {
+			continue
+		}
+		names[fmt.Sprintf("#a%d", i)] = name
+		if _, ok := after[name]; !ok {
+			removes = append(removes, fmt.Sprintf("#a%d", i))
+			continue
+		}
+		values[fmt.Sprintf(":a%d", i)] = after[name]
+		sets = append(sets, fmt.Sprintf("#a%d = :a%d", i, i))
+	}
+
+	expression := "SET " + strings.Join(sets, ", ")
+	if len(removes) > 0 {
+		expression += " REMOVE " + strings.Join(removes, ", ")
+	}
+
+	names["#version"] = VersionKey
+	condition := "attribute_exists(#pk) AND #version = :version"
+	if currentVersion == 0 {
+		condition = "attribute_exists(#pk) AND attribute_not_exists(#version)"
+	} else {
+		values[":version"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(currentVersion, 10)}
+	}
+	if len(values) == 0 {
+		// DynamoDB rejects empty expression attribute values
+		values = nil
+	}
+
+	return &types.Update{
+		TableName:                 aws.String(store.tableName),
+		Key:                       store.getUserKey(ctx, updated.UserID),
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}, nil
+}
+
+/*
+recordChange builds the writes that record the change from before to after, which go in the same transaction as
+the change itself: the history item, and the outbox item if the store has an outbox.
+*/
+func (store UserStore) recordChange(ctx context.Context, action models.HistoryAction, before models.User, after models.User) ([]types.TransactWriteItem, error) {
+	entry := NewHistoryEntry(ctx, action, before, after)
+	var err error
+	entry.Changes, err = store.sealChanges(ctx, after.UserID, entry.Changes)
+	if err != nil {
+		return nil, err
+	}
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return nil, errors.Wrap(err, "an error ocurred marshaling the history entry")
+	}
+	item[PKKey] = &types.AttributeValueMemberS{Value: store.getUserPK(ctx, after.UserID)}
+	item[SKKey] = &types.AttributeValueMemberS{Value: schema.HistorySK(entry.Timestamp, entry.Version)}
+	writes := []types.TransactWriteItem{store.putImmutable(item)}
+
+	if store.outbox {
+		item, err := store.outboxItem(ctx, action, before, after)
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, store.putImmutable(item))
+	}
+
+	return writes, nil
+}
+
+// putImmutable builds a write of an item that must not already exist, such as history
+func (store UserStore) putImmutable(item map[string]types.AttributeValue) types.TransactWriteItem {
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:                aws.String(store.tableName),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+			ExpressionAttributeNames: map[string]string{"#pk": PKKey},
+		},
+	}
+}
+
+/*
+versionCondition builds the condition for a write that expects the stored record to be at the given version. A
+zero version expects there to be no stored record, or one written before versioning was introduced, which has no
+version attribute and so is treated as being at version zero.
+*/
+func (store UserStore) versionCondition(expectedVersion int64) (*string, map[string]string, map[string]types.AttributeValue) {
+	if expectedVersion == 0 {
+		names := map[string]string{"#pk": PKKey, "#version": VersionKey}
+		return aws.String("attribute_not_exists(#pk) OR attribute_not_exists(#version)"), names, nil
+	}
+
+	names := map[string]string{"#version": VersionKey}
+	values := map[string]types.AttributeValue{
+		":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(expectedVersion, 10)},
+	}
+	return aws.String("#version = :version"), names, values
+}
+
+/*
+The key builders scope every key to the tenant the request is made on behalf of, which is what keeps tenants
+apart: a user or email can only be reached through keys built for its own tenant.
+*/
+func (store UserStore) getUserPK(ctx context.Context, userID string) (_pk string) {
+	return schema.UserPK(tenant.FromContext(ctx), userID)
 }
 
-func (store UserStore) getUserGSI1(email string) (gsi1 string) {
-	return fmt.Sprintf("email/%s", email)
+func (store UserStore) getUserKey(ctx context.Context, userID string) map[string]types.AttributeValue {
+	return schema.UserKey(tenant.FromContext(ctx), userID)
 }
 
-func (store UserStore) getUserSK(userID string) (_pk string) {
-	return userID
+func (store UserStore) getUserGSI1(ctx context.Context, email string) (gsi1 string) {
+	return schema.EmailKey(tenant.FromContext(ctx), store.emailIndexValue(email))
+}
+
+func (store UserStore) getEmailPK(ctx context.Context, email string) (_pk string) {
+	return schema.EmailKey(tenant.FromContext(ctx), store.emailIndexValue(email))
+}
+
+func (store UserStore) getCreatedBucket(ctx context.Context, createdAt time.Time) (gsi3 string) {
+	return schema.CreatedBucket(tenant.FromContext(ctx), createdAt)
+}
+
+/*
+emailIndexValue returns what the email is keyed by. Keys are built from the normalised email, so that lookups
+and uniqueness checks ignore differences such as case that don't change which mailbox an address delivers to,
+and if the store encrypts they are built from its blind index key so that the email can't be read from them.
+*/
+func (store UserStore) emailIndexValue(email string) string {
+	normalized := store.emailPolicy.Normalize(email)
+	if store.cipher == nil {
+		return normalized
+	}
+	return store.blindIndex.Key(emailField, normalized)
 }
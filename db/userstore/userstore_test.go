@@ -2,23 +2,32 @@ package userstore
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
 	"github.com/benjaminkitson/bk-user-api/internal/testhelpers"
 	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/benjaminkitson/bk-user-api/userevents"
 	"github.com/google/uuid"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func NewStore(t *testing.T) UserStore {
+func NewStore(t *testing.T, opts ...Option) UserStore {
 	th := testhelpers.DBTester{}
 	testTableName := "user"
 	tableName := th.CreateLocalTable(t, testTableName)
 	client := th.GetTestClient()
 	t.Cleanup(func() { th.DeleteLocalTable(t, tableName) })
-	return NewUserStore(client, testTableName)
+	opts = append([]Option{WithCursorKey([]byte("test-cursor-key"))}, opts...)
+	return NewUserStore(client, testTableName, opts...)
 }
 
 // TODO: generally tidy these up a bit, make them a bit more robust
@@ -44,8 +53,130 @@ func TestGetUserByEmail(t *testing.T) {
 		t.Fatalf("Expected email to be benk13@gmail.com, got %v", r.Email)
 	}
 
-	// _, err = store.GetByEmail(ctx, "nonexistent@gmail.com")
+	_, err = store.GetByEmail(ctx, "nonexistent@gmail.com")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestGetUserByIDNotFound(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	_, err := store.GetByID(ctx, "nonexistent")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestListUsers(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	expected := map[string]bool{"12345": true}
+	for i := 0; i < 5; i++ {
+		u, err := store.Create(ctx, models.User{Email: fmt.Sprintf("list%d@gmail.com", i), UserID: uuid.New().String()})
+		require.NoError(t, err)
+		expected[u.UserID] = true
+	}
+
+	found := map[string]bool{}
+	cursor := ""
+	for {
+		page, err := store.List(ctx, 2, cursor)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Users), 2)
+		for _, u := range page.Users {
+			assert.False(t, found[u.UserID], "user %s returned more than once", u.UserID)
+			found[u.UserID] = true
+		}
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+
+	assert.Equal(t, expected, found)
+}
+
+func TestListUsersInvalidCursor(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	_, err := store.List(ctx, 2, "not a cursor")
+	require.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestListCreatedBetween(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t, WithClock(func() time.Time { return time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC) }))
+	u, err := store.Create(ctx, models.User{Email: "created@gmail.com", UserID: uuid.New().String()})
+	require.NoError(t, err)
+
+	from := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	page, err := store.ListCreatedBetween(ctx, from, to, 1, "")
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, "12345", page.Users[0].UserID)
+	require.NotEmpty(t, page.Cursor)
+
+	next, err := store.ListCreatedBetween(ctx, from, to, 1, page.Cursor)
+	require.NoError(t, err)
+	require.Len(t, next.Users, 1)
+	assert.Equal(t, u.UserID, next.Users[0].UserID)
+
+	// Cursors only resume the range they were issued for
+	_, err = store.ListCreatedBetween(ctx, to, to.AddDate(0, 1, 0), 1, page.Cursor)
+	require.ErrorIs(t, err, ErrInvalidCursor)
+	listed, err := store.List(ctx, 1, "")
+	require.NoError(t, err)
+	_, err = store.ListCreatedBetween(ctx, from, to, 1, listed.Cursor)
+	require.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestBackfillCreatedIndex(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	// Write users the way they were stored before the creation index was added
+	legacy := func(id string, createdAt time.Time, deletedAt *time.Time) {
+		u := models.User{UserID: id, Email: id + "@example.com", Version: 1, CreatedAt: createdAt, DeletedAt: deletedAt}
+		item, err := attributevalue.MarshalMap(u)
+		require.NoError(t, err)
+		item[PKKey] = &types.AttributeValueMemberS{Value: "user/" + id}
+		item[SKKey] = &types.AttributeValueMemberS{Value: schema.UserSK}
+		_, err = store.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: &store.tableName, Item: item})
+		require.NoError(t, err)
+	}
+	createdAt := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	legacy("legacy", createdAt, nil)
+	deletedAt := createdAt.Add(time.Hour)
+	legacy("deleted", createdAt, &deletedAt)
+
+	from := createdAt.AddDate(0, 0, -1)
+	to := createdAt.AddDate(0, 0, 1)
+	page, err := store.ListCreatedBetween(ctx, from, to, 0, "")
+	require.NoError(t, err)
+	assert.Empty(t, page.Users)
+
+	report, err := store.BackfillCreatedIndex(ctx, CreatedIndexOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Scanned)
+	assert.Equal(t, 1, report.Unindexed)
+	assert.Equal(t, 0, report.Indexed)
+
+	report, err = store.BackfillCreatedIndex(ctx, CreatedIndexOptions{WritesPerSecond: 100})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Indexed)
+	assert.Empty(t, report.Failed)
 
+	page, err = store.ListCreatedBetween(ctx, from, to, 0, "")
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, "legacy", page.Users[0].UserID)
+	// Indexing doesn't count as a change to the user
+	assert.Equal(t, int64(1), page.Users[0].Version)
+
+	// Running it again is a no-op
+	report, err = store.BackfillCreatedIndex(ctx, CreatedIndexOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Unindexed)
 }
 
 func TestPutUser(t *testing.T) {
@@ -67,6 +198,193 @@ func TestPutUser(t *testing.T) {
 	}
 }
 
+func TestCreateUser(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	id := uuid.New().String()
+	email := "created@gmail.com"
+
+	u, err := store.Create(ctx, models.User{Email: email, UserID: id})
+	require.NoError(t, err)
+	assert.Equal(t, id, u.UserID)
+
+	r, err := store.GetByEmail(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, id, r.UserID)
+}
+
+func TestCreateUserSetsVersion(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	u, err := store.Create(ctx, models.User{Email: "versioned@gmail.com", UserID: uuid.New().String()})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), u.Version)
+	assert.Equal(t, now, u.CreatedAt)
+	assert.Equal(t, now, u.UpdatedAt)
+
+	r, err := store.GetByID(ctx, u.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, u, r)
+}
+
+func TestCreateUserEmailTaken(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	email := "taken@gmail.com"
+
+	_, err := store.Create(ctx, models.User{Email: email, UserID: uuid.New().String()})
+	require.NoError(t, err)
+
+	_, err = store.Create(ctx, models.User{Email: email, UserID: uuid.New().String()})
+	require.ErrorIs(t, err, ErrEmailTaken)
+}
+
+func TestDeleteUserHoldsEmail(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	id := uuid.New().String()
+	email := "held@gmail.com"
+
+	_, err := store.Create(ctx, models.User{Email: email, UserID: id})
+	require.NoError(t, err)
+
+	_, err = store.Delete(ctx, id, 0)
+	require.NoError(t, err)
+
+	_, err = store.Create(ctx, models.User{Email: email, UserID: uuid.New().String()})
+	require.ErrorIs(t, err, ErrEmailTaken)
+
+	_, err = store.GetByEmail(ctx, email)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	r, err := store.GetByEmail(ctx, email, IncludeDeleted())
+	require.NoError(t, err)
+	assert.Equal(t, id, r.UserID)
+}
+
+func TestDeleteUserReleasesEmail(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t, WithDeletedEmailPolicy(ReleaseEmail))
+	id := uuid.New().String()
+	email := "released@gmail.com"
+
+	_, err := store.Create(ctx, models.User{Email: email, UserID: id})
+	require.NoError(t, err)
+
+	_, err = store.Delete(ctx, id, 0)
+	require.NoError(t, err)
+
+	_, err = store.Create(ctx, models.User{Email: email, UserID: uuid.New().String()})
+	require.NoError(t, err)
+
+	// The email now belongs to someone else
+	_, err = store.Restore(ctx, id, 0)
+	require.ErrorIs(t, err, ErrEmailTaken)
+}
+
+func TestPutUserVersionConflict(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	id := uuid.New().String()
+
+	u, err := store.Put(ctx, models.User{Email: "someother@gmail.com", UserID: id})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), u.Version)
+
+	// A new record must not overwrite an existing one
+	_, err = store.Put(ctx, models.User{Email: "someother@gmail.com", UserID: id})
+	require.ErrorIs(t, err, ErrConflict)
+
+	updated, err := store.Put(ctx, u)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), updated.Version)
+	assert.Equal(t, u.CreatedAt, updated.CreatedAt)
+
+	// u is now stale
+	_, err = store.Put(ctx, u)
+	require.ErrorIs(t, err, ErrConflict)
+}
+
+func TestUpdateUserEmail(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	id := uuid.New().String()
+	oldEmail := "before@gmail.com"
+	newEmail := "after@gmail.com"
+
+	u, err := store.Create(ctx, models.User{Email: oldEmail, UserID: id})
+	require.NoError(t, err)
+
+	updated, err := store.Update(ctx, id, models.UserUpdate{Email: &newEmail}, u.Version)
+	require.NoError(t, err)
+	assert.Equal(t, newEmail, updated.Email)
+	assert.Equal(t, u.Version+1, updated.Version)
+
+	r, err := store.GetByEmail(ctx, newEmail)
+	require.NoError(t, err)
+	assert.Equal(t, updated, r)
+
+	_, err = store.GetByEmail(ctx, oldEmail)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// The old email should have been released, and the new one reserved
+	_, err = store.Create(ctx, models.User{Email: oldEmail, UserID: uuid.New().String()})
+	require.NoError(t, err)
+	_, err = store.Create(ctx, models.User{Email: newEmail, UserID: uuid.New().String()})
+	require.ErrorIs(t, err, ErrEmailTaken)
+}
+
+func TestUpdateUnversionedUser(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	email := "updated@gmail.com"
+
+	// The seeded user was written before versioning was introduced
+	u, err := store.Update(ctx, "12345", models.UserUpdate{Email: &email}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), u.Version)
+
+	r, err := store.GetByEmail(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, u, r)
+}
+
+func TestUpdateUserEmailTaken(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	id := uuid.New().String()
+	takenEmail := "benk13@gmail.com"
+
+	u, err := store.Create(ctx, models.User{Email: "mine@gmail.com", UserID: id})
+	require.NoError(t, err)
+
+	_, err = store.Update(ctx, id, models.UserUpdate{Email: &takenEmail}, 0)
+	require.ErrorIs(t, err, ErrEmailTaken)
+
+	r, err := store.GetByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, u, r)
+}
+
+func TestUpdateUserConflict(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	id := uuid.New().String()
+	newEmail := "after@gmail.com"
+
+	u, err := store.Create(ctx, models.User{Email: "before@gmail.com", UserID: id})
+	require.NoError(t, err)
+
+	_, err = store.Update(ctx, id, models.UserUpdate{Email: &newEmail}, u.Version+1)
+	require.ErrorIs(t, err, ErrConflict)
+
+	_, err = store.Update(ctx, "nonexistent", models.UserUpdate{Email: &newEmail}, 0)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
 func TestDeleteUser(t *testing.T) {
 	ctx := context.Background()
 	store := NewStore(t)
@@ -76,8 +394,346 @@ func TestDeleteUser(t *testing.T) {
 	_, err := store.Put(ctx, models.User{Email: email, UserID: id})
 	require.NoError(t, err)
 
-	s, err := store.Delete(ctx, id)
+	s, err := store.Delete(ctx, id, 0)
 	require.NoError(t, err)
 
 	assert.Equal(t, s, id)
+
+	_, err = store.GetByID(ctx, id)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	r, err := store.GetByID(ctx, id, IncludeDeleted())
+	require.NoError(t, err)
+	require.NotNil(t, r.DeletedAt)
+
+	_, err = store.Delete(ctx, id, 0)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRestoreUser(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	id := uuid.New().String()
+	email := "restored@gmail.com"
+
+	u, err := store.Create(ctx, models.User{Email: email, UserID: id})
+	require.NoError(t, err)
+
+	// Users that haven't been deleted can't be restored
+	_, err = store.Restore(ctx, id, 0)
+	require.ErrorIs(t, err, ErrNotDeleted)
+
+	_, err = store.Delete(ctx, id, u.Version)
+	require.NoError(t, err)
+
+	r, err := store.Restore(ctx, id, u.Version+1)
+	require.NoError(t, err)
+	assert.Nil(t, r.DeletedAt)
+	assert.Equal(t, u.Version+2, r.Version)
+
+	r, err = store.GetByEmail(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, id, r.UserID)
+}
+
+func TestDeleteAndRestoreUnversionedUser(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	// The seeded user was written before versioning was introduced, so is at version zero
+	_, err := store.Delete(ctx, "12345", 0)
+	require.NoError(t, err)
+
+	r, err := store.GetByID(ctx, "12345", IncludeDeleted())
+	require.NoError(t, err)
+	require.NotNil(t, r.DeletedAt)
+	assert.Equal(t, int64(1), r.Version)
+
+	r, err = store.Restore(ctx, "12345", r.Version)
+	require.NoError(t, err)
+	assert.Nil(t, r.DeletedAt)
+	assert.Equal(t, int64(2), r.Version)
+}
+
+func TestRestoreUserAfterWindow(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t, WithRestoreWindow(time.Hour))
+	id := uuid.New().String()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	_, err := store.Create(ctx, models.User{Email: "expired@gmail.com", UserID: id})
+	require.NoError(t, err)
+
+	_, err = store.Delete(ctx, id, 0)
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Hour)
+	_, err = store.Restore(ctx, id, 0)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestPutUnversionedUser(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	// The seeded user was written before versioning was introduced, so can be rewritten as a new record
+	u, err := store.Put(ctx, models.User{Email: "benk13@gmail.com", UserID: "12345"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), u.Version)
+
+	// But once it has a version, writes must expect it
+	_, err = store.Put(ctx, models.User{Email: "benk13@gmail.com", UserID: "12345"})
+	require.ErrorIs(t, err, ErrConflict)
+
+	history, err := store.History(ctx, "12345", 10, "")
+	require.NoError(t, err)
+	require.Len(t, history.Entries, 1)
+	assert.Equal(t, int64(1), history.Entries[0].Version)
+}
+
+func TestDeleteUserVersionConflict(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	id := uuid.New().String()
+
+	u, err := store.Put(ctx, models.User{Email: "someother@gmail.com", UserID: id})
+	require.NoError(t, err)
+
+	_, err = store.Delete(ctx, id, u.Version+1)
+	require.ErrorIs(t, err, ErrConflict)
+
+	_, err = store.Delete(ctx, id, u.Version)
+	require.NoError(t, err)
+}
+
+func TestDeleteUserNotFound(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	_, err := store.Delete(ctx, "nonexistent", 0)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	var ccf *types.ConditionalCheckFailedException
+	require.ErrorAs(t, err, &ccf)
+}
+
+func TestGetUserByEmailNormalized(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	r, err := store.GetByEmail(ctx, "  BenK13@Gmail.COM ")
+	require.NoError(t, err)
+	assert.Equal(t, "12345", r.UserID)
+}
+
+func TestCreateUserEmailTakenCaseVariant(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	_, err := store.Create(ctx, models.User{Email: "BENK13@gmail.com", UserID: uuid.New().String()})
+	require.ErrorIs(t, err, ErrEmailTaken)
+}
+
+func TestPutUserIndexesEmail(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+	id := uuid.New().String()
+
+	_, err := store.Put(ctx, models.User{Email: "Put@gmail.com", UserID: id})
+	require.NoError(t, err)
+
+	r, err := store.GetByEmail(ctx, "put@gmail.com")
+	require.NoError(t, err)
+	assert.Equal(t, id, r.UserID)
+}
+
+func TestMigrateEmailKeys(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	// Write users the way they were stored before emails were normalised
+	legacy := func(id, email string) {
+		u := models.User{UserID: id, Email: email, Version: 1}
+		item, err := attributevalue.MarshalMap(u)
+		require.NoError(t, err)
+		item[PKKey] = &types.AttributeValueMemberS{Value: "user/" + id}
+		item[SKKey] = &types.AttributeValueMemberS{Value: schema.UserSK}
+		item[GSI1Key] = &types.AttributeValueMemberS{Value: "email/" + email}
+		_, err = store.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: &store.tableName, Item: item})
+		require.NoError(t, err)
+		_, err = store.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: &store.tableName,
+			Item: map[string]types.AttributeValue{
+				PKKey:    &types.AttributeValueMemberS{Value: "email/" + email},
+				SKKey:    &types.AttributeValueMemberS{Value: schema.EmailSK},
+				"userID": &types.AttributeValueMemberS{Value: id},
+			},
+		})
+		require.NoError(t, err)
+	}
+	legacy("legacy", "Legacy@Example.com")
+	legacy("dupe1", "Dupe@Example.com")
+	legacy("dupe2", "dupe@example.com")
+
+	report, err := store.MigrateEmailKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Scanned)
+	assert.Equal(t, 1, report.Rekeyed)
+	assert.Empty(t, report.Failed)
+	assert.Equal(t, []EmailCollision{{NormalizedEmail: "dupe@example.com", UserIDs: []string{"dupe1", "dupe2"}}}, report.Collisions)
+
+	r, err := store.GetByEmail(ctx, "legacy@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", r.UserID)
+
+	// The old reservation was moved rather than left behind
+	_, err = store.Create(ctx, models.User{Email: "Legacy@Example.com", UserID: uuid.New().String()})
+	require.ErrorIs(t, err, ErrEmailTaken)
+	out, err := store.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &store.tableName,
+		Key:       schema.EmailReservationKey(tenant.Default, "Legacy@Example.com"),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, out.Item)
+
+	// Running it again is a no-op
+	report, err = store.MigrateEmailKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Rekeyed)
+}
+
+func TestRepairEmailIndex(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(t)
+
+	// Write users the way they were stored before emails were normalised, or by the old Put
+	legacy := func(id, email, gsi1 string, reserved bool) {
+		u := models.User{UserID: id, Email: email, Version: 1}
+		item, err := attributevalue.MarshalMap(u)
+		require.NoError(t, err)
+		item[PKKey] = &types.AttributeValueMemberS{Value: "user/" + id}
+		item[SKKey] = &types.AttributeValueMemberS{Value: schema.UserSK}
+		item[GSI1Key] = &types.AttributeValueMemberS{Value: gsi1}
+		_, err = store.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: &store.tableName, Item: item})
+		require.NoError(t, err)
+		if !reserved {
+			return
+		}
+		_, err = store.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: &store.tableName,
+			Item: map[string]types.AttributeValue{
+				PKKey:    &types.AttributeValueMemberS{Value: gsi1},
+				SKKey:    &types.AttributeValueMemberS{Value: schema.EmailSK},
+				"userID": &types.AttributeValueMemberS{Value: id},
+			},
+		})
+		require.NoError(t, err)
+	}
+	legacy("legacy", "Legacy@Example.com", "email/Legacy@Example.com", true)
+	legacy("put", "put@example.com", "user/put@example.com", false)
+	legacy("dupe1", "Dupe@Example.com", "email/Dupe@Example.com", true)
+	legacy("dupe2", "dupe@example.com", "email/dupe@example.com", true)
+	legacy("taken", "BENK13@gmail.com", "user/BENK13@gmail.com", false)
+
+	report, err := store.RepairEmailIndex(ctx, EmailRepairOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 6, report.Scanned)
+	assert.Equal(t, 0, report.Repaired)
+	assert.ElementsMatch(t, []EmailRepair{
+		{UserID: "legacy", StoredKey: "email/Legacy@Example.com", CanonicalKey: "email/legacy@example.com", MissingReservation: true},
+		{UserID: "put", StoredKey: "user/put@example.com", CanonicalKey: "email/put@example.com", MissingReservation: true},
+	}, report.Repairs)
+	assert.Equal(t, []EmailCollision{
+		{NormalizedEmail: "benk13@gmail.com", UserIDs: []string{"12345", "taken"}},
+		{NormalizedEmail: "dupe@example.com", UserIDs: []string{"dupe1", "dupe2"}},
+	}, report.Collisions)
+
+	_, err = store.GetByEmail(ctx, "put@example.com")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	report, err = store.RepairEmailIndex(ctx, EmailRepairOptions{WritesPerSecond: 100})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Repaired)
+	assert.Empty(t, report.Failed)
+
+	r, err := store.GetByEmail(ctx, "legacy@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", r.UserID)
+
+	r, err = store.GetByEmail(ctx, "PUT@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "put", r.UserID)
+
+	// Both emails are now protected, and the old reservation was moved rather than left behind
+	_, err = store.Create(ctx, models.User{Email: "put@example.com", UserID: uuid.New().String()})
+	require.ErrorIs(t, err, ErrEmailTaken)
+	_, err = store.Create(ctx, models.User{Email: "Legacy@Example.com", UserID: uuid.New().String()})
+	require.ErrorIs(t, err, ErrEmailTaken)
+	out, err := store.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &store.tableName,
+		Key:       schema.EmailReservationKey(tenant.Default, "Legacy@Example.com"),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, out.Item)
+
+	// Running it again is a no-op
+	report, err = store.RepairEmailIndex(ctx, EmailRepairOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, report.Repairs)
+	assert.Len(t, report.Collisions, 2)
+}
+
+type failingPublisher struct {
+	failures int
+	userevents.MemoryPublisher
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, event userevents.Event) error {
+	if p.failures > 0 {
+		p.failures--
+		return fmt.Errorf("publish failed")
+	}
+	return p.MemoryPublisher.Publish(ctx, event)
+}
+
+func TestRelayOutbox(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := NewStore(t, WithOutbox(), WithClock(func() time.Time { return now }))
+
+	created, err := store.Create(ctx, models.User{Email: "outbox@example.com", UserID: uuid.New().String()})
+	require.NoError(t, err)
+	email := "outbox2@example.com"
+	_, err = store.Update(ctx, created.UserID, models.UserUpdate{Email: &email}, created.Version)
+	require.NoError(t, err)
+
+	// The first publish fails, which holds back the user's later event too
+	p := &failingPublisher{failures: 1}
+	report, err := store.RelayOutbox(ctx, p, RelayOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, RelayReport{Failed: 1, Deferred: 1}, report)
+	assert.Empty(t, p.Events())
+
+	// Nothing is retried until the backoff has passed
+	report, err = store.RelayOutbox(ctx, p, RelayOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, RelayReport{Deferred: 2}, report)
+
+	now = now.Add(time.Minute)
+	report, err = store.RelayOutbox(ctx, p, RelayOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, RelayReport{Published: 2}, report)
+
+	events := p.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, userevents.UserCreated, events[0].Type)
+	assert.Equal(t, userevents.UserUpdated, events[1].Type)
+	assert.Equal(t, email, events[1].User.Email)
+	assert.Equal(t, "outbox@example.com", events[1].Previous.Email)
+
+	// Delivered items aren't published again
+	report, err = store.RelayOutbox(ctx, p, RelayOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, RelayReport{}, report)
 }
@@ -0,0 +1,54 @@
+package emailaddr
+
+import "strings"
+
+/*
+Policy controls how email addresses are normalised, so that addresses that deliver to the same mailbox are
+treated as the same address. The domain is always lowercased, as domains are case-insensitive.
+*/
+type Policy struct {
+	// LowercaseLocalPart lowercases the part before the @. Strictly, local parts are case-sensitive, but in
+	// practice almost every provider treats them case-insensitively.
+	LowercaseLocalPart bool
+	// FoldGmail removes dots and +suffixes from the local part of Gmail addresses, which Gmail ignores, and
+	// treats googlemail.com as gmail.com
+	FoldGmail bool
+}
+
+// DefaultPolicy lowercases the whole address, but doesn't apply any provider specific folding
+var DefaultPolicy = Policy{
+	LowercaseLocalPart: true,
+}
+
+var gmailDomains = map[string]bool{
+	"gmail.com":      true,
+	"googlemail.com": true,
+}
+
+// Normalize returns the canonical form of the address under the policy
+func (p Policy) Normalize(address string) string {
+	address = strings.TrimSpace(address)
+
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		if p.LowercaseLocalPart {
+			return strings.ToLower(address)
+		}
+		return address
+	}
+
+	local := address[:i]
+	domain := strings.ToLower(address[i+1:])
+
+	if p.LowercaseLocalPart {
+		local = strings.ToLower(local)
+	}
+
+	if p.FoldGmail && gmailDomains[domain] {
+		domain = "gmail.com"
+		local, _, _ = strings.Cut(local, "+")
+		local = strings.ReplaceAll(local, ".", "")
+	}
+
+	return local + "@" + domain
+}
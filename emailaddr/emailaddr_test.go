@@ -0,0 +1,67 @@
+package emailaddr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	type test struct {
+		Name     string
+		Policy   Policy
+		Address  string
+		Expected string
+	}
+
+	tests := []test{
+		{
+			Name:     "Trims whitespace",
+			Policy:   DefaultPolicy,
+			Address:  "  abc@gmail.com\n",
+			Expected: "abc@gmail.com",
+		},
+		{
+			Name:     "Lowercases the whole address by default",
+			Policy:   DefaultPolicy,
+			Address:  "Foo.Bar@Example.COM",
+			Expected: "foo.bar@example.com",
+		},
+		{
+			Name:     "Preserves local part case when configured",
+			Policy:   Policy{},
+			Address:  "Foo.Bar@Example.COM",
+			Expected: "Foo.Bar@example.com",
+		},
+		{
+			Name:     "Folds gmail addresses",
+			Policy:   Policy{LowercaseLocalPart: true, FoldGmail: true},
+			Address:  "Foo.Bar+signup@GoogleMail.com",
+			Expected: "foobar@gmail.com",
+		},
+		{
+			Name:     "Doesn't fold other domains",
+			Policy:   Policy{LowercaseLocalPart: true, FoldGmail: true},
+			Address:  "foo.bar+signup@example.com",
+			Expected: "foo.bar+signup@example.com",
+		},
+		{
+			Name:     "Doesn't fold gmail addresses by default",
+			Policy:   DefaultPolicy,
+			Address:  "foo.bar+signup@gmail.com",
+			Expected: "foo.bar+signup@gmail.com",
+		},
+		{
+			Name:     "Uses the last @ as the separator",
+			Policy:   DefaultPolicy,
+			Address:  "\"a@b\"@Example.com",
+			Expected: "\"a@b\"@example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			assert.Equal(t, tt.Expected, tt.Policy.Normalize(tt.Address))
+		})
+	}
+}
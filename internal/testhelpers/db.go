@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -12,7 +13,9 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
 	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/tenant"
 )
 
 type DBTester struct {
@@ -20,53 +23,16 @@ type DBTester struct {
 }
 
 func (d DBTester) CreateLocalTable(t *testing.T, tableName string) string {
-	_, err := d.GetTestClient().CreateTable(context.Background(), &dynamodb.CreateTableInput{
-		AttributeDefinitions: []types.AttributeDefinition{
-			{
-				AttributeName: aws.String("_pk"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-			// {
-			// 	AttributeName: aws.String("_sk"),
-			// 	AttributeType: types.ScalarAttributeTypeS,
-			// },
-			{
-				AttributeName: aws.String("_gsi1"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-		},
-		KeySchema: []types.KeySchemaElement{
-			{
-				AttributeName: aws.String("_pk"),
-				KeyType:       types.KeyTypeHash,
-			},
-			// {
-			// 	AttributeName: aws.String("_sk"),
-			// 	KeyType:       types.KeyTypeRange,
-			// },
-		},
-		BillingMode: types.BillingModePayPerRequest,
-		TableName:   aws.String(tableName),
-		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
-			{
-				IndexName: aws.String("gsi1"),
-				KeySchema: []types.KeySchemaElement{
-					{
-						AttributeName: aws.String("_gsi1"),
-						KeyType:       types.KeyTypeHash,
-					},
-				},
-				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
-			},
-		},
-	})
+	err := schema.UserTable.Create(context.Background(), d.GetTestClient(), tableName)
 	if err != nil {
 		t.Fatalf("failed to create local table: %v", err)
 	}
 
 	testUser := models.User{
-		Email:  "benk13@gmail.com",
-		UserID: "12345",
+		Email:     "benk13@gmail.com",
+		UserID:    "12345",
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 	}
 
 	item, err := attributevalue.MarshalMap(testUser)
@@ -74,9 +40,15 @@ func (d DBTester) CreateLocalTable(t *testing.T, tableName string) string {
 		t.Fatalf("an error ocurred marshaling the record: %v", err)
 	}
 
-	item["_pk"] = &types.AttributeValueMemberS{Value: d.getUserPK(testUser.UserID)}
-	item["_gsi1"] = &types.AttributeValueMemberS{Value: d.getUserGSI1(testUser.Email)}
-	// item["_sk"] = &types.AttributeValueMemberS{Value: d.getUserSK(userID)}
+	// The seeded user was written before versioning was introduced, so has no version
+	delete(item, "version")
+
+	for k, v := range schema.UserKey(tenant.Default, testUser.UserID) {
+		item[k] = v
+	}
+	item[schema.GSI1Key] = &types.AttributeValueMemberS{Value: schema.EmailKey(tenant.Default, testUser.Email)}
+	item[schema.GSI3Key] = &types.AttributeValueMemberS{Value: schema.CreatedBucket(tenant.Default, testUser.CreatedAt)}
+	item[schema.GSI3SortKey] = &types.AttributeValueMemberS{Value: schema.CreatedOrderKey(testUser.CreatedAt, testUser.UserID)}
 
 	_, err = d.GetTestClient().PutItem(context.Background(), &dynamodb.PutItemInput{
 		Item:      item,
@@ -86,19 +58,18 @@ func (d DBTester) CreateLocalTable(t *testing.T, tableName string) string {
 		t.Fatalf("an error ocurred creating the user record: %v", err)
 	}
 
-	return tableName
-}
-
-func (store DBTester) getUserPK(userId string) (_pk string) {
-	return fmt.Sprintf("user/%s", userId)
-}
+	reservation := schema.EmailReservationKey(tenant.Default, testUser.Email)
+	reservation["userID"] = &types.AttributeValueMemberS{Value: testUser.UserID}
 
-func (store DBTester) getUserGSI1(email string) (gsi1 string) {
-	return fmt.Sprintf("email/%s", email)
-}
+	_, err = d.GetTestClient().PutItem(context.Background(), &dynamodb.PutItemInput{
+		Item:      reservation,
+		TableName: &tableName,
+	})
+	if err != nil {
+		t.Fatalf("an error ocurred creating the email reservation record: %v", err)
+	}
 
-func (store DBTester) getUserSK(userId string) (_pk string) {
-	return userId
+	return tableName
 }
 
 func (d DBTester) DeleteLocalTable(t *testing.T, name string) {
@@ -2,8 +2,11 @@ package handler
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/models"
+	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
 	"go.uber.org/zap"
 )
 
@@ -17,17 +20,9 @@ func NewHandler(logger *zap.Logger) (handler, error) {
 	}, nil
 }
 
-var Headers = map[string]string{
-	"Access-Control-Allow-Headers": "Content-Type",
-	"Access-Control-Allow-Origin":  "*",
-	"Access-Control-Allow-Methods": "OPTIONS,POST,GET",
-}
-
+// Handle responds to requests for paths no other handler serves with an invalid path problem
 func (handler handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	handler.logger.Error("invalid path")
-	return events.APIGatewayProxyResponse{
-		StatusCode: 400,
-		Headers:    Headers,
-		Body:       "{\"message\": \"Invalid path\"}",
-	}, nil
+	handler.logger.Error("invalid path", zap.String("path", request.Path))
+	err := utils.NewError(400, models.ProblemInvalidPath, fmt.Sprintf("%s %s isn't a route of the API", request.HTTPMethod, request.Path), nil)
+	return utils.ErrorResponse(request, err), nil
 }
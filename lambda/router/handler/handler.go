@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	createhandler "github.com/benjaminkitson/bk-user-api/lambda/user/create/handler"
+	deletehandler "github.com/benjaminkitson/bk-user-api/lambda/user/delete/handler"
+	gethandler "github.com/benjaminkitson/bk-user-api/lambda/user/get/handler"
+	historyhandler "github.com/benjaminkitson/bk-user-api/lambda/user/history/handler"
+	listhandler "github.com/benjaminkitson/bk-user-api/lambda/user/list/handler"
+	restorehandler "github.com/benjaminkitson/bk-user-api/lambda/user/restore/handler"
+	updatehandler "github.com/benjaminkitson/bk-user-api/lambda/user/update/handler"
+	"github.com/benjaminkitson/bk-user-api/router"
+	"go.uber.org/zap"
+)
+
+type handler struct {
+	logger *zap.Logger
+	router *router.Router
+}
+
+/*
+NewHandler returns a handler serving every route of the user API from the one store, for deployments that route
+every request to a single Lambda.
+*/
+func NewHandler(logger *zap.Logger, u userstore.Store) (handler, error) {
+	r, err := NewRouter(logger, u)
+	if err != nil {
+		return handler{}, err
+	}
+
+	return handler{
+		logger: logger,
+		router: r,
+	}, nil
+}
+
+/*
+NewRouter returns a router with every route of the user API registered, each served by the same handler as it
+is when the API is deployed as a Lambda per operation.
+*/
+func NewRouter(logger *zap.Logger, u userstore.Store) (*router.Router, error) {
+	create, err := createhandler.NewHandler(logger, u)
+	if err != nil {
+		return nil, err
+	}
+	get, err := gethandler.NewHandler(logger, u)
+	if err != nil {
+		return nil, err
+	}
+	update, err := updatehandler.NewHandler(logger, u)
+	if err != nil {
+		return nil, err
+	}
+	del, err := deletehandler.NewHandler(logger, u)
+	if err != nil {
+		return nil, err
+	}
+	restore, err := restorehandler.NewHandler(logger, u)
+	if err != nil {
+		return nil, err
+	}
+	list, err := listhandler.NewHandler(logger, u)
+	if err != nil {
+		return nil, err
+	}
+	history, err := historyhandler.NewHandler(logger, u)
+	if err != nil {
+		return nil, err
+	}
+
+	r := router.New()
+	r.Handle("GET", "/user", list.Handle)
+	r.Handle("POST", "/user", create.Handle)
+	r.Handle("GET", "/user/{id}", get.Handle)
+	r.Handle("PATCH", "/user/{id}", update.Handle)
+	r.Handle("DELETE", "/user/{id}", del.Handle)
+	r.Handle("POST", "/user/{id}/restore", restore.Handle)
+	r.Handle("GET", "/user/{id}/history", history.Handle)
+	r.Handle("POST", "/user/create/batch", create.Handle)
+	r.Handle("POST", "/user/get/batch", get.Handle)
+
+	// The deprecated RPC-style routes
+	r.Handle("POST", "/user/create", create.Handle)
+	r.Handle("POST", "/user/get", get.Handle)
+	r.Handle("PATCH", "/user/update", update.Handle)
+	r.Handle("POST", "/user/delete", del.Handle)
+	r.Handle("POST", "/user/restore", restore.Handle)
+
+	return r, nil
+}
+
+func (handler handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return handler.router.Route(ctx, request)
+}
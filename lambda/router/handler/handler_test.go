@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/memstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"go.uber.org/zap"
+)
+
+/*
+Tests that requests are routed to the handler for each operation, by running through a user's lifecycle
+*/
+func TestHandler(t *testing.T) {
+	h, err := NewHandler(zap.NewNop(), memstore.NewStore(userstore.WithCursorKey([]byte("test-cursor-key"))))
+	if err != nil {
+		t.Fatalf("Failed to initialise handler")
+	}
+
+	send := func(method string, path string, body string) events.APIGatewayProxyResponse {
+		r, err := h.Handle(context.Background(), events.APIGatewayProxyRequest{
+			HTTPMethod:     method,
+			Path:           path,
+			Body:           body,
+			PathParameters: map[string]string{"proxy": path[1:]},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected handler error")
+		}
+		return r
+	}
+
+	r := send("POST", "/user", "{\"email\": \"abc@gmail.com\"}")
+	if r.StatusCode != 200 {
+		t.Fatalf("Expected user to be created, got %v", r.StatusCode)
+	}
+	var u models.User
+	if err := json.Unmarshal([]byte(r.Body), &u); err != nil {
+		t.Fatalf("Failed to unmarshal created user")
+	}
+
+	type test struct {
+		Name               string
+		Method             string
+		Path               string
+		Body               string
+		ExpectedStatusCode int
+		IsDeprecated       bool
+	}
+
+	tests := []test{
+		{Name: "Get user", Method: "GET", Path: "/user/" + u.UserID, ExpectedStatusCode: 200},
+		{Name: "Get user by RPC", Method: "POST", Path: "/user/get", Body: "{\"id\": \"" + u.UserID + "\"}", ExpectedStatusCode: 200, IsDeprecated: true},
+		{Name: "Get users in batch", Method: "POST", Path: "/user/get/batch", Body: "{\"ids\": [\"" + u.UserID + "\"]}", ExpectedStatusCode: 200},
+		{Name: "List users", Method: "GET", Path: "/user", ExpectedStatusCode: 200},
+		{Name: "Update user", Method: "PATCH", Path: "/user/" + u.UserID, Body: "{\"email\": \"new@gmail.com\"}", ExpectedStatusCode: 200},
+		{Name: "Delete user", Method: "DELETE", Path: "/user/" + u.UserID, ExpectedStatusCode: 200},
+		{Name: "Deleted user", Method: "GET", Path: "/user/" + u.UserID, ExpectedStatusCode: 404},
+		{Name: "Restore user", Method: "POST", Path: "/user/" + u.UserID + "/restore", ExpectedStatusCode: 200},
+		{Name: "Restored user", Method: "GET", Path: "/user/" + u.UserID, ExpectedStatusCode: 200},
+		{Name: "Unknown route", Method: "GET", Path: "/users", ExpectedStatusCode: 404},
+		{Name: "Unknown method", Method: "PUT", Path: "/user/" + u.UserID, ExpectedStatusCode: 405},
+		{Name: "Get on an RPC route", Method: "GET", Path: "/user/create", ExpectedStatusCode: 405},
+		{Name: "Delete on an RPC route", Method: "DELETE", Path: "/user/delete", ExpectedStatusCode: 405},
+		{Name: "Update on an RPC route", Method: "PATCH", Path: "/user/get", ExpectedStatusCode: 405},
+		{Name: "Get on a batch route", Method: "GET", Path: "/user/create/batch", ExpectedStatusCode: 405},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := send(tt.Method, tt.Path, tt.Body)
+			if r.StatusCode != tt.ExpectedStatusCode {
+				t.Fatalf("Expected Status Code to be %v, got %v", tt.ExpectedStatusCode, r.StatusCode)
+			}
+			if _, ok := r.Headers["Deprecation"]; ok != tt.IsDeprecated {
+				t.Fatalf("Expected deprecation to be %v", tt.IsDeprecated)
+			}
+		})
+	}
+}
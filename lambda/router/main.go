@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/benjaminkitson/bk-user-api/db/storeconfig"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/cache"
+	"github.com/benjaminkitson/bk-user-api/lambda/router/handler"
+	"github.com/benjaminkitson/bk-user-api/secrets"
+	"go.uber.org/zap"
+)
+
+/*
+The router serves the whole API from one Lambda, so it is initialised once per instance rather than on every
+invocation. That keeps the secret fetch and the store, including a SQL backend's connection pool, shared by every
+request the instance serves, along with the user cache if it is enabled.
+*/
+func main() {
+	logger, err := zap.NewProduction()
+	if err != nil {
+		fmt.Printf("Failed to initialise logger: %v", err)
+		logger = &zap.Logger{}
+	}
+	defer logger.Sync()
+
+	h, err := newHandler(context.Background(), logger)
+	if err != nil {
+		logger.Error("Failed to initialise router", zap.Error(err))
+		logger.Sync()
+		os.Exit(1)
+	}
+
+	lambda.Start(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		defer logger.Sync()
+		return h(ctx, request)
+	})
+}
+
+func newHandler(ctx context.Context, logger *zap.Logger) (func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error), error) {
+	sdkConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise SDK config: %w", err)
+	}
+
+	sc, err := secrets.NewSecretsClient(logger, secretsmanager.NewFromConfig(sdkConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	cursorKey, err := sc.GetSecret(os.Getenv("CURSOR_KEY_SECRET_NAME"))
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := storeconfig.Open(ctx, storeconfig.FromEnv(), sdkConfig, userstore.WithCursorKey([]byte(cursorKey)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise user store: %w", err)
+	}
+
+	// Reads and writes go through the same store here, so writes invalidate the users they change
+	userCache, err := cache.NewFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise user cache: %w", err)
+	}
+	if userCache != nil {
+		u = userCache.Wrap(u)
+	}
+
+	h, err := handler.NewHandler(logger, u)
+	if err != nil {
+		return nil, err
+	}
+	return h.Handle, nil
+}
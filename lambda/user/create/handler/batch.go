@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/models"
+	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// MaxBatchSize is the maximum number of users that can be created in a single batch request
+const MaxBatchSize = 100
+
+type batchRequest struct {
+	// Users is limited to MaxBatchSize users
+	Users []batchUser `json:"users" validate:"required,max=100"`
+}
+
+type batchUser struct {
+	// UserID is optional, but must be a UUID if given, as the ids the API generates are
+	UserID string `json:"userID" validate:"uuid"`
+	Email  string `json:"email" validate:"required,email"`
+}
+
+type batchResponse struct {
+	Results []models.BatchUserResult `json:"results"`
+}
+
+/*
+Creates users in bulk, for imports. Users may be given with their existing ids, which must be UUIDs like the
+ones the API generates, otherwise an id is generated, though a user whose id is already taken is never
+overwritten, and has a 409 as its result. The response contains a result for each user, in the order they were
+given.
+*/
+func (handler handler) handleBatch(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body, err := utils.DecodeBody[batchRequest](request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	records := make([]models.User, len(body.Users))
+	for i, u := range body.Users {
+		if u.UserID == "" {
+			u.UserID = uuid.New().String()
+		}
+		records[i] = models.User{UserID: u.UserID, Email: u.Email}
+	}
+
+	handler.logger.Info("attempting batch user creation", zap.Int("size", len(records)))
+	results, err := handler.userStore.BatchPut(ctx, records)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, errors.Wrap(err, "failed to create users")
+	}
+
+	res := batchResponse{Results: make([]models.BatchUserResult, len(results))}
+	for i, r := range results {
+		res.Results[i].Status = utils.ErrorStatus(r.Err)
+		if r.Err != nil {
+			handler.logger.Info("failed to create user in batch", zap.String("userID", records[i].UserID), zap.Error(r.Err))
+			res.Results[i].Error = r.Err.Error()
+			continue
+		}
+		u := r.User
+		res.Results[i].User = &u
+	}
+
+	return utils.JSON(res)
+}
@@ -2,13 +2,14 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
 	"github.com/benjaminkitson/bk-user-api/models"
 	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
@@ -18,9 +19,8 @@ type handler struct {
 }
 
 type handlerUserStore interface {
-	GetByID(ctx context.Context, id string) (models.User, error)
-	GetByEmail(ctx context.Context, email string) (models.User, error)
-	Put(ctx context.Context, record models.User) (models.User, error)
+	Create(ctx context.Context, record models.User) (models.User, error)
+	BatchPut(ctx context.Context, records []models.User) ([]userstore.BatchPutResult, error)
 }
 
 func NewHandler(logger *zap.Logger, u handlerUserStore) (handler, error) {
@@ -30,54 +30,39 @@ func NewHandler(logger *zap.Logger, u handlerUserStore) (handler, error) {
 	}, nil
 }
 
-// TODO: for some error cases, specific messaging would be ideal
-// TODO: probably incorporate some sort of request body validation prior to calling cognito or whichever auth provider
+type createRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
 
+// Handle creates a user with POST /user, responding with the user as created
 func (handler handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	bodyMap := make(map[string]string)
-
-	err := json.Unmarshal([]byte(request.Body), &bodyMap)
-	if err != nil {
-		handler.logger.Error("Error parsing request body", zap.Error(err))
-		return utils.RESPONSE_500, fmt.Errorf("error parsing request body")
+	if strings.HasSuffix(request.Path, "/batch") {
+		return utils.Wrap(handler.logger, handler.handleBatch)(ctx, request)
 	}
-
-	u, err := handler.createUser(ctx, bodyMap)
-	if err != nil {
-		handler.logger.Error("Failed to get create new user", zap.Error(err))
-		return utils.RESPONSE_500, nil
-	}
-	r, err := json.Marshal(u)
-	if err != nil {
-		return utils.RESPONSE_500, nil
+	if strings.HasSuffix(request.Path, "/create") {
+		// The deprecated POST /user/create, which is otherwise the same
+		return utils.Deprecated(utils.Wrap(handler.logger, handler.handle), utils.RPCRoutesDeprecatedAt)(ctx, request)
 	}
-	return utils.RESPONSE_200(string(r)), nil
-
+	return utils.Wrap(handler.logger, handler.handle)(ctx, request)
 }
 
-func (handler handler) createUser(ctx context.Context, requestBody map[string]string) (models.User, error) {
-	id := uuid.New().String()
-
-	user := models.User{
-		Email:  requestBody["email"],
-		UserID: id,
-	}
-
-	c, err := handler.userStore.GetByEmail(ctx, requestBody["email"])
+func (handler handler) handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body, err := utils.DecodeBody[createRequest](request)
 	if err != nil {
-		handler.logger.Error("error checking for existing user by email")
-		return models.User{}, err
+		return events.APIGatewayProxyResponse{}, err
 	}
 
-	if c.Email != "" {
-		handler.logger.Error("user with email already exists")
-		return models.User{}, fmt.Errorf("user with email already exists")
+	u, err := handler.userStore.Create(ctx, models.User{
+		Email:  body.Email,
+		UserID: uuid.New().String(),
+	})
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, errors.Wrap(err, "failed to create new user")
 	}
 
-	u, err := handler.userStore.Put(ctx, user)
+	response, err := utils.JSON(u)
 	if err != nil {
-		return models.User{}, err
+		return response, err
 	}
-
-	return u, err
+	return utils.WithETag(response, u.Version), nil
 }
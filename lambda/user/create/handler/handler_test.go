@@ -2,61 +2,169 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/memstore"
 	"github.com/benjaminkitson/bk-user-api/models"
 	"go.uber.org/zap"
 )
 
 type mockUserStore struct {
-	isError bool
+	isError    bool
+	emailTaken bool
+	throttled  bool
 }
 
-func (m mockUserStore) GetByID(ctx context.Context, id string) (user models.User, err error) {
-	return models.User{}, nil
-}
-
-// TODO: when a user already exists, GetByEmail error
-func (m mockUserStore) GetByEmail(ctx context.Context, email string) (user models.User, err error) {
-	return models.User{}, nil
+func (m mockUserStore) Create(ctx context.Context, record models.User) (models.User, error) {
+	if m.isError {
+		return models.User{}, fmt.Errorf("UserStore create error!")
+	}
+	if m.emailTaken {
+		return models.User{}, userstore.ErrEmailTaken
+	}
+	if m.throttled {
+		return models.User{}, userstore.ErrThrottled
+	}
+	return record, nil
 }
 
-func (m mockUserStore) Put(ctx context.Context, record models.User) (models.User, error) {
+func (m mockUserStore) BatchPut(ctx context.Context, records []models.User) ([]userstore.BatchPutResult, error) {
 	if m.isError {
-		return models.User{}, fmt.Errorf("UserStore put error!")
+		return nil, fmt.Errorf("UserStore batch put error!")
 	}
-	return record, nil
+	results := make([]userstore.BatchPutResult, len(records))
+	for i, record := range records {
+		if record.Email == "taken@gmail.com" {
+			results[i].Err = userstore.ErrEmailTaken
+			continue
+		}
+		results[i].User = record
+	}
+	return results, nil
 }
 
+// existingID is the id batch users are given where the test needs one
+const existingID = "0b9f8a8e-6a8c-4d4e-9d1c-3b3f5f0a2c11"
+
 /*
 Tests the basic workings of the handler
 */
 func TestHandler(t *testing.T) {
 	type test struct {
-		Name                   string
-		StoreError             bool
-		RequestBody            string
-		RequestPath            string
-		ExpectedStatusCode     int
-		IsHandlerErrorExpected bool
+		Name               string
+		StoreError         bool
+		EmailTaken         bool
+		Throttled          bool
+		RequestBody        string
+		RequestPath        string
+		ExpectedStatusCode int
 	}
 
 	tests := []test{
 		{
 			Name:               "Successfully create user",
 			RequestBody:        "{\"email\": \"abc@gmail.com\"}",
+			RequestPath:        "/user",
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "Failed to create user",
+			RequestBody:        "{\"email\": \"abc@gmail.com\"}",
+			RequestPath:        "/user",
+			ExpectedStatusCode: 500,
+			StoreError:         true,
+		},
+		{
+			Name:               "Successfully create users in batch",
+			RequestBody:        "{\"users\": [{\"email\": \"abc@gmail.com\"}, {\"userID\": \"" + existingID + "\", \"email\": \"taken@gmail.com\"}]}",
+			RequestPath:        "/user/create/batch",
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "Batch user without email",
+			RequestBody:        "{\"users\": [{\"userID\": \"" + existingID + "\"}]}",
+			RequestPath:        "/user/create/batch",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Empty batch",
+			RequestBody:        "{\"users\": []}",
+			RequestPath:        "/user/create/batch",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Failed to create users in batch",
+			RequestBody:        "{\"users\": [{\"email\": \"abc@gmail.com\"}]}",
+			RequestPath:        "/user/create/batch",
+			ExpectedStatusCode: 500,
+			StoreError:         true,
+		},
+		{
+			Name:               "Successfully create user by RPC",
+			RequestBody:        "{\"email\": \"abc@gmail.com\"}",
 			RequestPath:        "/user/create",
 			ExpectedStatusCode: 200,
 		},
 		{
-			Name:                   "Failed to create user",
-			RequestBody:            "{\"email\": \"abc@gmail.com\"}",
-			RequestPath:            "/user/create",
-			ExpectedStatusCode:     500,
-			StoreError:             true,
-			IsHandlerErrorExpected: true,
+			Name:               "Invalid email",
+			RequestBody:        "{\"email\": \"abc\"}",
+			RequestPath:        "/user",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Unknown field",
+			RequestBody:        "{\"email\": \"abc@gmail.com\", \"emial\": \"abc@gmail.com\"}",
+			RequestPath:        "/user",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Malformed body",
+			RequestBody:        "{\"email\": ",
+			RequestPath:        "/user",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Batch user id that isn't a UUID",
+			RequestBody:        "{\"users\": [{\"userID\": \"../12345\", \"email\": \"abc@gmail.com\"}]}",
+			RequestPath:        "/user/create/batch",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Batch user id with control characters",
+			RequestBody:        "{\"users\": [{\"userID\": \"0b9f8a8e-6a8c-4d4e-9d1c-3b3f5f0a2c1\\n\", \"email\": \"abc@gmail.com\"}]}",
+			RequestPath:        "/user/create/batch",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Batch user id that's too long",
+			RequestBody:        "{\"users\": [{\"userID\": \"" + existingID + strings.Repeat("1", 1000) + "\", \"email\": \"abc@gmail.com\"}]}",
+			RequestPath:        "/user/create/batch",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Batch too large",
+			RequestBody:        "{\"users\": [" + strings.Repeat("{\"email\": \"abc@gmail.com\"},", MaxBatchSize) + "{\"email\": \"abc@gmail.com\"}]}",
+			RequestPath:        "/user/create/batch",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Email already in use",
+			RequestBody:        "{\"email\": \"abc@gmail.com\"}",
+			RequestPath:        "/user",
+			ExpectedStatusCode: 409,
+			EmailTaken:         true,
+		},
+		{
+			Name:               "Store out of capacity",
+			RequestBody:        "{\"email\": \"abc@gmail.com\"}",
+			RequestPath:        "/user",
+			ExpectedStatusCode: 503,
+			Throttled:          true,
 		},
 	}
 
@@ -68,7 +176,9 @@ func TestHandler(t *testing.T) {
 			}
 
 			u := mockUserStore{
-				isError: tt.StoreError,
+				isError:    tt.StoreError,
+				emailTaken: tt.EmailTaken,
+				throttled:  tt.Throttled,
 			}
 
 			h, err := NewHandler(l, u)
@@ -77,19 +187,62 @@ func TestHandler(t *testing.T) {
 			}
 
 			req := events.APIGatewayProxyRequest{
-				// This test should probably fail if the body isn't the correct format?
 				Body: tt.RequestBody,
 				Path: tt.RequestPath,
 			}
 
 			r, err := h.Handle(context.Background(), req)
-			if err != nil && !tt.IsHandlerErrorExpected {
+			if err != nil {
 				t.Fatalf("Unexpected handler error")
 			}
 
 			if r.StatusCode != tt.ExpectedStatusCode {
 				t.Fatalf("Expected Status Code to be %v", tt.ExpectedStatusCode)
 			}
+			if r.StatusCode == 503 && r.Headers["Retry-After"] == "" {
+				t.Fatalf("Expected a Retry-After header")
+			}
+			if _, ok := r.Headers["Deprecation"]; ok != (tt.RequestPath == "/user/create") {
+				t.Fatalf("Expected only the RPC route to be deprecated")
+			}
 		})
 	}
 }
+
+/*
+Tests that users given to the batch route with the id of an existing user don't overwrite it
+*/
+func TestHandlerBatchExistingUser(t *testing.T) {
+	store := memstore.NewStore()
+	existing, err := store.Create(context.Background(), models.User{UserID: existingID, Email: "abc@gmail.com"})
+	if err != nil {
+		t.Fatalf("Failed to create user")
+	}
+
+	h, err := NewHandler(zap.NewNop(), store)
+	if err != nil {
+		t.Fatalf("Failed to initialise handler")
+	}
+
+	r, err := h.Handle(context.Background(), events.APIGatewayProxyRequest{
+		Body: "{\"users\": [{\"userID\": \"" + existingID + "\", \"email\": \"def@gmail.com\"}]}",
+		Path: "/user/create/batch",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected handler error")
+	}
+
+	var res batchResponse
+	err = json.Unmarshal([]byte(r.Body), &res)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response")
+	}
+	if res.Results[0].Status != 409 {
+		t.Fatalf("Expected the existing user to be reported with a 409, got %v", res.Results[0].Status)
+	}
+
+	u, err := store.GetByID(context.Background(), existingID)
+	if err != nil || u != existing {
+		t.Fatalf("Expected the existing user to be left as it was")
+	}
+}
@@ -2,11 +2,11 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
 	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
@@ -16,7 +16,7 @@ type handler struct {
 }
 
 type handlerUserStore interface {
-	Delete(ctx context.Context, id string) (string, error)
+	Delete(ctx context.Context, id string, expectedVersion int64) (string, error)
 }
 
 func NewHandler(logger *zap.Logger, u handlerUserStore) (handler, error) {
@@ -26,33 +26,49 @@ func NewHandler(logger *zap.Logger, u handlerUserStore) (handler, error) {
 	}, nil
 }
 
-// TODO: for some error cases, specific messaging would be ideal
-// TODO: probably incorporate some sort of request body validation prior to calling cognito or whichever auth provider
+// deleteRequest is the body of the deprecated POST /user/delete route, which takes the id in the body
+type deleteRequest struct {
+	ID string `json:"id" validate:"required"`
+}
 
+// Handle deletes the user given by the id path parameter, responding with the deleted user's id
 func (handler handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	bodyMap := make(map[string]string)
+	if _, ok := request.PathParameters["id"]; !ok {
+		return utils.Deprecated(utils.Wrap(handler.logger, handler.handleRPC), utils.RPCRoutesDeprecatedAt)(ctx, request)
+	}
+	return utils.Wrap(handler.logger, handler.handle)(ctx, request)
+}
 
-	err := json.Unmarshal([]byte(request.Body), &bodyMap)
+func (handler handler) handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return handler.deleteUser(ctx, request, request.PathParameters["id"])
+}
+
+func (handler handler) handleRPC(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body, err := utils.DecodeBody[deleteRequest](request)
 	if err != nil {
-		handler.logger.Error("Error parsing request body", zap.Error(err))
-		return utils.RESPONSE_500, fmt.Errorf("error parsing request body")
+		return events.APIGatewayProxyResponse{}, err
 	}
+	return handler.deleteUser(ctx, request, body.ID)
+}
 
-	handler.logger.Info("attempting user deletion", zap.String("userID", bodyMap["id"]))
-	id, err := handler.userStore.Delete(ctx, bodyMap["id"])
+func (handler handler) deleteUser(ctx context.Context, request events.APIGatewayProxyRequest, id string) (events.APIGatewayProxyResponse, error) {
+	version, err := utils.IfMatchVersion(request)
 	if err != nil {
-		handler.logger.Error("error deleting user", zap.String("userID", bodyMap["id"]), zap.Error(err))
-		return utils.RESPONSE_500, nil
+		return events.APIGatewayProxyResponse{}, err
 	}
-	handler.logger.Info("successfully deleted user from db", zap.String("userID", bodyMap["id"]))
 
-	s := map[string]string{
-		"id": id,
+	handler.logger.Info("attempting user deletion", zap.String("userID", id))
+	deletedID, err := handler.userStore.Delete(ctx, id, version)
+	// A conflict is only a failed precondition if one was given, otherwise it's a plain 409
+	if errors.Is(err, userstore.ErrConflict) && version != 0 {
+		return events.APIGatewayProxyResponse{}, utils.PreconditionFailed(err)
 	}
-	r, err := json.Marshal(s)
 	if err != nil {
-		return utils.RESPONSE_500, nil
+		return events.APIGatewayProxyResponse{}, errors.Wrapf(err, "error deleting user %s", id)
 	}
+	handler.logger.Info("successfully deleted user from db", zap.String("userID", id))
 
-	return utils.RESPONSE_200(string(r)), nil
+	return utils.JSON(map[string]string{
+		"id": deletedID,
+	})
 }
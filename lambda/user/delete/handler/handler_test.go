@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
 	"go.uber.org/zap"
 )
 
@@ -13,10 +14,19 @@ type mockUserStore struct {
 	isError bool
 }
 
-func (m mockUserStore) Delete(ctx context.Context, id string) (string, error) {
+func (m mockUserStore) Delete(ctx context.Context, id string, expectedVersion int64) (string, error) {
 	if m.isError {
 		return "", fmt.Errorf("User store error")
 	}
+	if id == "missing" {
+		return "", userstore.ErrNotFound
+	}
+	if id == "changing" {
+		return "", userstore.ErrConflict
+	}
+	if expectedVersion != 0 && expectedVersion != 1 {
+		return "", userstore.ErrConflict
+	}
 	return id, nil
 }
 
@@ -25,26 +35,63 @@ Tests the basic workings of the handler
 */
 func TestHandler(t *testing.T) {
 	type test struct {
-		Name                   string
-		StoreError             bool
-		RequestBody            string
-		RequestPath            string
-		ExpectedStatusCode     int
-		IsHandlerErrorExpected bool
+		Name               string
+		StoreError         bool
+		PathID             string
+		RequestBody        string
+		RequestHeaders     map[string]string
+		ExpectedStatusCode int
 	}
 
 	tests := []test{
 		{
 			Name:               "Successfully delete user",
+			PathID:             "12345",
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "Failed to delete user",
+			PathID:             "23456",
+			ExpectedStatusCode: 500,
+			StoreError:         true,
+		},
+		{
+			Name:               "Successfully delete user at expected version",
+			PathID:             "12345",
+			RequestHeaders:     map[string]string{"If-Match": "\"1\""},
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "User has been modified",
+			PathID:             "12345",
+			RequestHeaders:     map[string]string{"If-Match": "\"2\""},
+			ExpectedStatusCode: 412,
+		},
+		{
+			Name:               "User changed without a precondition",
+			PathID:             "changing",
+			ExpectedStatusCode: 409,
+		},
+		{
+			Name:               "Invalid If-Match header",
+			PathID:             "12345",
+			RequestHeaders:     map[string]string{"If-Match": "abc"},
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "User does not exist",
+			PathID:             "missing",
+			ExpectedStatusCode: 404,
+		},
+		{
+			Name:               "Successfully delete user by RPC",
 			RequestBody:        "{\"id\": \"12345\"}",
 			ExpectedStatusCode: 200,
 		},
 		{
-			Name:                   "Failed to delete user",
-			RequestBody:            "{\"id\": \"23456\"}",
-			ExpectedStatusCode:     500,
-			StoreError:             true,
-			IsHandlerErrorExpected: true,
+			Name:               "Missing id by RPC",
+			RequestBody:        "{}",
+			ExpectedStatusCode: 400,
 		},
 	}
 
@@ -65,19 +112,27 @@ func TestHandler(t *testing.T) {
 			}
 
 			req := events.APIGatewayProxyRequest{
-				// This test should probably fail if the body isn't the correct format?
-				Body: tt.RequestBody,
-				Path: tt.RequestPath,
+				Body:    tt.RequestBody,
+				Path:    "/user/delete",
+				Headers: tt.RequestHeaders,
+			}
+			if tt.PathID != "" {
+				req.Path = "/user/" + tt.PathID
+				req.PathParameters = map[string]string{"id": tt.PathID}
 			}
 
 			r, err := h.Handle(context.Background(), req)
-			if err != nil && !tt.IsHandlerErrorExpected {
+			if err != nil {
 				t.Fatalf("Unexpected handler error")
 			}
 
 			if r.StatusCode != tt.ExpectedStatusCode {
 				t.Fatalf("Expected Status Code to be %v, got %v", tt.ExpectedStatusCode, r.StatusCode)
 			}
+
+			if _, ok := r.Headers["Deprecation"]; ok != (tt.PathID == "") {
+				t.Fatalf("Expected only the RPC route to be deprecated")
+			}
 		})
 	}
 }
@@ -7,8 +7,7 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/storeconfig"
 	"github.com/benjaminkitson/bk-user-api/lambda/user/delete/handler"
 	"go.uber.org/zap"
 )
@@ -28,11 +27,11 @@ func main() {
 			return events.APIGatewayProxyResponse{}, err
 		}
 
-		d := dynamodb.NewFromConfig(sdkConfig)
-
-		tableName := "userTable"
-
-		u := userstore.NewUserStore(d, tableName)
+		u, err := storeconfig.Open(ctx, storeconfig.FromEnv(), sdkConfig)
+		if err != nil {
+			logger.Error("Failed to initialise user store", zap.Error(err))
+			return events.APIGatewayProxyResponse{}, err
+		}
 
 		h, err := handler.NewHandler(logger, u)
 		if err != nil {
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/userevents"
+	"go.uber.org/zap"
+)
+
+type handler struct {
+	logger    *zap.Logger
+	decoder   userDecoder
+	publisher userevents.Publisher
+}
+
+// userDecoder reads users from their stored items, which may have encrypted fields
+type userDecoder interface {
+	UnmarshalUser(ctx context.Context, item map[string]types.AttributeValue) (models.User, error)
+}
+
+func NewHandler(logger *zap.Logger, d userDecoder, p userevents.Publisher) (handler, error) {
+	return handler{
+		logger:    logger,
+		decoder:   d,
+		publisher: p,
+	}, nil
+}
+
+/*
+Handle publishes an event for each change to a user in a batch of records from the user table's stream. Records
+for other items, such as email reservations and history, are skipped.
+
+Records are published in order, so that each user's events are delivered in the order they happened. On the
+first record that can't be published the rest of the batch is left unprocessed, and that record is reported as
+a batch item failure so that Lambda retries the batch from it.
+*/
+func (handler handler) Handle(ctx context.Context, request events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+	response := events.DynamoDBEventResponse{BatchItemFailures: []events.DynamoDBBatchItemFailure{}}
+
+	for _, record := range request.Records {
+		event, ok, err := handler.toEvent(ctx, record)
+		if err == nil && ok {
+			err = handler.publisher.Publish(ctx, event)
+		}
+		if err != nil {
+			handler.logger.Error("failed to publish user event",
+				zap.String("eventID", record.EventID),
+				zap.String("sequenceNumber", record.Change.SequenceNumber),
+				zap.Error(err),
+			)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.DynamoDBBatchItemFailure{
+				ItemIdentifier: record.Change.SequenceNumber,
+			})
+			return response, nil
+		}
+		if ok {
+			handler.logger.Info("published user event", zap.String("eventID", event.ID), zap.String("type", string(event.Type)), zap.String("userID", event.UserID))
+		}
+	}
+
+	return response, nil
+}
+
+// toEvent converts a stream record to the event it represents, returning false if it isn't of interest
+func (handler handler) toEvent(ctx context.Context, record events.DynamoDBEventRecord) (userevents.Event, bool, error) {
+	if sk, ok := record.Change.Keys[schema.SortKey]; !ok || sk.DataType() != events.DataTypeString || sk.String() != schema.UserSK {
+		return userevents.Event{}, false, nil
+	}
+
+	var before, after *models.User
+	if len(record.Change.OldImage) > 0 {
+		u, err := unmarshalUser(ctx, handler.decoder, record.Change.OldImage)
+		if err != nil {
+			return userevents.Event{}, false, err
+		}
+		before = &u
+	}
+	if len(record.Change.NewImage) > 0 {
+		u, err := unmarshalUser(ctx, handler.decoder, record.Change.NewImage)
+		if err != nil {
+			return userevents.Event{}, false, err
+		}
+		after = &u
+	}
+
+	at := record.Change.ApproximateCreationDateTime.Time
+	switch events.DynamoDBOperationType(record.EventName) {
+	case events.DynamoDBOperationTypeInsert:
+		if after == nil {
+			break
+		}
+		return userevents.New(record.EventID, userevents.UserCreated, at, *after, nil), true, nil
+
+	case events.DynamoDBOperationTypeModify:
+		if before == nil || after == nil || reflect.DeepEqual(before, after) {
+			break
+		}
+		// Soft deletion is what deletes a user, as far as anyone else is concerned
+		if before.DeletedAt == nil && after.DeletedAt != nil {
+			return userevents.New(record.EventID, userevents.UserDeleted, at, *after, before), true, nil
+		}
+		return userevents.New(record.EventID, userevents.UserUpdated, at, *after, before), true, nil
+
+	case events.DynamoDBOperationTypeRemove:
+		// Users purged once their restore window has passed were announced as deleted when they were soft-deleted
+		if before == nil || before.DeletedAt != nil {
+			break
+		}
+		return userevents.New(record.EventID, userevents.UserDeleted, at, *before, nil), true, nil
+	}
+
+	return userevents.Event{}, false, nil
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/schema"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/userevents"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type mockPublisher struct {
+	userevents.MemoryPublisher
+	// failOn is the id of an event that fails to publish
+	failOn string
+}
+
+func (m *mockPublisher) Publish(ctx context.Context, event userevents.Event) error {
+	if event.ID == m.failOn {
+		return fmt.Errorf("Publisher error!")
+	}
+	return m.MemoryPublisher.Publish(ctx, event)
+}
+
+func image(sk string, email string, version int, deletedAt string) map[string]events.DynamoDBAttributeValue {
+	i := map[string]events.DynamoDBAttributeValue{
+		schema.PartitionKey: events.NewStringAttribute("user/12345"),
+		schema.SortKey:      events.NewStringAttribute(sk),
+		"userID":            events.NewStringAttribute("12345"),
+		"email":             events.NewStringAttribute(email),
+		"version":           events.NewNumberAttribute(fmt.Sprint(version)),
+		"createdAt":         events.NewStringAttribute("2024-10-01T12:00:00Z"),
+		"updatedAt":         events.NewStringAttribute("2024-10-01T12:00:00Z"),
+	}
+	if deletedAt != "" {
+		i["deletedAt"] = events.NewStringAttribute(deletedAt)
+	}
+	return i
+}
+
+func record(id string, name events.DynamoDBOperationType, oldImage, newImage map[string]events.DynamoDBAttributeValue) events.DynamoDBEventRecord {
+	keys := map[string]events.DynamoDBAttributeValue{}
+	for _, i := range []map[string]events.DynamoDBAttributeValue{oldImage, newImage} {
+		for _, k := range []string{schema.PartitionKey, schema.SortKey} {
+			if v, ok := i[k]; ok {
+				keys[k] = v
+			}
+		}
+	}
+	return events.DynamoDBEventRecord{
+		EventID:   id,
+		EventName: string(name),
+		Change: events.DynamoDBStreamRecord{
+			ApproximateCreationDateTime: events.SecondsEpochTime{Time: time.Unix(1727784000, 0)},
+			Keys:                        keys,
+			OldImage:                    oldImage,
+			NewImage:                    newImage,
+			SequenceNumber:              "seq-" + id,
+		},
+	}
+}
+
+/*
+Tests the basic workings of the handler
+*/
+func TestHandler(t *testing.T) {
+	deletedAt := "2024-10-02T12:00:00Z"
+	records := []events.DynamoDBEventRecord{
+		record("1", events.DynamoDBOperationTypeInsert, nil, image(schema.UserSK, "abc@gmail.com", 1, "")),
+		// History items share the user's partition
+		record("2", events.DynamoDBOperationTypeInsert, nil, image("history/2024-10-01T12:00:00.000000000Z/1", "abc@gmail.com", 1, "")),
+		record("3", events.DynamoDBOperationTypeModify, image(schema.UserSK, "abc@gmail.com", 1, ""), image(schema.UserSK, "def@gmail.com", 2, "")),
+		record("4", events.DynamoDBOperationTypeModify, image(schema.UserSK, "def@gmail.com", 2, ""), image(schema.UserSK, "def@gmail.com", 3, deletedAt)),
+		// Purged by TTL after the soft delete
+		record("5", events.DynamoDBOperationTypeRemove, image(schema.UserSK, "def@gmail.com", 3, deletedAt), nil),
+		record("6", events.DynamoDBOperationTypeRemove, image(schema.UserSK, "ghi@gmail.com", 1, ""), nil),
+	}
+
+	type test struct {
+		Name             string
+		FailOn           string
+		ExpectedEvents   []userevents.Type
+		ExpectedFailures []string
+	}
+
+	tests := []test{
+		{
+			Name:             "Successfully publish events",
+			ExpectedEvents:   []userevents.Type{userevents.UserCreated, userevents.UserUpdated, userevents.UserDeleted, userevents.UserDeleted},
+			ExpectedFailures: []string{},
+		},
+		{
+			Name:             "Stops at the first failure",
+			FailOn:           "3",
+			ExpectedEvents:   []userevents.Type{userevents.UserCreated},
+			ExpectedFailures: []string{"seq-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			l, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatalf("Failed to initialise dev logger")
+			}
+
+			p := &mockPublisher{failOn: tt.FailOn}
+			h, err := NewHandler(l, userstore.NewUserStore(nil, "userTable"), p)
+			if err != nil {
+				t.Fatalf("Failed to initialise handler")
+			}
+
+			r, err := h.Handle(context.Background(), events.DynamoDBEvent{Records: records})
+			require.NoError(t, err)
+
+			failures := []string{}
+			for _, f := range r.BatchItemFailures {
+				failures = append(failures, f.ItemIdentifier)
+			}
+			assert.Equal(t, tt.ExpectedFailures, failures)
+
+			types := []userevents.Type{}
+			for _, e := range p.Events() {
+				types = append(types, e.Type)
+			}
+			assert.Equal(t, tt.ExpectedEvents, types)
+		})
+	}
+}
+
+func TestToEvent(t *testing.T) {
+	h := handler{decoder: userstore.NewUserStore(nil, "userTable")}
+	before := image(schema.UserSK, "abc@gmail.com", 1, "")
+	after := image(schema.UserSK, "def@gmail.com", 2, "")
+
+	e, ok, err := h.toEvent(context.Background(), record("1", events.DynamoDBOperationTypeModify, before, after))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "1", e.ID)
+	assert.Equal(t, userevents.UserUpdated, e.Type)
+	assert.Equal(t, userevents.SchemaVersion, e.SchemaVersion)
+	assert.Equal(t, "12345", e.UserID)
+	assert.Equal(t, "def@gmail.com", e.User.Email)
+	assert.Equal(t, int64(2), e.User.Version)
+	require.NotNil(t, e.Previous)
+	assert.Equal(t, "abc@gmail.com", e.Previous.Email)
+	assert.Equal(t, time.Unix(1727784000, 0).UTC(), e.Time)
+
+	// Rewrites that don't change the user aren't announced
+	_, ok, err = h.toEvent(context.Background(), record("2", events.DynamoDBOperationTypeModify, before, before))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Reservations aren't users
+	_, ok, err = h.toEvent(context.Background(), record("3", events.DynamoDBOperationTypeInsert, nil, map[string]events.DynamoDBAttributeValue{
+		schema.PartitionKey: events.NewStringAttribute("email/abc@gmail.com"),
+		schema.SortKey:      events.NewStringAttribute(schema.EmailSK),
+		"userID":            events.NewStringAttribute("12345"),
+	}))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/pkg/errors"
+)
+
+// unmarshalUser converts a stream image of a user item to a user
+func unmarshalUser(ctx context.Context, decoder userDecoder, image map[string]events.DynamoDBAttributeValue) (models.User, error) {
+	item, err := toItem(image)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user, err := decoder.UnmarshalUser(ctx, item)
+	if err != nil {
+		return models.User{}, errors.Wrap(err, "an error ocurred unmarshaling the user")
+	}
+	return user, nil
+}
+
+// toItem converts a stream image, which uses the Lambda event types, to the SDK's attribute values
+func toItem(image map[string]events.DynamoDBAttributeValue) (map[string]types.AttributeValue, error) {
+	item := make(map[string]types.AttributeValue, len(image))
+	for name, v := range image {
+		av, err := toAttributeValue(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid attribute %s", name)
+		}
+		item[name] = av
+	}
+	return item, nil
+}
+
+func toAttributeValue(v events.DynamoDBAttributeValue) (types.AttributeValue, error) {
+	switch v.DataType() {
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: v.String()}, nil
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: v.Number()}, nil
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: v.Binary()}, nil
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: v.Boolean()}, nil
+	case events.DataTypeNull:
+		return &types.AttributeValueMemberNULL{Value: true}, nil
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: v.StringSet()}, nil
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: v.NumberSet()}, nil
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: v.BinarySet()}, nil
+	case events.DataTypeList:
+		list := []types.AttributeValue{}
+		for _, e := range v.List() {
+			av, err := toAttributeValue(e)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, av)
+		}
+		return &types.AttributeValueMemberL{Value: list}, nil
+	case events.DataTypeMap:
+		m, err := toItem(v.Map())
+		if err != nil {
+			return nil, err
+		}
+		return &types.AttributeValueMemberM{Value: m}, nil
+	}
+	return nil, errors.Errorf("unsupported attribute type %v", v.DataType())
+}
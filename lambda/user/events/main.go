@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/benjaminkitson/bk-user-api/db/storeconfig"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/lambda/user/events/handler"
+	"github.com/benjaminkitson/bk-user-api/userevents"
+	"go.uber.org/zap"
+)
+
+func main() {
+	lambda.Start(func(ctx context.Context, request events.DynamoDBEvent) (events.DynamoDBEventResponse, error) {
+		logger, err := zap.NewProduction()
+		if err != nil {
+			fmt.Printf("Failed to initialise logger: %v", err)
+			logger = &zap.Logger{}
+		}
+		defer logger.Sync()
+
+		sdkConfig, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			logger.Error("Failed to intialise SDK config", zap.Error(err))
+			return events.DynamoDBEventResponse{}, err
+		}
+
+		p := userevents.NewEventBridgePublisher(eventbridge.NewFromConfig(sdkConfig), os.Getenv(userevents.EventBusEnv))
+
+		// Only the store's decoding is used, to decrypt the users in the stream's images
+		c := storeconfig.FromEnv()
+		opts, err := storeconfig.StoreOptions(ctx, c, sdkConfig)
+		if err != nil {
+			logger.Error("Failed to initialise user store", zap.Error(err))
+			return events.DynamoDBEventResponse{}, err
+		}
+		u := userstore.NewUserStore(dynamodb.NewFromConfig(sdkConfig), c.TableName, opts...)
+
+		h, err := handler.NewHandler(logger, u, p)
+		if err != nil {
+			return events.DynamoDBEventResponse{}, err
+		}
+
+		return h.Handle(ctx, request)
+	})
+}
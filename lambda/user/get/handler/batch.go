@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/models"
+	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+	"github.com/pkg/errors"
+)
+
+// MaxBatchSize is the maximum number of users that can be fetched in a single batch request
+const MaxBatchSize = 100
+
+type batchRequest struct {
+	// IDs is limited to MaxBatchSize ids
+	IDs []string `json:"ids" validate:"required,max=100"`
+}
+
+type batchResponse struct {
+	Results []models.BatchUserResult `json:"results"`
+}
+
+// Fetches users in bulk. The response contains a result for each id, in the order they were given.
+func (handler handler) handleBatch(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body, err := utils.DecodeBody[batchRequest](request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	results, err := handler.userStore.BatchGetByIDs(ctx, body.IDs, readOptions(request)...)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, errors.Wrap(err, "error retrieving users")
+	}
+
+	res := batchResponse{Results: make([]models.BatchUserResult, len(results))}
+	for i, r := range results {
+		res.Results[i].Status = utils.ErrorStatus(r.Err)
+		if r.Err != nil {
+			res.Results[i].Error = r.Err.Error()
+			continue
+		}
+		u := r.User
+		res.Results[i].User = &u
+	}
+
+	return utils.JSON(res)
+}
@@ -2,12 +2,13 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
 	"github.com/benjaminkitson/bk-user-api/models"
 	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
@@ -17,8 +18,9 @@ type handler struct {
 }
 
 type handlerUserStore interface {
-	GetByID(ctx context.Context, id string) (models.User, error)
-	GetByEmail(ctx context.Context, email string) (models.User, error)
+	GetByID(ctx context.Context, id string, opts ...userstore.ReadOption) (models.User, error)
+	GetByEmail(ctx context.Context, email string, opts ...userstore.ReadOption) (models.User, error)
+	BatchGetByIDs(ctx context.Context, ids []string, opts ...userstore.ReadOption) ([]userstore.BatchGetResult, error)
 }
 
 func NewHandler(logger *zap.Logger, u handlerUserStore) (handler, error) {
@@ -28,28 +30,51 @@ func NewHandler(logger *zap.Logger, u handlerUserStore) (handler, error) {
 	}, nil
 }
 
-// TODO: for some error cases, specific messaging would be ideal
-// TODO: probably incorporate some sort of request body validation prior to calling cognito or whichever auth provider
+// getRequest is the body of the deprecated POST /user/get route, which takes the id in the body
+type getRequest struct {
+	ID string `json:"id" validate:"required"`
+}
 
+// Handle fetches a user by id, given by the id path parameter of GET /user/{id}
 func (handler handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	bodyMap := make(map[string]string)
+	if strings.HasSuffix(request.Path, "/batch") {
+		return utils.Wrap(handler.logger, handler.handleBatch)(ctx, request)
+	}
+	if _, ok := request.PathParameters["id"]; !ok {
+		return utils.Deprecated(utils.Wrap(handler.logger, handler.handleRPC), utils.RPCRoutesDeprecatedAt)(ctx, request)
+	}
+	return utils.Wrap(handler.logger, handler.handle)(ctx, request)
+}
+
+func (handler handler) handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return handler.getUser(ctx, request, request.PathParameters["id"])
+}
 
-	err := json.Unmarshal([]byte(request.Body), &bodyMap)
+func (handler handler) handleRPC(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body, err := utils.DecodeBody[getRequest](request)
 	if err != nil {
-		handler.logger.Error("Error parsing request body", zap.Error(err))
-		return utils.RESPONSE_500, fmt.Errorf("error parsing request body")
+		return events.APIGatewayProxyResponse{}, err
 	}
+	return handler.getUser(ctx, request, body.ID)
+}
 
-	u, err := handler.userStore.GetByID(ctx, bodyMap["id"])
+func (handler handler) getUser(ctx context.Context, request events.APIGatewayProxyRequest, id string) (events.APIGatewayProxyResponse, error) {
+	u, err := handler.userStore.GetByID(ctx, id, readOptions(request)...)
 	if err != nil {
-		handler.logger.Error("error retrieving user", zap.String("userID", bodyMap["id"]), zap.Error(err))
-		return utils.RESPONSE_500, nil
+		return events.APIGatewayProxyResponse{}, errors.Wrapf(err, "error retrieving user %s", id)
 	}
 
-	r, err := json.Marshal(u)
+	response, err := utils.JSON(u)
 	if err != nil {
-		handler.logger.Error("Error marshalling response body", zap.Error(err))
-		return utils.RESPONSE_500, nil
+		return response, err
+	}
+	return utils.WithETag(response, u.Version), nil
+}
+
+// readOptions lets callers that can't tolerate a stale user bypass any cache with Cache-Control: no-cache
+func readOptions(request events.APIGatewayProxyRequest) []userstore.ReadOption {
+	if utils.NoCache(request) {
+		return []userstore.ReadOption{userstore.ConsistentRead()}
 	}
-	return utils.RESPONSE_200(string(r)), nil
+	return nil
 }
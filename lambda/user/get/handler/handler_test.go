@@ -3,90 +3,202 @@ package handler
 import (
 	"context"
 	"fmt"
+	"testing"
 
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/memstore"
 	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"go.uber.org/zap"
 )
 
 type mockUserStore struct {
 	isError bool
 }
 
-func (m mockUserStore) GetByID(ctx context.Context, id string) (user models.User, err error) {
-	return models.User{}, nil
+func (m mockUserStore) GetByID(ctx context.Context, id string, opts ...userstore.ReadOption) (user models.User, err error) {
+	if m.isError {
+		return models.User{}, fmt.Errorf("UserStore get error!")
+	}
+	if id == "missing" {
+		return models.User{}, userstore.ErrNotFound
+	}
+	return models.User{UserID: id, Email: "abc@gmail.com", Version: 3}, nil
 }
 
-// TODO: when a user already exists, GetByEmail error
-func (m mockUserStore) GetByEmail(ctx context.Context, email string) (user models.User, err error) {
+func (m mockUserStore) GetByEmail(ctx context.Context, email string, opts ...userstore.ReadOption) (user models.User, err error) {
 	return models.User{}, nil
 }
 
-func (m mockUserStore) Put(ctx context.Context, record models.User, id string) (models.User, error) {
+func (m mockUserStore) BatchGetByIDs(ctx context.Context, ids []string, opts ...userstore.ReadOption) ([]userstore.BatchGetResult, error) {
 	if m.isError {
-		return models.User{}, fmt.Errorf("UserStore put error!")
+		return nil, fmt.Errorf("UserStore batch get error!")
 	}
-	return record, nil
+	results := make([]userstore.BatchGetResult, len(ids))
+	for i, id := range ids {
+		results[i].User, results[i].Err = m.GetByID(ctx, id)
+	}
+	return results, nil
 }
 
 /*
 Tests the basic workings of the handler
 */
-// func TestHandler(t *testing.T) {
-// 	type test struct {
-// 		Name                   string
-// 		StoreError             bool
-// 		RequestBody            string
-// 		RequestPath            string
-// 		ExpectedStatusCode     int
-// 		IsHandlerErrorExpected bool
-// 	}
-
-// 	tests := []test{
-// 		{
-// 			Name:               "Successfully create user",
-// 			RequestBody:        "{\"email\": \"abc@gmail.com\"}",
-// 			RequestPath:        "/user/create",
-// 			ExpectedStatusCode: 200,
-// 		},
-// 		{
-// 			Name:                   "Failed to create user",
-// 			RequestBody:            "{\"email\": \"abc@gmail.com\"}",
-// 			RequestPath:            "/user/create",
-// 			ExpectedStatusCode:     500,
-// 			StoreError:             true,
-// 			IsHandlerErrorExpected: true,
-// 		},
-// 	}
-
-// 	for _, tt := range tests {
-// 		t.Run(tt.Name, func(t *testing.T) {
-// 			l, err := zap.NewDevelopment()
-// 			if err != nil {
-// 				t.Fatalf("Failed to initialise dev logger")
-// 			}
-
-// 			u := mockUserStore{
-// 				isError: tt.StoreError,
-// 			}
-
-// 			h, err := NewHandler(l, u)
-// 			if err != nil {
-// 				t.Fatalf("Failed to initialise handler")
-// 			}
-
-// 			req := events.APIGatewayProxyRequest{
-// 				// This test should probably fail if the body isn't the correct format?
-// 				Body: tt.RequestBody,
-// 				Path: tt.RequestPath,
-// 			}
-
-// 			r, err := h.Handle(context.Background(), req)
-// 			if err != nil && !tt.IsHandlerErrorExpected {
-// 				t.Fatalf("Unexpected handler error")
-// 			}
-
-// 			if r.StatusCode != tt.ExpectedStatusCode {
-// 				t.Fatalf("Expected Status Code to be %v", tt.ExpectedStatusCode)
-// 			}
-// 		})
-// 	}
-// }
+func TestHandler(t *testing.T) {
+	type test struct {
+		Name               string
+		StoreError         bool
+		RequestBody        string
+		RequestPath        string
+		PathParameters     map[string]string
+		ExpectedStatusCode int
+		IsDeprecated       bool
+	}
+
+	tests := []test{
+		{
+			Name:               "Successfully get user",
+			RequestPath:        "/user/12345",
+			PathParameters:     map[string]string{"id": "12345"},
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "User does not exist",
+			RequestPath:        "/user/missing",
+			PathParameters:     map[string]string{"id": "missing"},
+			ExpectedStatusCode: 404,
+		},
+		{
+			Name:               "Successfully get user by RPC",
+			RequestBody:        "{\"id\": \"12345\"}",
+			RequestPath:        "/user/get",
+			ExpectedStatusCode: 200,
+			IsDeprecated:       true,
+		},
+		{
+			Name:               "User does not exist by RPC",
+			RequestBody:        "{\"id\": \"missing\"}",
+			RequestPath:        "/user/get",
+			ExpectedStatusCode: 404,
+			IsDeprecated:       true,
+		},
+		{
+			Name:               "Successfully get users in batch",
+			RequestBody:        "{\"ids\": [\"12345\", \"missing\"]}",
+			RequestPath:        "/user/get/batch",
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "Empty batch",
+			RequestBody:        "{\"ids\": []}",
+			RequestPath:        "/user/get/batch",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Failed to get users in batch",
+			RequestBody:        "{\"ids\": [\"12345\"]}",
+			RequestPath:        "/user/get/batch",
+			ExpectedStatusCode: 500,
+			StoreError:         true,
+		},
+		{
+			Name:               "Failed to get user",
+			RequestPath:        "/user/12345",
+			PathParameters:     map[string]string{"id": "12345"},
+			ExpectedStatusCode: 500,
+			StoreError:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			l, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatalf("Failed to initialise dev logger")
+			}
+
+			u := mockUserStore{
+				isError: tt.StoreError,
+			}
+
+			h, err := NewHandler(l, u)
+			if err != nil {
+				t.Fatalf("Failed to initialise handler")
+			}
+
+			req := events.APIGatewayProxyRequest{
+				Body:           tt.RequestBody,
+				Path:           tt.RequestPath,
+				PathParameters: tt.PathParameters,
+			}
+
+			r, err := h.Handle(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Unexpected handler error")
+			}
+
+			if r.StatusCode != tt.ExpectedStatusCode {
+				t.Fatalf("Expected Status Code to be %v, got %v", tt.ExpectedStatusCode, r.StatusCode)
+			}
+
+			if r.StatusCode == 200 && tt.RequestPath != "/user/get/batch" && r.Headers["ETag"] != "\"3\"" {
+				t.Fatalf("Expected ETag to be \"3\", got %v", r.Headers["ETag"])
+			}
+
+			if _, ok := r.Headers["Deprecation"]; ok != tt.IsDeprecated {
+				t.Fatalf("Expected deprecation to be %v", tt.IsDeprecated)
+			}
+		})
+	}
+}
+
+/*
+Tests that users can only be got from their own tenant
+*/
+func TestHandlerTenantIsolation(t *testing.T) {
+	store := memstore.NewStore()
+	_, err := store.Create(tenant.WithTenant(context.Background(), "acme"), models.User{UserID: "12345", Email: "abc@gmail.com"})
+	if err != nil {
+		t.Fatalf("Failed to create user")
+	}
+
+	h, err := NewHandler(zap.NewNop(), store)
+	if err != nil {
+		t.Fatalf("Failed to initialise handler")
+	}
+
+	tests := map[string]struct {
+		Headers            map[string]string
+		Authorizer         map[string]interface{}
+		ExpectedStatusCode int
+	}{
+		"Own tenant":                  {Headers: map[string]string{"X-Tenant-ID": "acme"}, Authorizer: map[string]interface{}{"tenantID": "acme"}, ExpectedStatusCode: 200},
+		"Another tenant":              {Authorizer: map[string]interface{}{"tenantID": "globex"}, ExpectedStatusCode: 404},
+		"Default tenant":              {ExpectedStatusCode: 404},
+		"Invalid tenant":              {Headers: map[string]string{"X-Tenant-ID": "ACME!"}, ExpectedStatusCode: 400},
+		"Unassigned tenant":           {Headers: map[string]string{"X-Tenant-ID": "acme"}, ExpectedStatusCode: 403},
+		"Authorizer's tenant":         {Authorizer: map[string]interface{}{"tenantID": "acme"}, ExpectedStatusCode: 200},
+		"Not the authorizer's tenant": {Headers: map[string]string{"X-Tenant-ID": "acme"}, Authorizer: map[string]interface{}{"tenantID": "globex"}, ExpectedStatusCode: 403},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := events.APIGatewayProxyRequest{
+				Path:           "/user/12345",
+				PathParameters: map[string]string{"id": "12345"},
+				Headers:        tt.Headers,
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: tt.Authorizer},
+			}
+
+			r, err := h.Handle(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Unexpected handler error")
+			}
+
+			if r.StatusCode != tt.ExpectedStatusCode {
+				t.Fatalf("Expected Status Code to be %v, got %v", tt.ExpectedStatusCode, r.StatusCode)
+			}
+		})
+	}
+}
@@ -7,12 +7,20 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/db/storeconfig"
+	"github.com/benjaminkitson/bk-user-api/db/userstore/cache"
 	"github.com/benjaminkitson/bk-user-api/lambda/user/get/handler"
 	"go.uber.org/zap"
 )
 
+/*
+userCache lives outside the handler so that it is shared by every invocation of the same Lambda instance. It is
+nil unless caching has been enabled through the environment. Users are written by the other Lambdas, which can't
+invalidate it, so a cached user is served as it was for up to the cache's TTL after it changes or is deleted.
+Keep the TTL short here, or deploy the router, whose cache sees its own writes.
+*/
+var userCache, userCacheErr = cache.NewFromEnv()
+
 func main() {
 	lambda.Start(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 		logger, err := zap.NewProduction()
@@ -28,11 +36,28 @@ func main() {
 			return events.APIGatewayProxyResponse{}, err
 		}
 
-		d := dynamodb.NewFromConfig(sdkConfig)
-
-		tableName := "userTable"
+		u, err := storeconfig.Open(ctx, storeconfig.FromEnv(), sdkConfig)
+		if err != nil {
+			logger.Error("Failed to initialise user store", zap.Error(err))
+			return events.APIGatewayProxyResponse{}, err
+		}
 
-		u := userstore.NewUserStore(d, tableName)
+		if userCacheErr != nil {
+			logger.Error("Failed to initialise user cache", zap.Error(userCacheErr))
+			return events.APIGatewayProxyResponse{}, userCacheErr
+		}
+		if userCache != nil {
+			u = userCache.Wrap(u)
+			defer func() {
+				stats := userCache.Stats()
+				logger.Info("User cache stats",
+					zap.Int64("hits", stats.Hits),
+					zap.Int64("negativeHits", stats.NegativeHits),
+					zap.Int64("misses", stats.Misses),
+					zap.Int64("evictions", stats.Evictions),
+				)
+			}()
+		}
 
 		h, err := handler.NewHandler(logger, u)
 		if err != nil {
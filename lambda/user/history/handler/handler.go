@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/models"
+	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type handler struct {
+	logger    *zap.Logger
+	userStore handlerUserStore
+}
+
+type handlerUserStore interface {
+	History(ctx context.Context, id string, limit int32, cursor string) (models.HistoryPage, error)
+}
+
+func NewHandler(logger *zap.Logger, u handlerUserStore) (handler, error) {
+	return handler{
+		logger:    logger,
+		userStore: u,
+	}, nil
+}
+
+// Handle returns a page of the history of the user given by the id path parameter, newest first
+func (handler handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return utils.Wrap(handler.logger, handler.handle)(ctx, request)
+}
+
+func (handler handler) handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := request.PathParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{}, utils.ValidationError(models.FieldError{Field: "id", Message: "is required"})
+	}
+
+	limit, err := utils.PageLimit(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	page, err := handler.userStore.History(ctx, id, limit, request.QueryStringParameters["cursor"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, errors.Wrapf(err, "error retrieving history of user %s", id)
+	}
+
+	return utils.JSON(page)
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"go.uber.org/zap"
+)
+
+type mockUserStore struct {
+	isError bool
+}
+
+func (m mockUserStore) History(ctx context.Context, id string, limit int32, cursor string) (models.HistoryPage, error) {
+	if m.isError {
+		return models.HistoryPage{}, fmt.Errorf("UserStore history error!")
+	}
+	if cursor == "invalid" {
+		return models.HistoryPage{}, userstore.ErrInvalidCursor
+	}
+	return models.HistoryPage{
+		Entries: []models.HistoryEntry{
+			{
+				UserID:    id,
+				Action:    models.HistoryCreate,
+				Version:   1,
+				Timestamp: time.Now(),
+				Changes:   []models.UserChange{{Field: "email", To: "abc@gmail.com"}},
+			},
+		},
+		Cursor: "next",
+	}, nil
+}
+
+/*
+Tests the basic workings of the handler
+*/
+func TestHandler(t *testing.T) {
+	type test struct {
+		Name                  string
+		StoreError            bool
+		PathParameters        map[string]string
+		QueryStringParameters map[string]string
+		ExpectedStatusCode    int
+	}
+
+	tests := []test{
+		{
+			Name:               "Successfully get user history",
+			PathParameters:     map[string]string{"id": "12345"},
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:                  "Successfully get user history with limit and cursor",
+			PathParameters:        map[string]string{"id": "12345"},
+			QueryStringParameters: map[string]string{"limit": "10", "cursor": "abc"},
+			ExpectedStatusCode:    200,
+		},
+		{
+			Name:               "Missing user id",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:                  "Invalid limit",
+			PathParameters:        map[string]string{"id": "12345"},
+			QueryStringParameters: map[string]string{"limit": "0"},
+			ExpectedStatusCode:    400,
+		},
+		{
+			Name:                  "Invalid cursor",
+			PathParameters:        map[string]string{"id": "12345"},
+			QueryStringParameters: map[string]string{"cursor": "invalid"},
+			ExpectedStatusCode:    400,
+		},
+		{
+			Name:               "Failed to get user history",
+			PathParameters:     map[string]string{"id": "12345"},
+			ExpectedStatusCode: 500,
+			StoreError:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			l, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatalf("Failed to initialise dev logger")
+			}
+
+			u := mockUserStore{
+				isError: tt.StoreError,
+			}
+
+			h, err := NewHandler(l, u)
+			if err != nil {
+				t.Fatalf("Failed to initialise handler")
+			}
+
+			req := events.APIGatewayProxyRequest{
+				HTTPMethod:            "GET",
+				Path:                  "/user/12345/history",
+				PathParameters:        tt.PathParameters,
+				QueryStringParameters: tt.QueryStringParameters,
+			}
+
+			r, err := h.Handle(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Unexpected handler error")
+			}
+
+			if r.StatusCode != tt.ExpectedStatusCode {
+				t.Fatalf("Expected Status Code to be %v, got %v", tt.ExpectedStatusCode, r.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/models"
+	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type handler struct {
+	logger    *zap.Logger
+	userStore handlerUserStore
+}
+
+type handlerUserStore interface {
+	List(ctx context.Context, limit int32, cursor string) (models.UserPage, error)
+	ListCreatedBetween(ctx context.Context, from time.Time, to time.Time, limit int32, cursor string) (models.UserPage, error)
+}
+
+/*
+maxCreatedRange bounds how far apart createdAfter and createdBefore can be. Users are indexed by the month they
+were created in, and a page may need to query every month in the range, so it keeps requests within the
+Lambda's timeout.
+*/
+const maxCreatedRange = 366 * 24 * time.Hour
+
+func NewHandler(logger *zap.Logger, u handlerUserStore) (handler, error) {
+	return handler{
+		logger:    logger,
+		userStore: u,
+	}, nil
+}
+
+// Handle returns a page of users, optionally only those created within a range of times
+func (handler handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return utils.Wrap(handler.logger, handler.handle)(ctx, request)
+}
+
+func (handler handler) handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	limit, err := utils.PageLimit(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	from, to, byCreation, err := createdRange(request.QueryStringParameters)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	var page models.UserPage
+	if byCreation {
+		page, err = handler.userStore.ListCreatedBetween(ctx, from, to, limit, request.QueryStringParameters["cursor"])
+	} else {
+		page, err = handler.userStore.List(ctx, limit, request.QueryStringParameters["cursor"])
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, errors.Wrap(err, "error listing users")
+	}
+
+	return utils.JSON(page)
+}
+
+/*
+createdRange returns the range of creation times to list users from, if the query asks for one. createdAfter is
+required and inclusive, and createdBefore is exclusive and defaults to now. Both are RFC 3339 timestamps, and a
+validation error naming the offending parameter is returned if the range is invalid.
+*/
+func createdRange(query map[string]string) (time.Time, time.Time, bool, error) {
+	after, hasAfter := query["createdAfter"]
+	before, hasBefore := query["createdBefore"]
+	if !hasAfter && !hasBefore {
+		return time.Time{}, time.Time{}, false, nil
+	}
+	if !hasAfter {
+		return time.Time{}, time.Time{}, false, utils.ValidationError(models.FieldError{Field: "createdAfter", Message: "is required with createdBefore"})
+	}
+
+	from, err := time.Parse(time.RFC3339, after)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, utils.ValidationError(models.FieldError{Field: "createdAfter", Message: "must be an RFC 3339 timestamp"})
+	}
+	to := time.Now()
+	if hasBefore {
+		to, err = time.Parse(time.RFC3339, before)
+		if err != nil {
+			return time.Time{}, time.Time{}, false, utils.ValidationError(models.FieldError{Field: "createdBefore", Message: "must be an RFC 3339 timestamp"})
+		}
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, false, utils.ValidationError(models.FieldError{Field: "createdAfter", Message: "must be before createdBefore"})
+	}
+	if to.Sub(from) > maxCreatedRange {
+		return time.Time{}, time.Time{}, false, utils.ValidationError(models.FieldError{Field: "createdBefore", Message: fmt.Sprintf("must be within %s of createdAfter", maxCreatedRange)})
+	}
+	return from, to, true, nil
+}
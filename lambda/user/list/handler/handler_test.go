@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"go.uber.org/zap"
+)
+
+type mockUserStore struct {
+	isError bool
+}
+
+func (m mockUserStore) List(ctx context.Context, limit int32, cursor string) (models.UserPage, error) {
+	if m.isError {
+		return models.UserPage{}, fmt.Errorf("UserStore list error!")
+	}
+	if cursor == "invalid" {
+		return models.UserPage{}, userstore.ErrInvalidCursor
+	}
+	return models.UserPage{Users: []models.User{{UserID: "12345", Email: "abc@gmail.com"}}, Cursor: "next"}, nil
+}
+
+func (m mockUserStore) ListCreatedBetween(ctx context.Context, from time.Time, to time.Time, limit int32, cursor string) (models.UserPage, error) {
+	if m.isError {
+		return models.UserPage{}, fmt.Errorf("UserStore list error!")
+	}
+	if cursor == "invalid" {
+		return models.UserPage{}, userstore.ErrInvalidCursor
+	}
+	return models.UserPage{Users: []models.User{{UserID: "12345", Email: "abc@gmail.com", CreatedAt: from}}}, nil
+}
+
+/*
+Tests the basic workings of the handler
+*/
+func TestHandler(t *testing.T) {
+	type test struct {
+		Name                  string
+		StoreError            bool
+		QueryStringParameters map[string]string
+		ExpectedStatusCode    int
+	}
+
+	tests := []test{
+		{
+			Name:               "Successfully list users",
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:                  "Successfully list users with limit and cursor",
+			QueryStringParameters: map[string]string{"limit": "10", "cursor": "abc"},
+			ExpectedStatusCode:    200,
+		},
+		{
+			Name:                  "Invalid limit",
+			QueryStringParameters: map[string]string{"limit": "abc"},
+			ExpectedStatusCode:    400,
+		},
+		{
+			Name:                  "Limit too large",
+			QueryStringParameters: map[string]string{"limit": "1000"},
+			ExpectedStatusCode:    400,
+		},
+		{
+			Name:                  "Invalid cursor",
+			QueryStringParameters: map[string]string{"cursor": "invalid"},
+			ExpectedStatusCode:    400,
+		},
+		{
+			Name:                  "Successfully list users created in a range",
+			QueryStringParameters: map[string]string{"createdAfter": "2024-10-01T00:00:00Z", "createdBefore": "2024-10-08T00:00:00Z"},
+			ExpectedStatusCode:    200,
+		},
+		{
+			Name:                  "Successfully list users created since a time",
+			QueryStringParameters: map[string]string{"createdAfter": time.Now().Add(-7 * 24 * time.Hour).Format(time.RFC3339)},
+			ExpectedStatusCode:    200,
+		},
+		{
+			Name:                  "createdBefore without createdAfter",
+			QueryStringParameters: map[string]string{"createdBefore": "2024-10-08T00:00:00Z"},
+			ExpectedStatusCode:    400,
+		},
+		{
+			Name:                  "Invalid createdAfter",
+			QueryStringParameters: map[string]string{"createdAfter": "last week"},
+			ExpectedStatusCode:    400,
+		},
+		{
+			Name:                  "Range out of order",
+			QueryStringParameters: map[string]string{"createdAfter": "2024-10-08T00:00:00Z", "createdBefore": "2024-10-01T00:00:00Z"},
+			ExpectedStatusCode:    400,
+		},
+		{
+			Name:                  "Range too long",
+			QueryStringParameters: map[string]string{"createdAfter": "2020-01-01T00:00:00Z", "createdBefore": "2024-10-01T00:00:00Z"},
+			ExpectedStatusCode:    400,
+		},
+		{
+			Name:                  "Invalid cursor for a range",
+			QueryStringParameters: map[string]string{"createdAfter": "2024-10-01T00:00:00Z", "createdBefore": "2024-10-08T00:00:00Z", "cursor": "invalid"},
+			ExpectedStatusCode:    400,
+		},
+		{
+			Name:               "Failed to list users",
+			ExpectedStatusCode: 500,
+			StoreError:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			l, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatalf("Failed to initialise dev logger")
+			}
+
+			u := mockUserStore{
+				isError: tt.StoreError,
+			}
+
+			h, err := NewHandler(l, u)
+			if err != nil {
+				t.Fatalf("Failed to initialise handler")
+			}
+
+			req := events.APIGatewayProxyRequest{
+				HTTPMethod:            "GET",
+				Path:                  "/user",
+				QueryStringParameters: tt.QueryStringParameters,
+			}
+
+			r, err := h.Handle(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Unexpected handler error")
+			}
+
+			if r.StatusCode != tt.ExpectedStatusCode {
+				t.Fatalf("Expected Status Code to be %v, got %v", tt.ExpectedStatusCode, r.StatusCode)
+			}
+		})
+	}
+}
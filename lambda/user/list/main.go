@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/benjaminkitson/bk-user-api/db/storeconfig"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/lambda/user/list/handler"
+	"github.com/benjaminkitson/bk-user-api/secrets"
+	"go.uber.org/zap"
+)
+
+func main() {
+	lambda.Start(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		logger, err := zap.NewProduction()
+		if err != nil {
+			fmt.Printf("Failed to initialise logger: %v", err)
+			logger = &zap.Logger{}
+		}
+		defer logger.Sync()
+
+		sdkConfig, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			logger.Error("Failed to intialise SDK config", zap.Error(err))
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		sc, err := secrets.NewSecretsClient(logger, secretsmanager.NewFromConfig(sdkConfig))
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		cursorKey, err := sc.GetSecret(os.Getenv("CURSOR_KEY_SECRET_NAME"))
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		u, err := storeconfig.Open(ctx, storeconfig.FromEnv(), sdkConfig, userstore.WithCursorKey([]byte(cursorKey)))
+		if err != nil {
+			logger.Error("Failed to initialise user store", zap.Error(err))
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		h, err := handler.NewHandler(logger, u)
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		return h.Handle(ctx, request)
+	})
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/userevents"
+	"go.uber.org/zap"
+)
+
+type handler struct {
+	logger    *zap.Logger
+	userStore handlerUserStore
+	publisher userevents.Publisher
+	opts      userstore.RelayOptions
+}
+
+type handlerUserStore interface {
+	RelayOutbox(ctx context.Context, publisher userevents.Publisher, opts userstore.RelayOptions) (userstore.RelayReport, error)
+}
+
+func NewHandler(logger *zap.Logger, u handlerUserStore, p userevents.Publisher, opts userstore.RelayOptions) (handler, error) {
+	return handler{
+		logger:    logger,
+		userStore: u,
+		publisher: p,
+		opts:      opts,
+	}, nil
+}
+
+/*
+Handle drains the outbox, and is run on a schedule. Events that fail to publish are retried by later runs, so
+they are only logged here; the run itself only fails if the outbox can't be read or updated.
+*/
+func (handler handler) Handle(ctx context.Context) error {
+	report, err := handler.userStore.RelayOutbox(ctx, handler.publisher, handler.opts)
+	fields := []zap.Field{
+		zap.Int("published", report.Published),
+		zap.Int("failed", report.Failed),
+		zap.Int("deferred", report.Deferred),
+	}
+	if err != nil {
+		handler.logger.Error("failed to relay outbox", append(fields, zap.Error(err))...)
+		return err
+	}
+
+	if report.Failed > 0 {
+		handler.logger.Warn("some user events could not be published", fields...)
+		return nil
+	}
+	handler.logger.Info("relayed outbox", fields...)
+	return nil
+}
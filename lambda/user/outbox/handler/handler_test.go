@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/userevents"
+	"go.uber.org/zap"
+)
+
+type mockUserStore struct {
+	isError        bool
+	publishFailure bool
+}
+
+func (m mockUserStore) RelayOutbox(ctx context.Context, publisher userevents.Publisher, opts userstore.RelayOptions) (userstore.RelayReport, error) {
+	if m.isError {
+		return userstore.RelayReport{Published: 1}, fmt.Errorf("UserStore relay error!")
+	}
+	if m.publishFailure {
+		return userstore.RelayReport{Published: 1, Failed: 1, Deferred: 2}, nil
+	}
+	return userstore.RelayReport{Published: 3}, nil
+}
+
+/*
+Tests the basic workings of the handler
+*/
+func TestHandler(t *testing.T) {
+	type test struct {
+		Name                   string
+		StoreError             bool
+		PublishFailure         bool
+		IsHandlerErrorExpected bool
+	}
+
+	tests := []test{
+		{
+			Name: "Successfully relay outbox",
+		},
+		{
+			Name:           "Publish failures are left for the next run",
+			PublishFailure: true,
+		},
+		{
+			Name:                   "Failed to relay outbox",
+			StoreError:             true,
+			IsHandlerErrorExpected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			l, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatalf("Failed to initialise dev logger")
+			}
+
+			u := mockUserStore{
+				isError:        tt.StoreError,
+				publishFailure: tt.PublishFailure,
+			}
+
+			h, err := NewHandler(l, u, &userevents.MemoryPublisher{}, userstore.RelayOptions{})
+			if err != nil {
+				t.Fatalf("Failed to initialise handler")
+			}
+
+			err = h.Handle(context.Background())
+			if (err != nil) != tt.IsHandlerErrorExpected {
+				t.Fatalf("Expected handler error to be %v, got %v", tt.IsHandlerErrorExpected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/benjaminkitson/bk-user-api/db/storeconfig"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/lambda/user/outbox/handler"
+	"github.com/benjaminkitson/bk-user-api/userevents"
+	"go.uber.org/zap"
+)
+
+// Leaves plenty of the Lambda's timeout for the items it does publish
+const maxItemsPerRun = 1000
+
+func main() {
+	lambda.Start(func(ctx context.Context) error {
+		logger, err := zap.NewProduction()
+		if err != nil {
+			fmt.Printf("Failed to initialise logger: %v", err)
+			logger = &zap.Logger{}
+		}
+		defer logger.Sync()
+
+		sdkConfig, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			logger.Error("Failed to intialise SDK config", zap.Error(err))
+			return err
+		}
+
+		// Only the DynamoDB store has an outbox
+		c := storeconfig.FromEnv()
+		opts, err := storeconfig.StoreOptions(ctx, c, sdkConfig)
+		if err != nil {
+			logger.Error("Failed to initialise user store", zap.Error(err))
+			return err
+		}
+		u := userstore.NewUserStore(dynamodb.NewFromConfig(sdkConfig), c.TableName, opts...)
+		p := userevents.NewEventBridgePublisher(eventbridge.NewFromConfig(sdkConfig), os.Getenv(userevents.EventBusEnv))
+
+		h, err := handler.NewHandler(logger, u, p, userstore.RelayOptions{MaxItems: maxItemsPerRun})
+		if err != nil {
+			return err
+		}
+
+		return h.Handle(ctx)
+	})
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type handler struct {
+	logger    *zap.Logger
+	userStore handlerUserStore
+}
+
+type handlerUserStore interface {
+	Restore(ctx context.Context, id string, expectedVersion int64) (models.User, error)
+}
+
+func NewHandler(logger *zap.Logger, u handlerUserStore) (handler, error) {
+	return handler{
+		logger:    logger,
+		userStore: u,
+	}, nil
+}
+
+// restoreRequest is the body of the deprecated POST /user/restore route, which takes the id in the body
+type restoreRequest struct {
+	ID string `json:"id" validate:"required"`
+}
+
+// Handle restores the deleted user given by the id path parameter, responding with the restored user
+func (handler handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, ok := request.PathParameters["id"]; !ok {
+		return utils.Deprecated(utils.Wrap(handler.logger, handler.handleRPC), utils.RPCRoutesDeprecatedAt)(ctx, request)
+	}
+	return utils.Wrap(handler.logger, handler.handle)(ctx, request)
+}
+
+func (handler handler) handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return handler.restoreUser(ctx, request, request.PathParameters["id"])
+}
+
+func (handler handler) handleRPC(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body, err := utils.DecodeBody[restoreRequest](request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	return handler.restoreUser(ctx, request, body.ID)
+}
+
+func (handler handler) restoreUser(ctx context.Context, request events.APIGatewayProxyRequest, id string) (events.APIGatewayProxyResponse, error) {
+	version, err := utils.IfMatchVersion(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	handler.logger.Info("attempting user restore", zap.String("userID", id))
+	u, err := handler.userStore.Restore(ctx, id, version)
+	if errors.Is(err, userstore.ErrConflict) && version != 0 {
+		return events.APIGatewayProxyResponse{}, utils.PreconditionFailed(err)
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, errors.Wrapf(err, "error restoring user %s", id)
+	}
+	handler.logger.Info("successfully restored user", zap.String("userID", id))
+
+	response, err := utils.JSON(u)
+	if err != nil {
+		return response, err
+	}
+	return utils.WithETag(response, u.Version), nil
+}
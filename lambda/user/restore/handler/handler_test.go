@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"go.uber.org/zap"
+)
+
+type mockUserStore struct {
+	isError bool
+}
+
+func (m mockUserStore) Restore(ctx context.Context, id string, expectedVersion int64) (models.User, error) {
+	if m.isError {
+		return models.User{}, fmt.Errorf("UserStore restore error!")
+	}
+	switch id {
+	case "missing":
+		return models.User{}, userstore.ErrNotFound
+	case "active":
+		return models.User{}, userstore.ErrNotDeleted
+	case "reused":
+		return models.User{}, userstore.ErrEmailTaken
+	}
+	if expectedVersion != 0 && expectedVersion != 2 {
+		return models.User{}, userstore.ErrConflict
+	}
+	return models.User{UserID: id, Email: "abc@gmail.com", Version: 3}, nil
+}
+
+/*
+Tests the basic workings of the handler
+*/
+func TestHandler(t *testing.T) {
+	type test struct {
+		Name               string
+		StoreError         bool
+		PathID             string
+		RequestBody        string
+		RequestHeaders     map[string]string
+		ExpectedStatusCode int
+	}
+
+	tests := []test{
+		{
+			Name:               "Successfully restore user",
+			PathID:             "12345",
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "User has been modified",
+			PathID:             "12345",
+			RequestHeaders:     map[string]string{"If-Match": "\"1\""},
+			ExpectedStatusCode: 412,
+		},
+		{
+			Name:               "User is not deleted",
+			PathID:             "active",
+			ExpectedStatusCode: 409,
+		},
+		{
+			Name:               "User is not deleted, at the expected version",
+			PathID:             "active",
+			RequestHeaders:     map[string]string{"If-Match": "\"2\""},
+			ExpectedStatusCode: 409,
+		},
+		{
+			Name:               "Email has been reused",
+			PathID:             "reused",
+			ExpectedStatusCode: 409,
+		},
+		{
+			Name:               "User does not exist",
+			PathID:             "missing",
+			ExpectedStatusCode: 404,
+		},
+		{
+			Name:               "Failed to restore user",
+			PathID:             "12345",
+			ExpectedStatusCode: 500,
+			StoreError:         true,
+		},
+		{
+			Name:               "Successfully restore user by RPC",
+			RequestBody:        "{\"id\": \"12345\"}",
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "Missing id by RPC",
+			RequestBody:        "{}",
+			ExpectedStatusCode: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			l, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatalf("Failed to initialise dev logger")
+			}
+
+			u := mockUserStore{
+				isError: tt.StoreError,
+			}
+
+			h, err := NewHandler(l, u)
+			if err != nil {
+				t.Fatalf("Failed to initialise handler")
+			}
+
+			req := events.APIGatewayProxyRequest{
+				Body:    tt.RequestBody,
+				Path:    "/user/restore",
+				Headers: tt.RequestHeaders,
+			}
+			if tt.PathID != "" {
+				req.Path = "/user/" + tt.PathID + "/restore"
+				req.PathParameters = map[string]string{"id": tt.PathID}
+			}
+
+			r, err := h.Handle(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Unexpected handler error")
+			}
+
+			if r.StatusCode != tt.ExpectedStatusCode {
+				t.Fatalf("Expected Status Code to be %v, got %v", tt.ExpectedStatusCode, r.StatusCode)
+			}
+
+			if _, ok := r.Headers["Deprecation"]; ok != (tt.PathID == "") {
+				t.Fatalf("Expected only the RPC route to be deprecated")
+			}
+		})
+	}
+}
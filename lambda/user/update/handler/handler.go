@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type handler struct {
+	logger    *zap.Logger
+	userStore handlerUserStore
+}
+
+type handlerUserStore interface {
+	Update(ctx context.Context, id string, changes models.UserUpdate, expectedVersion int64) (models.User, error)
+}
+
+func NewHandler(logger *zap.Logger, u handlerUserStore) (handler, error) {
+	return handler{
+		logger:    logger,
+		userStore: u,
+	}, nil
+}
+
+// updateRequest is the body of PATCH /user/{id}, holding the fields to change
+type updateRequest struct {
+	Email *string `json:"email" validate:"email"`
+}
+
+// rpcUpdateRequest is the body of the deprecated PATCH /user/update route, which takes the id in the body
+type rpcUpdateRequest struct {
+	ID string `json:"id" validate:"required"`
+	updateRequest
+}
+
+// Handle updates the given fields of the user given by the id path parameter, responding with the updated user
+func (handler handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if _, ok := request.PathParameters["id"]; !ok {
+		return utils.Deprecated(utils.Wrap(handler.logger, handler.handleRPC), utils.RPCRoutesDeprecatedAt)(ctx, request)
+	}
+	return utils.Wrap(handler.logger, handler.handle)(ctx, request)
+}
+
+func (handler handler) handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body, err := utils.DecodeBody[updateRequest](request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	return handler.updateUser(ctx, request, request.PathParameters["id"], body)
+}
+
+func (handler handler) handleRPC(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body, err := utils.DecodeBody[rpcUpdateRequest](request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	return handler.updateUser(ctx, request, body.ID, body.updateRequest)
+}
+
+func (handler handler) updateUser(ctx context.Context, request events.APIGatewayProxyRequest, id string, body updateRequest) (events.APIGatewayProxyResponse, error) {
+	version, err := utils.IfMatchVersion(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	handler.logger.Info("attempting user update", zap.String("userID", id))
+	u, err := handler.userStore.Update(ctx, id, models.UserUpdate{Email: body.Email}, version)
+	if errors.Is(err, userstore.ErrConflict) && version != 0 {
+		return events.APIGatewayProxyResponse{}, utils.PreconditionFailed(err)
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, errors.Wrapf(err, "error updating user %s", id)
+	}
+	handler.logger.Info("successfully updated user", zap.String("userID", id))
+
+	response, err := utils.JSON(u)
+	if err != nil {
+		return response, err
+	}
+	return utils.WithETag(response, u.Version), nil
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"go.uber.org/zap"
+)
+
+type mockUserStore struct {
+	isError bool
+}
+
+func (m mockUserStore) Update(ctx context.Context, id string, changes models.UserUpdate, expectedVersion int64) (models.User, error) {
+	if m.isError {
+		return models.User{}, fmt.Errorf("UserStore update error!")
+	}
+	if id == "missing" {
+		return models.User{}, userstore.ErrNotFound
+	}
+	if expectedVersion != 0 && expectedVersion != 1 {
+		return models.User{}, userstore.ErrConflict
+	}
+	if changes.Email != nil && *changes.Email == "taken@gmail.com" {
+		return models.User{}, userstore.ErrEmailTaken
+	}
+	u := models.User{UserID: id, Email: "abc@gmail.com", Version: 2}
+	if changes.Email != nil {
+		u.Email = *changes.Email
+	}
+	return u, nil
+}
+
+/*
+Tests the basic workings of the handler
+*/
+func TestHandler(t *testing.T) {
+	type test struct {
+		Name               string
+		StoreError         bool
+		PathID             string
+		RequestBody        string
+		RequestHeaders     map[string]string
+		ExpectedStatusCode int
+	}
+
+	tests := []test{
+		{
+			Name:               "Successfully update user",
+			PathID:             "12345",
+			RequestBody:        "{\"email\": \"new@gmail.com\"}",
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "Successfully update user at expected version",
+			PathID:             "12345",
+			RequestBody:        "{\"email\": \"new@gmail.com\"}",
+			RequestHeaders:     map[string]string{"If-Match": "\"1\""},
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "User has been modified",
+			PathID:             "12345",
+			RequestBody:        "{\"email\": \"new@gmail.com\"}",
+			RequestHeaders:     map[string]string{"If-Match": "\"2\""},
+			ExpectedStatusCode: 412,
+		},
+		{
+			Name:               "Email already in use",
+			PathID:             "12345",
+			RequestBody:        "{\"email\": \"taken@gmail.com\"}",
+			ExpectedStatusCode: 409,
+		},
+		{
+			Name:               "User does not exist",
+			PathID:             "missing",
+			RequestBody:        "{\"email\": \"new@gmail.com\"}",
+			ExpectedStatusCode: 404,
+		},
+		{
+			Name:               "Successfully update user by RPC",
+			RequestBody:        "{\"id\": \"12345\", \"email\": \"new@gmail.com\"}",
+			ExpectedStatusCode: 200,
+		},
+		{
+			Name:               "Missing id by RPC",
+			RequestBody:        "{\"email\": \"new@gmail.com\"}",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Id in body",
+			PathID:             "12345",
+			RequestBody:        "{\"id\": \"12345\", \"email\": \"new@gmail.com\"}",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Empty email",
+			PathID:             "12345",
+			RequestBody:        "{\"email\": \"\"}",
+			ExpectedStatusCode: 400,
+		},
+		{
+			Name:               "Failed to update user",
+			PathID:             "12345",
+			RequestBody:        "{\"email\": \"new@gmail.com\"}",
+			ExpectedStatusCode: 500,
+			StoreError:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			l, err := zap.NewDevelopment()
+			if err != nil {
+				t.Fatalf("Failed to initialise dev logger")
+			}
+
+			u := mockUserStore{
+				isError: tt.StoreError,
+			}
+
+			h, err := NewHandler(l, u)
+			if err != nil {
+				t.Fatalf("Failed to initialise handler")
+			}
+
+			req := events.APIGatewayProxyRequest{
+				Body:    tt.RequestBody,
+				Path:    "/user/update",
+				Headers: tt.RequestHeaders,
+			}
+			if tt.PathID != "" {
+				req.Path = "/user/" + tt.PathID
+				req.PathParameters = map[string]string{"id": tt.PathID}
+			}
+
+			r, err := h.Handle(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Unexpected handler error")
+			}
+
+			if r.StatusCode != tt.ExpectedStatusCode {
+				t.Fatalf("Expected Status Code to be %v, got %v", tt.ExpectedStatusCode, r.StatusCode)
+			}
+
+			if _, ok := r.Headers["Deprecation"]; ok != (tt.PathID == "") {
+				t.Fatalf("Expected only the RPC route to be deprecated")
+			}
+		})
+	}
+}
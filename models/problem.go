@@ -0,0 +1,41 @@
+package models
+
+// ProblemContentType is the media type of the API's error responses
+const ProblemContentType = "application/problem+json"
+
+// Problem types identify the kind of error a problem describes, so callers can branch on them
+const (
+	ProblemValidation         = "/problems/validation"
+	ProblemMalformedRequest   = "/problems/malformed-request"
+	ProblemInvalidPath        = "/problems/invalid-path"
+	ProblemMethodNotAllowed   = "/problems/method-not-allowed"
+	ProblemInvalidTenant      = "/problems/invalid-tenant"
+	ProblemForbidden          = "/problems/forbidden"
+	ProblemNotFound           = "/problems/not-found"
+	ProblemConflict           = "/problems/conflict"
+	ProblemEmailTaken         = "/problems/email-taken"
+	ProblemPreconditionFailed = "/problems/precondition-failed"
+	ProblemUnavailable        = "/problems/unavailable"
+	ProblemInternal           = "/problems/internal"
+)
+
+/*
+Problem is the body of every error response, an RFC 7807 problem details object. Title is the same for every
+problem of a type, while Detail explains this occurrence of it, and Instance is the id of the request it
+occurred in.
+*/
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors describes which fields of the request were invalid, for validation problems
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a single field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
@@ -1,6 +1,73 @@
 package models
 
+import "time"
+
 type User struct {
 	UserID string `json:"userID" dynamodbav:"userID"`
-	Email  string `json:"email" dynamodbav:"email"`
+	// TenantID is the tenant the user belongs to, which is empty for the default tenant. It is set by the store
+	// from the tenant the request is made on behalf of.
+	TenantID string `json:"tenantID,omitempty" dynamodbav:"tenantID,omitempty"`
+	Email    string `json:"email" dynamodbav:"email"`
+	// Version is incremented by the store on every write, and is used to detect concurrent modifications
+	Version   int64     `json:"version" dynamodbav:"version"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" dynamodbav:"updatedAt"`
+	// DeletedAt is set when the user has been soft-deleted
+	DeletedAt *time.Time `json:"deletedAt,omitempty" dynamodbav:"deletedAt,omitempty"`
+}
+
+// UserUpdate describes a partial update to a user. Fields left nil are not changed.
+type UserUpdate struct {
+	Email *string `json:"email,omitempty"`
+}
+
+// UserPage is a single page of users, with a cursor for fetching the next page if there is one
+type UserPage struct {
+	Users  []User `json:"users"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// BatchUserResult is the outcome for a single user in a batch request. If the operation failed for that user,
+// Status and Error describe why.
+type BatchUserResult struct {
+	User   *User  `json:"user,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HistoryAction is the kind of change recorded by a history entry
+type HistoryAction string
+
+const (
+	HistoryCreate  HistoryAction = "create"
+	HistoryPut     HistoryAction = "put"
+	HistoryUpdate  HistoryAction = "update"
+	HistoryDelete  HistoryAction = "delete"
+	HistoryRestore HistoryAction = "restore"
+)
+
+// UserChange is a change to a single field of a user. Fields that were or became unset are left empty.
+type UserChange struct {
+	Field string `json:"field" dynamodbav:"field"`
+	From  string `json:"from,omitempty" dynamodbav:"from,omitempty"`
+	To    string `json:"to,omitempty" dynamodbav:"to,omitempty"`
+}
+
+// HistoryEntry is an immutable record of a change made to a user
+type HistoryEntry struct {
+	UserID string        `json:"userID" dynamodbav:"userID"`
+	Action HistoryAction `json:"action" dynamodbav:"action"`
+	// Version is the user's version after the change
+	Version   int64     `json:"version" dynamodbav:"version"`
+	Timestamp time.Time `json:"timestamp" dynamodbav:"timestamp"`
+	// Actor identifies who made the change, if known
+	Actor     string       `json:"actor,omitempty" dynamodbav:"actor,omitempty"`
+	RequestID string       `json:"requestID,omitempty" dynamodbav:"requestID,omitempty"`
+	Changes   []UserChange `json:"changes" dynamodbav:"changes"`
+}
+
+// HistoryPage is a single page of a user's history, newest first, with a cursor for fetching the next page
+type HistoryPage struct {
+	Entries []HistoryEntry `json:"entries"`
+	Cursor  string         `json:"cursor,omitempty"`
 }
@@ -0,0 +1,187 @@
+package pii
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/pkg/errors"
+)
+
+const dataKeySize = 32
+
+// kmsEncryptionContext is bound to every data key generated by KMS, and shows up in CloudTrail
+var kmsEncryptionContext = map[string]string{"purpose": "pii"}
+
+type kmsClient interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+/*
+KMSKeyProvider encrypts data keys under a KMS key. KMS's own rotation of key material is invisible here, as the
+key keeps its id; moving to a different KMS key is what calls for re-encrypting stored values.
+*/
+type KMSKeyProvider struct {
+	client kmsClient
+	keyID  string
+}
+
+var _ KeyProvider = KMSKeyProvider{}
+
+// NewKMSKeyProvider returns a provider using the KMS key with the given ARN
+func NewKMSKeyProvider(client *kms.Client, keyARN string) KMSKeyProvider {
+	return KMSKeyProvider{
+		client: client,
+		keyID:  keyARN,
+	}
+}
+
+func (p KMSKeyProvider) KeyID() string {
+	return p.keyID
+}
+
+func (p KMSKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	out, err := p.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             &p.keyID,
+		KeySpec:           kmstypes.DataKeySpecAes256,
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+func (p KMSKeyProvider) DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	out, err := p.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             &keyID,
+		CiphertextBlob:    encrypted,
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+/*
+KeyFile holds master keys and a blind index key locally, for tests and local development. It is stored as JSON,
+with the keys base64 encoded.
+*/
+type KeyFile struct {
+	// Current is the id of the master key new data keys are encrypted under
+	Current string `json:"current"`
+	// Keys holds every master key by id, including retired keys that old values are still encrypted under
+	Keys     map[string][]byte `json:"keys"`
+	IndexKey []byte            `json:"indexKey"`
+}
+
+// NewKeyFile returns a key file with a new random master key, under the given id, and a new random index key
+func NewKeyFile(keyID string) KeyFile {
+	return KeyFile{
+		Current:  keyID,
+		Keys:     map[string][]byte{keyID: NewKey()},
+		IndexKey: NewKey(),
+	}
+}
+
+// LoadKeyFile reads the key file at the given path
+func LoadKeyFile(path string) (KeyFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return KeyFile{}, errors.Wrap(err, "failed to read key file")
+	}
+
+	var f KeyFile
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return KeyFile{}, errors.Wrap(err, "failed to parse key file")
+	}
+	if _, ok := f.Keys[f.Current]; !ok {
+		return KeyFile{}, fmt.Errorf("key file has no key %q", f.Current)
+	}
+	if len(f.IndexKey) == 0 {
+		return KeyFile{}, errors.New("key file has no index key")
+	}
+	return f, nil
+}
+
+// Save writes the key file to the given path, readable only by its owner
+func (f KeyFile) Save(path string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+// Rotate adds a new master key under the given id, and makes it the current key
+func (f *KeyFile) Rotate(keyID string) {
+	f.Keys[keyID] = NewKey()
+	f.Current = keyID
+}
+
+// Provider returns a provider using the file's master keys
+func (f KeyFile) Provider() LocalKeyProvider {
+	return LocalKeyProvider{keys: f.Keys, current: f.Current}
+}
+
+// LocalKeyProvider encrypts data keys with AES-GCM under master keys held in memory
+type LocalKeyProvider struct {
+	keys    map[string][]byte
+	current string
+}
+
+var _ KeyProvider = LocalKeyProvider{}
+
+func (p LocalKeyProvider) KeyID() string {
+	return p.current
+}
+
+func (p LocalKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	plaintext := NewKey()
+
+	aead, err := newAEAD(p.keys[p.current])
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	return plaintext, aead.Seal(nonce, nonce, plaintext, []byte(p.current)), nil
+}
+
+func (p LocalKeyProvider) DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	master, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", keyID)
+	}
+
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return aead.Open(nil, encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():], []byte(keyID))
+}
+
+// NewKey returns a new random 256 bit key
+func NewKey() []byte {
+	key := make([]byte, dataKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		// crypto/rand only fails if the system's source of randomness is broken
+		panic(err)
+	}
+	return key
+}
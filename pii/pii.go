@@ -0,0 +1,291 @@
+/*
+Package pii encrypts personal data before it is stored, and builds blind indexes so that encrypted values can
+still be looked up.
+
+Values are encrypted with envelope encryption: each value is sealed with AES-GCM under a data key, and the data
+key is itself encrypted under a master key held by a KeyProvider, such as a KMS key. The encrypted data key and
+the id of the master key travel with the value, so values can be decrypted after the master key has been
+rotated, and values still under an old master key can be found and re-encrypted.
+*/
+package pii
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultDataKeyMaxAge is how long a data key is used to encrypt values before a new one is generated
+	DefaultDataKeyMaxAge = 5 * time.Minute
+
+	// prefix marks a value as encrypted, and versions the format of what follows
+	prefix = "pii:v1:"
+	// decryptedKeysLimit bounds the number of decrypted data keys kept for reuse
+	decryptedKeysLimit = 1000
+)
+
+// ErrMalformed is returned when decrypting a value that isn't in the format Encrypt produces
+var ErrMalformed = errors.New("malformed encrypted value")
+
+/*
+KeyProvider holds the master keys that data keys are encrypted under. Every provider identifies its master keys
+by id, so that a data key can always be decrypted by the key it was encrypted under.
+*/
+type KeyProvider interface {
+	// KeyID returns the id of the master key new data keys are encrypted under
+	KeyID() string
+	// GenerateDataKey returns a new 256 bit data key, both in plaintext and encrypted under the current master key
+	GenerateDataKey(ctx context.Context) (plaintext []byte, encrypted []byte, err error)
+	// DecryptDataKey decrypts a data key that was encrypted under the master key with the given id
+	DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error)
+}
+
+type dataKey struct {
+	keyID     string
+	plaintext []byte
+	encrypted []byte
+	created   time.Time
+}
+
+type CipherOption func(*Cipher)
+
+// WithDataKeyMaxAge sets how long a data key is used for before a new one is generated
+func WithDataKeyMaxAge(d time.Duration) CipherOption {
+	return func(c *Cipher) {
+		c.maxAge = d
+	}
+}
+
+// WithClock sets the clock used to age data keys, which is mostly useful in tests
+func WithClock(now func() time.Time) CipherOption {
+	return func(c *Cipher) {
+		c.now = now
+	}
+}
+
+/*
+Cipher encrypts and decrypts values under data keys from a KeyProvider. Data keys are reused for a while rather
+than generated for every value, and decrypted data keys are kept, so that most calls don't reach the provider.
+A Cipher is safe for concurrent use, and is best shared for the lifetime of the process.
+*/
+type Cipher struct {
+	keys   KeyProvider
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	current   *dataKey
+	decrypted map[string][]byte
+}
+
+func NewCipher(keys KeyProvider, opts ...CipherOption) *Cipher {
+	c := &Cipher{
+		keys:      keys,
+		maxAge:    DefaultDataKeyMaxAge,
+		now:       time.Now,
+		decrypted: map[string][]byte{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// IsEncrypted reports whether the value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+/*
+Encrypt encrypts the value, binding it to the record and field it belongs to. The same binding must be given to
+decrypt it, so that encrypted values can't be moved between records.
+*/
+func (c *Cipher) Encrypt(ctx context.Context, plaintext string, binding string) (string, error) {
+	key, err := c.dataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(key.plaintext)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate nonce")
+	}
+
+	// keyID length, keyID, encrypted data key length, encrypted data key, nonce, sealed value
+	b := []byte{byte(len(key.keyID))}
+	b = append(b, key.keyID...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(key.encrypted)))
+	b = append(b, key.encrypted...)
+	b = append(b, nonce...)
+	b = aead.Seal(b, nonce, []byte(plaintext), []byte(binding))
+
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt with the same binding
+func (c *Cipher) Decrypt(ctx context.Context, value string, binding string) (string, error) {
+	e, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := c.decryptDataKey(ctx, e.keyID, e.encryptedKey)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(e.sealed) < aead.NonceSize() {
+		return "", ErrMalformed
+	}
+	plaintext, err := aead.Open(nil, e.sealed[:aead.NonceSize()], e.sealed[aead.NonceSize():], []byte(binding))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt value")
+	}
+	return string(plaintext), nil
+}
+
+/*
+NeedsRotation reports whether the value should be re-encrypted, either because it was never encrypted or because
+its data key was encrypted under a master key other than the current one.
+*/
+func (c *Cipher) NeedsRotation(value string) bool {
+	e, err := parse(value)
+	return err != nil || e.keyID != c.keys.KeyID()
+}
+
+// dataKey returns the data key to encrypt with, generating a new one if the current one is too old
+func (c *Cipher) dataKey(ctx context.Context) (*dataKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keyID := c.keys.KeyID()
+	if c.current != nil && c.current.keyID == keyID && c.now().Sub(c.current.created) < c.maxAge {
+		return c.current, nil
+	}
+
+	plaintext, encrypted, err := c.keys.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate data key")
+	}
+	if len(keyID) > 255 || len(encrypted) > 65535 {
+		return nil, errors.New("key id or encrypted data key is too long")
+	}
+
+	c.current = &dataKey{keyID: keyID, plaintext: plaintext, encrypted: encrypted, created: c.now()}
+	c.remember(encrypted, plaintext)
+	return c.current, nil
+}
+
+func (c *Cipher) decryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	c.mu.Lock()
+	key, ok := c.decrypted[string(encrypted)]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	key, err := c.keys.DecryptDataKey(ctx, keyID, encrypted)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt data key")
+	}
+
+	c.mu.Lock()
+	c.remember(encrypted, key)
+	c.mu.Unlock()
+	return key, nil
+}
+
+// remember keeps a decrypted data key for reuse. It must be called with the lock held.
+func (c *Cipher) remember(encrypted []byte, plaintext []byte) {
+	if len(c.decrypted) >= decryptedKeysLimit {
+		// Values are mostly encrypted under a handful of recent keys, so starting again is cheap
+		clear(c.decrypted)
+	}
+	c.decrypted[string(encrypted)] = plaintext
+}
+
+type envelope struct {
+	keyID        string
+	encryptedKey []byte
+	// sealed is the nonce followed by the sealed value
+	sealed []byte
+}
+
+func parse(value string) (envelope, error) {
+	if !IsEncrypted(value) {
+		return envelope{}, ErrMalformed
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil || len(b) < 1 {
+		return envelope{}, ErrMalformed
+	}
+
+	n := int(b[0])
+	b = b[1:]
+	if len(b) < n+2 {
+		return envelope{}, ErrMalformed
+	}
+	e := envelope{keyID: string(b[:n])}
+	b = b[n:]
+
+	n = int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < n {
+		return envelope{}, ErrMalformed
+	}
+	e.encryptedKey = b[:n]
+	e.sealed = b[n:]
+	return e, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid data key")
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+BlindIndex builds keys for looking up encrypted values without decrypting them. Each key is an HMAC of the
+value, so equal values get equal keys, but the value can't be recovered from the key without the index key.
+Values should be normalised first, so that values that are meant to match do.
+*/
+type BlindIndex struct {
+	key []byte
+}
+
+// NewBlindIndex returns a blind index keyed with the given secret, which should be at least 32 random bytes
+func NewBlindIndex(key []byte) BlindIndex {
+	return BlindIndex{key: key}
+}
+
+// Key returns the index key of the value. The field name keeps keys of equal values in different fields distinct.
+func (b BlindIndex) Key(field string, value string) string {
+	mac := hmac.New(sha256.New, b.key)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,171 @@
+package pii
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingProvider counts the calls made to the provider it wraps
+type countingProvider struct {
+	KeyProvider
+	generated int
+	decrypted int
+}
+
+func (p *countingProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	p.generated++
+	return p.KeyProvider.GenerateDataKey(ctx)
+}
+
+func (p *countingProvider) DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	p.decrypted++
+	return p.KeyProvider.DecryptDataKey(ctx, keyID, encrypted)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	c := NewCipher(NewKeyFile("k1").Provider())
+
+	encrypted, err := c.Encrypt(ctx, "abc@gmail.com", "user/12345/email")
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(encrypted))
+	assert.NotContains(t, encrypted, "abc@gmail.com")
+
+	decrypted, err := c.Decrypt(ctx, encrypted, "user/12345/email")
+	require.NoError(t, err)
+	assert.Equal(t, "abc@gmail.com", decrypted)
+
+	// Values are bound to where they were encrypted
+	_, err = c.Decrypt(ctx, encrypted, "user/67890/email")
+	assert.Error(t, err)
+
+	again, err := c.Encrypt(ctx, "abc@gmail.com", "user/12345/email")
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted, again)
+
+	for _, malformed := range []string{"", "abc@gmail.com", "pii:v1:", "pii:v1:!!!", encrypted[:len(encrypted)-20]} {
+		_, err = c.Decrypt(ctx, malformed, "user/12345/email")
+		assert.Error(t, err, malformed)
+	}
+}
+
+func TestDataKeyReuse(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := &countingProvider{KeyProvider: NewKeyFile("k1").Provider()}
+	c := NewCipher(p, WithClock(func() time.Time { return now }), WithDataKeyMaxAge(time.Minute))
+
+	values := []string{}
+	for i := 0; i < 3; i++ {
+		v, err := c.Encrypt(ctx, "abc@gmail.com", "binding")
+		require.NoError(t, err)
+		values = append(values, v)
+	}
+	assert.Equal(t, 1, p.generated)
+
+	now = now.Add(time.Minute)
+	_, err := c.Encrypt(ctx, "abc@gmail.com", "binding")
+	require.NoError(t, err)
+	assert.Equal(t, 2, p.generated)
+
+	// Another process decrypts each data key once
+	other := NewCipher(p)
+	for _, v := range values {
+		_, err := other.Decrypt(ctx, v, "binding")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, p.decrypted)
+}
+
+func TestRotation(t *testing.T) {
+	ctx := context.Background()
+	f := NewKeyFile("k1")
+	old, err := NewCipher(f.Provider()).Encrypt(ctx, "abc@gmail.com", "binding")
+	require.NoError(t, err)
+
+	f.Rotate("k2")
+	c := NewCipher(f.Provider())
+	assert.True(t, c.NeedsRotation(old))
+	assert.True(t, c.NeedsRotation("abc@gmail.com"))
+
+	// Old values can still be read
+	decrypted, err := c.Decrypt(ctx, old, "binding")
+	require.NoError(t, err)
+	assert.Equal(t, "abc@gmail.com", decrypted)
+
+	rotated, err := c.Encrypt(ctx, decrypted, "binding")
+	require.NoError(t, err)
+	assert.False(t, c.NeedsRotation(rotated))
+
+	// Once the old key is gone, neither are its values
+	delete(f.Keys, "k1")
+	_, err = NewCipher(f.Provider()).Decrypt(ctx, old, "binding")
+	assert.Error(t, err)
+}
+
+func TestKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	f := NewKeyFile("k1")
+	f.Rotate("k2")
+	require.NoError(t, f.Save(path))
+
+	loaded, err := LoadKeyFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, f, loaded)
+
+	f.Current = "k3"
+	require.NoError(t, f.Save(path))
+	_, err = LoadKeyFile(path)
+	assert.Error(t, err)
+}
+
+func TestBlindIndex(t *testing.T) {
+	b := NewBlindIndex([]byte("index-key"))
+
+	key := b.Key("email", "abc@gmail.com")
+	assert.Equal(t, key, b.Key("email", "abc@gmail.com"))
+	assert.NotContains(t, key, "abc")
+	assert.NotEqual(t, key, b.Key("email", "def@gmail.com"))
+	assert.NotEqual(t, key, b.Key("phone", "abc@gmail.com"))
+	assert.NotEqual(t, key, NewBlindIndex([]byte("other-key")).Key("email", "abc@gmail.com"))
+}
+
+type mockKMSClient struct {
+	master []byte
+}
+
+func (m mockKMSClient) GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	plaintext, encrypted, err := LocalKeyProvider{keys: map[string][]byte{*params.KeyId: m.master}, current: *params.KeyId}.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GenerateDataKeyOutput{Plaintext: plaintext, CiphertextBlob: encrypted, KeyId: params.KeyId}, nil
+}
+
+func (m mockKMSClient) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	plaintext, err := LocalKeyProvider{keys: map[string][]byte{*params.KeyId: m.master}}.DecryptDataKey(ctx, *params.KeyId, params.CiphertextBlob)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.DecryptOutput{Plaintext: plaintext, KeyId: params.KeyId}, nil
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	ctx := context.Background()
+	p := KMSKeyProvider{client: mockKMSClient{master: NewKey()}, keyID: "arn:aws:kms:eu-west-2:123456789012:key/abc"}
+	c := NewCipher(p)
+
+	encrypted, err := c.Encrypt(ctx, "abc@gmail.com", "binding")
+	require.NoError(t, err)
+	assert.False(t, c.NeedsRotation(encrypted))
+
+	decrypted, err := NewCipher(p).Decrypt(ctx, encrypted, "binding")
+	require.NoError(t, err)
+	assert.Equal(t, "abc@gmail.com", decrypted)
+}
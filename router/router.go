@@ -0,0 +1,188 @@
+/*
+Package router routes API Gateway requests to handlers by method and path, so that the whole API can be served
+by a single Lambda behind a proxy resource rather than a Lambda per operation. Requests are passed on with the
+path parameters and resource API Gateway would have given them, so handlers see the same requests whichever way
+the API is deployed.
+*/
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/models"
+	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+)
+
+// Router holds the routes of an API, and is safe to use concurrently once they're all registered
+type Router struct {
+	routes   []route
+	notFound utils.HandlerFunc
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  utils.HandlerFunc
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+/*
+Handle registers the handler for requests with the given method to paths matching the pattern. Patterns are
+paths whose segments may be {name} parameters, each matching any single segment of the path and passed to the
+handler, unescaped, in the request's PathParameters under that name.
+*/
+func (r *Router) Handle(method string, pattern string, handler utils.HandlerFunc) {
+	r.routes = append(r.routes, route{
+		method:   strings.ToUpper(method),
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handler:  handler,
+	})
+}
+
+// NotFound sets the handler for requests to paths matching no pattern, in place of responding with a 404
+func (r *Router) NotFound(handler utils.HandlerFunc) {
+	r.notFound = handler
+}
+
+/*
+Route calls the handler registered for the request's method and path, passing it the request with the path's
+parameters added and its Resource set to the matching pattern, as API Gateway would. Where several patterns
+match a path, the one whose earliest differing segment is static rather than a parameter wins, whatever methods
+they're registered for, as API Gateway picks a resource before its method. Paths matching no pattern are passed
+to the NotFound handler, or responded to with a 404 if there isn't one, and requests with a method the winning
+pattern isn't registered for with a 405 listing the methods it allows in an Allow header. OPTIONS requests to
+paths with a route are CORS preflights, and are answered with a 204 carrying the CORS headers unless a route is
+registered for OPTIONS itself.
+
+The request's Path is expected to be escaped, as API Gateway gives it, so that a parameter containing an
+escaped slash is matched as one segment rather than split across several.
+*/
+func (r *Router) Route(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	path := splitPath(request.Path)
+	method := strings.ToUpper(request.HTTPMethod)
+
+	// The most specific pattern matching the path is found first, so that a static route registered for
+	// another method isn't passed over for a parameter
+	var resource *route
+	for i := range r.routes {
+		rt := &r.routes[i]
+		if _, ok := rt.match(path); !ok {
+			continue
+		}
+		if resource == nil || moreSpecific(rt.segments, resource.segments) {
+			resource = rt
+		}
+	}
+
+	var match *route
+	var params map[string]string
+	allowed := []string{}
+	for i := range r.routes {
+		rt := &r.routes[i]
+		if resource == nil || rt.pattern != resource.pattern {
+			continue
+		}
+		if rt.method != method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+		match = rt
+		params, _ = rt.match(path)
+		break
+	}
+
+	if match == nil && len(allowed) == 0 {
+		if r.notFound != nil {
+			return r.notFound(ctx, request)
+		}
+		err := utils.NewError(404, models.ProblemInvalidPath, fmt.Sprintf("%s %s isn't a route of the API", request.HTTPMethod, request.Path), nil)
+		return utils.ErrorResponse(request, err), nil
+	}
+	if match == nil {
+		allowed = append(allowed, http.MethodOptions)
+		slices.Sort(allowed)
+		allowed = slices.Compact(allowed)
+		if method == http.MethodOptions {
+			return utils.WithHeader(events.APIGatewayProxyResponse{StatusCode: 204, Headers: utils.Headers}, "Allow", strings.Join(allowed, ", ")), nil
+		}
+		err := utils.NewError(405, models.ProblemMethodNotAllowed, fmt.Sprintf("%s only allows %s", request.Path, strings.Join(allowed, ", ")), nil)
+		return utils.WithHeader(utils.ErrorResponse(request, err), "Allow", strings.Join(allowed, ", ")), nil
+	}
+
+	pathParameters := make(map[string]string, len(request.PathParameters)+len(params))
+	for k, v := range request.PathParameters {
+		pathParameters[k] = v
+	}
+	for k, v := range params {
+		pathParameters[k] = v
+	}
+	request.PathParameters = pathParameters
+	request.Resource = match.pattern
+
+	return match.handler(ctx, request)
+}
+
+/*
+match reports whether the route's pattern matches the escaped segments of the path, returning the unescaped
+values of its parameters if so. Segments that aren't validly escaped match nothing.
+*/
+func (rt route) match(path []string) (map[string]string, bool) {
+	if len(path) != len(rt.segments) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, segment := range rt.segments {
+		value, err := url.PathUnescape(path[i])
+		if err != nil {
+			return nil, false
+		}
+		if name, ok := parameter(segment); ok {
+			params[name] = value
+			continue
+		}
+		if segment != value {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// moreSpecific reports whether pattern a should be preferred to pattern b, where both match the same path
+func moreSpecific(a []string, b []string) bool {
+	for i := range a {
+		_, aIsParam := parameter(a[i])
+		_, bIsParam := parameter(b[i])
+		if aIsParam != bIsParam {
+			return bIsParam
+		}
+	}
+	return false
+}
+
+// parameter returns the name of the parameter if the pattern segment is one
+func parameter(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,150 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/models"
+	utils "github.com/benjaminkitson/bk-user-api/utils/lambda"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echo responds with the route that handled the request and the path parameters it was given
+func echo(name string) utils.HandlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		b, err := json.Marshal(map[string]interface{}{
+			"route":    name,
+			"resource": request.Resource,
+			"params":   request.PathParameters,
+		})
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+		return utils.RESPONSE_200(string(b)), nil
+	}
+}
+
+func TestRoute(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/user", echo("list"))
+	r.Handle("POST", "/user", echo("create"))
+	r.Handle("POST", "/user/create", echo("create by RPC"))
+	r.Handle("GET", "/user/{id}", echo("get"))
+	r.Handle("PATCH", "/user/{id}", echo("update"))
+	r.Handle("DELETE", "/user/{id}", echo("delete"))
+	r.Handle("GET", "/user/{id}/history", echo("history"))
+
+	tests := map[string]struct {
+		method         string
+		path           string
+		expectedStatus int
+		expectedRoute  string
+		expectedParams map[string]string
+		expectedAllow  string
+	}{
+		"static":                   {method: "GET", path: "/user", expectedStatus: 200, expectedRoute: "list", expectedParams: map[string]string{}},
+		"by method":                {method: "POST", path: "/user", expectedStatus: 200, expectedRoute: "create", expectedParams: map[string]string{}},
+		"trailing slash":           {method: "GET", path: "/user/", expectedStatus: 200, expectedRoute: "list", expectedParams: map[string]string{}},
+		"parameter":                {method: "GET", path: "/user/12345", expectedStatus: 200, expectedRoute: "get", expectedParams: map[string]string{"id": "12345"}},
+		"nested parameter":         {method: "GET", path: "/user/12345/history", expectedStatus: 200, expectedRoute: "history", expectedParams: map[string]string{"id": "12345"}},
+		"static preferred":         {method: "POST", path: "/user/create", expectedStatus: 200, expectedRoute: "create by RPC", expectedParams: map[string]string{}},
+		"static for other verbs":   {method: "DELETE", path: "/user/create", expectedStatus: 405, expectedAllow: "OPTIONS, POST"},
+		"static for get":           {method: "GET", path: "/user/create", expectedStatus: 405, expectedAllow: "OPTIONS, POST"},
+		"escaped parameter":        {method: "GET", path: "/user/a%2Fb%20c", expectedStatus: 200, expectedRoute: "get", expectedParams: map[string]string{"id": "a/b c"}},
+		"escaped nested parameter": {method: "GET", path: "/user/a%2Fb/history", expectedStatus: 200, expectedRoute: "history", expectedParams: map[string]string{"id": "a/b"}},
+		"invalid escape":           {method: "GET", path: "/user/a%zz", expectedStatus: 404},
+		"lowercase method":         {method: "patch", path: "/user/12345", expectedStatus: 200, expectedRoute: "update", expectedParams: map[string]string{"id": "12345"}},
+		"unknown path":             {method: "GET", path: "/users", expectedStatus: 404},
+		"too deep":                 {method: "GET", path: "/user/12345/history/1", expectedStatus: 404},
+		"unknown method":           {method: "PUT", path: "/user/12345", expectedStatus: 405, expectedAllow: "DELETE, GET, OPTIONS, PATCH"},
+		"unknown method on static": {method: "PUT", path: "/user", expectedStatus: 405, expectedAllow: "GET, OPTIONS, POST"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			res, err := r.Route(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: tt.method, Path: tt.path})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, res.StatusCode)
+			assert.Equal(t, tt.expectedAllow, res.Headers["Allow"])
+
+			if tt.expectedStatus != 200 {
+				var problem models.Problem
+				require.NoError(t, json.Unmarshal([]byte(res.Body), &problem))
+				assert.Equal(t, tt.expectedStatus, problem.Status)
+				return
+			}
+
+			var body struct {
+				Route  string            `json:"route"`
+				Params map[string]string `json:"params"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(res.Body), &body))
+			assert.Equal(t, tt.expectedRoute, body.Route)
+			assert.Equal(t, tt.expectedParams, body.Params)
+		})
+	}
+}
+
+func TestRouteKeepsPathParameters(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/user/{id}", echo("get"))
+
+	res, err := r.Route(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod:     "GET",
+		Path:           "/user/12345",
+		PathParameters: map[string]string{"proxy": "user/12345"},
+	})
+	require.NoError(t, err)
+
+	var body struct {
+		Resource string            `json:"resource"`
+		Params   map[string]string `json:"params"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(res.Body), &body))
+	assert.Equal(t, "/user/{id}", body.Resource)
+	assert.Equal(t, map[string]string{"proxy": "user/12345", "id": "12345"}, body.Params)
+}
+
+func TestRouteNotFound(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/user/{id}", echo("get"))
+	r.NotFound(echo("fallback"))
+
+	res, err := r.Route(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/users"})
+	require.NoError(t, err)
+	assert.Contains(t, res.Body, `"route":"fallback"`)
+
+	// Paths with routes for other methods are still responded to with a 405
+	res, err = r.Route(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "PUT", Path: "/user/12345"})
+	require.NoError(t, err)
+	assert.Equal(t, 405, res.StatusCode)
+}
+
+func TestRoutePreflight(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/user/{id}", echo("get"))
+	r.Handle("DELETE", "/user/{id}", echo("delete"))
+
+	res, err := r.Route(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "OPTIONS", Path: "/user/12345"})
+	require.NoError(t, err)
+	assert.Equal(t, 204, res.StatusCode)
+	assert.Empty(t, res.Body)
+	assert.Equal(t, "DELETE, GET, OPTIONS", res.Headers["Allow"])
+	for k, v := range utils.Headers {
+		assert.Equal(t, v, res.Headers[k])
+	}
+
+	// Paths without a route aren't answered
+	res, err = r.Route(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "OPTIONS", Path: "/users"})
+	require.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+
+	// Nor are paths with a route of their own for OPTIONS
+	r.Handle("OPTIONS", "/user/{id}", echo("options"))
+	res, err = r.Route(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "OPTIONS", Path: "/user/12345"})
+	require.NoError(t, err)
+	assert.Contains(t, res.Body, `"route":"options"`)
+}
@@ -0,0 +1,45 @@
+/*
+Package tenant carries the tenant a request is made on behalf of through the context, so that the stores can
+scope everything they read and write to it. Several products are served from one deployment, and each is a
+tenant with its own users: ids and emails only need to be unique within a tenant, and no tenant can see
+another's users.
+
+Requests that don't name a tenant belong to the default tenant, whose id is empty. Its users are stored exactly
+as they were before tenants were introduced.
+*/
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+type contextKey int
+
+const tenantKey contextKey = iota
+
+// Default is the id of the default tenant
+const Default = ""
+
+// validID matches the ids tenants can be given, which are built into keys and so can't contain a separator
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// WithTenant returns a context carrying the id of the tenant the request is made on behalf of
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey, id)
+}
+
+// FromContext returns the tenant id carried by the context, or the default tenant if there isn't one
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey).(string)
+	return id
+}
+
+// Validate returns an error unless the id is the default tenant or a valid tenant id
+func Validate(id string) error {
+	if id == Default || validID.MatchString(id) {
+		return nil
+	}
+	return fmt.Errorf("invalid tenant id %q: must be up to 63 lowercase letters, digits and hyphens", id)
+}
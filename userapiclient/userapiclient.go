@@ -6,11 +6,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -28,13 +30,46 @@ type HTTPClient struct {
 	requestSigner *v4.Signer
 }
 
+/*
+ClientError is returned when the API responds with an error status. The problem the API responded with is parsed
+into its fields, so callers can branch on Type, which is one of the models.Problem types.
+*/
 type ClientError struct {
+	// Message is the body of the response
 	Message    string
 	StatusCode int
+	Type       string
+	Title      string
+	Detail     string
+	// Instance is the id of the request the problem occurred in
+	Instance string
+	// Errors describes which fields of the request were invalid, for validation problems
+	Errors []models.FieldError
 }
 
 func (e ClientError) Error() string {
-	return e.Message
+	if e.Title == "" {
+		return e.Message
+	}
+	if e.Detail == "" {
+		return e.Title
+	}
+	return e.Title + ": " + e.Detail
+}
+
+// newClientError returns the error for a response with an error status, parsing the problem in its body if it has one
+func newClientError(statusCode int, body []byte) ClientError {
+	e := ClientError{StatusCode: statusCode, Message: string(body)}
+
+	var problem models.Problem
+	if json.Unmarshal(body, &problem) == nil && problem.Type != "" {
+		e.Type = problem.Type
+		e.Title = problem.Title
+		e.Detail = problem.Detail
+		e.Instance = problem.Instance
+		e.Errors = problem.Errors
+	}
+	return e
 }
 
 func NewClient(baseURL string, logger *zap.Logger) (HTTPClient, error) {
@@ -59,102 +94,302 @@ func NewClient(baseURL string, logger *zap.Logger) (HTTPClient, error) {
 	}, nil
 }
 
+// CreateUser creates a user with the given email, with POST /user
 func (c HTTPClient) CreateUser(ctx context.Context, email string) (models.User, error) {
-	r := *c.baseURL
-	r.Path = path.Join(r.Path, "create")
 	bodyMap := map[string]string{"email": email}
 	b, err := json.Marshal(bodyMap)
-	body := bytes.NewReader(b)
 	if err != nil {
 		return models.User{}, err
 	}
 
-	c.logger.Info("building request")
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.String(), body)
+	res, err := c.send(ctx, http.MethodPost, "", nil, b)
 	if err != nil {
 		return models.User{}, err
 	}
+	return unmarshalUser(res)
+}
 
-	creds, err := c.awsConfig.Credentials.Retrieve(ctx)
+// ErrInvalidUserID is returned for ids that can't address a user, such as an empty id
+var ErrInvalidUserID = errors.New("invalid user id")
+
+/*
+userPath returns the path of the user with the given id, relative to the base URL. The id is escaped, so that
+ids containing slashes can't address another route. Ids of . and .. are rejected outright, as they would be
+resolved to another path before reaching the API.
+*/
+func userPath(id string) (string, error) {
+	if id == "" || id == "." || id == ".." {
+		return "", fmt.Errorf("%w: %q", ErrInvalidUserID, id)
+	}
+	return url.PathEscape(id), nil
+}
+
+// GetUser fetches a user by id, with GET /user/{id}
+func (c HTTPClient) GetUser(ctx context.Context, id string) (models.User, error) {
+	p, err := userPath(id)
 	if err != nil {
 		return models.User{}, err
 	}
 
-	h := sha256.Sum256([]byte(b))
-	s := hex.EncodeToString(h[:])
-	c.requestSigner.SignHTTP(ctx, creds, req, s, "execute-api", "eu-west-2", time.Now())
+	res, err := c.send(ctx, http.MethodGet, p, nil, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	return unmarshalUser(res)
+}
 
-	c.logger.Info("sending request")
-	res, err := c.client.Do(req)
+// UpdateUser changes the given fields of a user, with PATCH /user/{id}, returning the updated user
+func (c HTTPClient) UpdateUser(ctx context.Context, id string, changes models.UserUpdate) (models.User, error) {
+	p, err := userPath(id)
 	if err != nil {
-		c.logger.Error("request error")
 		return models.User{}, err
 	}
-	if res.StatusCode == 200 {
-		c.logger.Info("request success")
-		var u models.User
-		err = json.NewDecoder(res.Body).Decode(&u)
-		if err != nil {
-			return models.User{}, err
-		}
-		return u, err
+
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return models.User{}, err
 	}
-	bodyRes, _ := io.ReadAll(res.Body)
-	if res.StatusCode == 400 || res.StatusCode == 500 {
-		c.logger.Error("error status code received", zap.Int("statusCode", res.StatusCode))
-		return models.User{}, ClientError{StatusCode: res.StatusCode, Message: string(bodyRes)}
+
+	res, err := c.send(ctx, http.MethodPatch, p, nil, b)
+	if err != nil {
+		return models.User{}, err
 	}
-	err = fmt.Errorf("api responded with unexpected status code %d, with body %s", res.StatusCode, string(bodyRes))
-	return models.User{}, err
+	return unmarshalUser(res)
 }
 
-// TODO: convert this to use the DELETE method
+// DeleteUser deletes a user by id, with DELETE /user/{id}, returning the id of the deleted user
 func (c HTTPClient) DeleteUser(ctx context.Context, id string) (string, error) {
-	r := *c.baseURL
-	r.Path = path.Join(r.Path, "delete")
-	bodyMap := map[string]string{"id": id}
-	b, err := json.Marshal(bodyMap)
-	body := bytes.NewReader(b)
+	p, err := userPath(id)
 	if err != nil {
 		return "", err
 	}
 
-	c.logger.Info("building request")
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.String(), body)
+	res, err := c.send(ctx, http.MethodDelete, p, nil, nil)
 	if err != nil {
 		return "", err
 	}
 
-	creds, err := c.awsConfig.Credentials.Retrieve(ctx)
+	var body map[string]string
+	err = json.Unmarshal(res, &body)
 	if err != nil {
 		return "", err
 	}
+	return body["id"], nil
+}
+
+func unmarshalUser(b []byte) (models.User, error) {
+	var u models.User
+	err := json.Unmarshal(b, &u)
+	if err != nil {
+		return models.User{}, err
+	}
+	return u, nil
+}
+
+// MaxBatchSize is the maximum number of users the API accepts in a single batch request
+const MaxBatchSize = 100
+
+type batchResponse struct {
+	Results []models.BatchUserResult `json:"results"`
+}
+
+/*
+BatchCreateUsers creates users in bulk, sending them in requests of at most MaxBatchSize users. Users may be
+given with existing ids, which must be UUIDs, otherwise one is generated, but users whose id is already taken
+aren't created. A result is returned for each user in the order they were given, describing whether that user
+was created.
+*/
+func (c HTTPClient) BatchCreateUsers(ctx context.Context, users []models.User) ([]models.BatchUserResult, error) {
+	results := make([]models.BatchUserResult, 0, len(users))
+	for start := 0; start < len(users); start += MaxBatchSize {
+		chunk := users[start:min(start+MaxBatchSize, len(users))]
+		b, err := json.Marshal(map[string][]models.User{"users": chunk})
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := c.sendBatch(ctx, "create/batch", b, len(chunk))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r...)
+	}
+	return results, nil
+}
 
-	h := sha256.Sum256([]byte(b))
+// BatchGetUsers fetches users in bulk, returning a result for each id in the order they were given
+func (c HTTPClient) BatchGetUsers(ctx context.Context, ids []string) ([]models.BatchUserResult, error) {
+	results := make([]models.BatchUserResult, 0, len(ids))
+	for start := 0; start < len(ids); start += MaxBatchSize {
+		chunk := ids[start:min(start+MaxBatchSize, len(ids))]
+		b, err := json.Marshal(map[string][]string{"ids": chunk})
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := c.sendBatch(ctx, "get/batch", b, len(chunk))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r...)
+	}
+	return results, nil
+}
+
+func (c HTTPClient) sendBatch(ctx context.Context, p string, b []byte, size int) ([]models.BatchUserResult, error) {
+	res, err := c.send(ctx, http.MethodPost, p, nil, b)
+	if err != nil {
+		return nil, err
+	}
+
+	var r batchResponse
+	err = json.Unmarshal(res, &r)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Results) != size {
+		return nil, fmt.Errorf("expected %d batch results, got %d", size, len(r.Results))
+	}
+	return r.Results, nil
+}
+
+// ListUsersPage fetches a single page of users. An empty cursor fetches the first page.
+func (c HTTPClient) ListUsersPage(ctx context.Context, limit int, cursor string) (models.UserPage, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+
+	res, err := c.send(ctx, http.MethodGet, "", query, nil)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+
+	var p models.UserPage
+	err = json.Unmarshal(res, &p)
+	if err != nil {
+		return models.UserPage{}, err
+	}
+	return p, nil
+}
+
+// ListUsers returns an iterator over all users, fetching pages of the given size as required
+func (c HTTPClient) ListUsers(pageSize int) *UserIterator {
+	return &UserIterator{
+		client:   c,
+		pageSize: pageSize,
+	}
+}
+
+/*
+UserIterator lazily pages through users. Call Next to advance to the next user, which can then be accessed
+with User. Once Next returns false, Err reports any error that stopped the iteration.
+
+	it := client.ListUsers(50)
+	for it.Next(ctx) {
+		u := it.User()
+	}
+	if err := it.Err(); err != nil {
+		...
+	}
+*/
+type UserIterator struct {
+	client   HTTPClient
+	pageSize int
+	cursor   string
+	page     []models.User
+	current  models.User
+	started  bool
+	err      error
+}
+
+func (it *UserIterator) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if it.err != nil || (it.started && it.cursor == "") {
+			return false
+		}
+
+		p, err := it.client.ListUsersPage(ctx, it.pageSize, it.cursor)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.started = true
+		it.page = p.Users
+		it.cursor = p.Cursor
+	}
+
+	it.current = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// User returns the user the iterator is currently at
+func (it *UserIterator) User() models.User {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+/*
+Signs and sends a request to the given path relative to the base URL, returning the response body. The path
+must already be escaped. A ClientError is returned for error status codes.
+*/
+func (c HTTPClient) send(ctx context.Context, method string, p string, query url.Values, b []byte) ([]byte, error) {
+	r := *c.baseURL
+	if p != "" {
+		r.RawPath = strings.TrimSuffix(r.EscapedPath(), "/") + "/" + p
+		var err error
+		r.Path, err = url.PathUnescape(r.RawPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.RawQuery = query.Encode()
+
+	c.logger.Info("building request")
+	req, err := http.NewRequestWithContext(ctx, method, r.String(), bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := c.awsConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.Sum256(b)
 	s := hex.EncodeToString(h[:])
-	c.requestSigner.SignHTTP(ctx, creds, req, s, "execute-api", "eu-west-2", time.Now())
+	err = c.requestSigner.SignHTTP(ctx, creds, req, s, "execute-api", "eu-west-2", time.Now())
+	if err != nil {
+		return nil, err
+	}
 
 	c.logger.Info("sending request")
 	res, err := c.client.Do(req)
 	if err != nil {
 		c.logger.Error("request error")
-		return "", err
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	bodyRes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
 	}
 	if res.StatusCode == 200 {
 		c.logger.Info("request success")
-		var u models.User
-		err = json.NewDecoder(res.Body).Decode(&u)
-		if err != nil {
-			return "", err
-		}
-		// TODO: get ID from user struct
-		return id, err
+		return bodyRes, nil
 	}
-	bodyRes, _ := io.ReadAll(res.Body)
-	if res.StatusCode == 400 || res.StatusCode == 500 {
+	if res.StatusCode >= 400 {
 		c.logger.Error("error status code received", zap.Int("statusCode", res.StatusCode))
-		return "", ClientError{StatusCode: res.StatusCode, Message: string(bodyRes)}
+		return nil, newClientError(res.StatusCode, bodyRes)
 	}
-	err = fmt.Errorf("api responded with unexpected status code %d, with body %s", res.StatusCode, string(bodyRes))
-	return "", err
+	return nil, fmt.Errorf("api responded with unexpected status code %d, with body %s", res.StatusCode, string(bodyRes))
 }
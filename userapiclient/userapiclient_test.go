@@ -1,3 +1,280 @@
 package userapiclient
 
-// TODO
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func NewTestClient(t *testing.T, handler http.HandlerFunc) HTTPClient {
+	t.Setenv("AWS_ACCESS_KEY_ID", "fake")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "fake")
+	t.Setenv("AWS_REGION", "eu-west-2")
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	c, err := NewClient(server.URL+"/user", zap.NewNop())
+	require.NoError(t, err)
+	return c
+}
+
+func TestListUsers(t *testing.T) {
+	pages := map[string]models.UserPage{
+		"": {
+			Users:  []models.User{{UserID: "1"}, {UserID: "2"}},
+			Cursor: "second",
+		},
+		"second": {
+			Users:  []models.User{},
+			Cursor: "third",
+		},
+		"third": {
+			Users: []models.User{{UserID: "3"}},
+		},
+	}
+
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/user", r.URL.Path)
+		assert.Equal(t, "2", r.URL.Query().Get("limit"))
+		assert.NotEmpty(t, r.Header.Get("Authorization"))
+
+		p, ok := pages[r.URL.Query().Get("cursor")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(p)
+	})
+
+	ids := []string{}
+	it := c.ListUsers(2)
+	for it.Next(context.Background()) {
+		ids = append(ids, it.User().UserID)
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{"1", "2", "3"}, ids)
+}
+
+func TestListUsersError(t *testing.T) {
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", models.ProblemContentType)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("{\"type\": \"/problems/internal\", \"title\": \"Something went wrong!\", \"status\": 500, \"instance\": \"abc-123\"}"))
+	})
+
+	it := c.ListUsers(2)
+	assert.False(t, it.Next(context.Background()))
+
+	var ce ClientError
+	require.ErrorAs(t, it.Err(), &ce)
+	assert.Equal(t, http.StatusInternalServerError, ce.StatusCode)
+	assert.Equal(t, models.ProblemInternal, ce.Type)
+	assert.Equal(t, "abc-123", ce.Instance)
+}
+
+func TestValidationError(t *testing.T) {
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", models.ProblemContentType)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.Problem{
+			Type:   models.ProblemValidation,
+			Title:  "Invalid request",
+			Status: http.StatusBadRequest,
+			Detail: "The request has invalid fields",
+			Errors: []models.FieldError{{Field: "limit", Message: "must be a number between 1 and 100"}},
+		})
+	})
+
+	it := c.ListUsers(200)
+	assert.False(t, it.Next(context.Background()))
+
+	var ce ClientError
+	require.ErrorAs(t, it.Err(), &ce)
+	assert.Equal(t, models.ProblemValidation, ce.Type)
+	assert.Equal(t, []models.FieldError{{Field: "limit", Message: "must be a number between 1 and 100"}}, ce.Errors)
+	assert.Equal(t, "Invalid request: The request has invalid fields", ce.Error())
+}
+
+func TestNonProblemError(t *testing.T) {
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("{\"message\": \"Internal server error\"}"))
+	})
+
+	it := c.ListUsers(2)
+	assert.False(t, it.Next(context.Background()))
+
+	var ce ClientError
+	require.ErrorAs(t, it.Err(), &ce)
+	assert.Equal(t, http.StatusBadGateway, ce.StatusCode)
+	assert.Empty(t, ce.Type)
+	assert.Equal(t, "{\"message\": \"Internal server error\"}", ce.Error())
+}
+
+func TestBatchCreateUsers(t *testing.T) {
+	requests := 0
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/user/create/batch", r.URL.Path)
+		requests++
+
+		body := map[string][]models.User{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.LessOrEqual(t, len(body["users"]), MaxBatchSize)
+
+		results := []models.BatchUserResult{}
+		for _, u := range body["users"] {
+			u := u
+			results = append(results, models.BatchUserResult{User: &u, Status: 200})
+		}
+		json.NewEncoder(w).Encode(map[string][]models.BatchUserResult{"results": results})
+	})
+
+	users := []models.User{}
+	for i := 0; i < 250; i++ {
+		users = append(users, models.User{UserID: strconv.Itoa(i), Email: fmt.Sprintf("user%d@gmail.com", i)})
+	}
+
+	results, err := c.BatchCreateUsers(context.Background(), users)
+	require.NoError(t, err)
+	assert.Equal(t, 3, requests)
+	require.Len(t, results, len(users))
+	for i, r := range results {
+		assert.Equal(t, users[i].UserID, r.User.UserID)
+	}
+}
+
+func TestBatchGetUsers(t *testing.T) {
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/user/get/batch", r.URL.Path)
+		json.NewEncoder(w).Encode(map[string][]models.BatchUserResult{"results": {
+			{User: &models.User{UserID: "1"}, Status: 200},
+			{Status: 404, Error: "user not found"},
+		}})
+	})
+
+	results, err := c.BatchGetUsers(context.Background(), []string{"1", "2"})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "1", results[0].User.UserID)
+	assert.Equal(t, 404, results[1].Status)
+}
+
+func TestCreateUser(t *testing.T) {
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/user", r.URL.Path)
+
+		body := map[string]string{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		json.NewEncoder(w).Encode(models.User{UserID: "12345", Email: body["email"], Version: 1})
+	})
+
+	u, err := c.CreateUser(context.Background(), "abc@gmail.com")
+	require.NoError(t, err)
+	assert.Equal(t, "abc@gmail.com", u.Email)
+	assert.Equal(t, int64(1), u.Version)
+}
+
+func TestGetUser(t *testing.T) {
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/user/12345", r.URL.Path)
+		json.NewEncoder(w).Encode(models.User{UserID: "12345", Email: "abc@gmail.com", Version: 3})
+	})
+
+	u, err := c.GetUser(context.Background(), "12345")
+	require.NoError(t, err)
+	assert.Equal(t, "12345", u.UserID)
+	assert.Equal(t, int64(3), u.Version)
+}
+
+func TestGetUserNotFound(t *testing.T) {
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", models.ProblemContentType)
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(models.Problem{Type: models.ProblemNotFound, Title: "Not found", Status: http.StatusNotFound})
+	})
+
+	_, err := c.GetUser(context.Background(), "missing")
+	var ce ClientError
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, models.ProblemNotFound, ce.Type)
+}
+
+func TestUpdateUser(t *testing.T) {
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPatch, r.Method)
+		assert.Equal(t, "/user/12345", r.URL.Path)
+
+		var changes models.UserUpdate
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&changes))
+		require.NotNil(t, changes.Email)
+		json.NewEncoder(w).Encode(models.User{UserID: "12345", Email: *changes.Email, Version: 2})
+	})
+
+	email := "new@gmail.com"
+	u, err := c.UpdateUser(context.Background(), "12345", models.UserUpdate{Email: &email})
+	require.NoError(t, err)
+	assert.Equal(t, "new@gmail.com", u.Email)
+}
+
+func TestDeleteUser(t *testing.T) {
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		assert.Equal(t, "/user/12345", r.URL.Path)
+		w.Write([]byte("{\"id\": \"12345\"}"))
+	})
+
+	id, err := c.DeleteUser(context.Background(), "12345")
+	require.NoError(t, err)
+	assert.Equal(t, "12345", id)
+}
+
+func TestUserIDsAreEscaped(t *testing.T) {
+	paths := []string{}
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		if r.Method == http.MethodDelete {
+			w.Write([]byte("{\"id\": \"12345\"}"))
+			return
+		}
+		json.NewEncoder(w).Encode(models.User{UserID: "12345"})
+	})
+
+	// Ids can't address other routes, or other users' paths
+	_, err := c.GetUser(context.Background(), "x/history")
+	require.NoError(t, err)
+	email := "new@gmail.com"
+	_, err = c.UpdateUser(context.Background(), "../foo", models.UserUpdate{Email: &email})
+	require.NoError(t, err)
+	_, err = c.DeleteUser(context.Background(), "a b?c")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"/user/x%2Fhistory", "/user/..%2Ffoo", "/user/a%20b%3Fc"}, paths)
+}
+
+func TestInvalidUserIDs(t *testing.T) {
+	c := NewTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+	})
+
+	for _, id := range []string{"", ".", ".."} {
+		_, err := c.GetUser(context.Background(), id)
+		assert.ErrorIs(t, err, ErrInvalidUserID)
+		_, err = c.UpdateUser(context.Background(), id, models.UserUpdate{})
+		assert.ErrorIs(t, err, ErrInvalidUserID)
+		_, err = c.DeleteUser(context.Background(), id)
+		assert.ErrorIs(t, err, ErrInvalidUserID)
+	}
+}
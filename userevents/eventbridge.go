@@ -0,0 +1,66 @@
+package userevents
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/pkg/errors"
+)
+
+// EventBusEnv names the environment variable holding the name of the bus events are published to
+const EventBusEnv = "EVENT_BUS_NAME"
+
+type eventBridgeClient interface {
+	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+/*
+EventBridgePublisher publishes events to an EventBridge bus, with the event type as the detail type and the
+whole envelope as the detail.
+*/
+type EventBridgePublisher struct {
+	client  eventBridgeClient
+	busName string
+}
+
+var _ Publisher = EventBridgePublisher{}
+
+func NewEventBridgePublisher(client *eventbridge.Client, busName string) EventBridgePublisher {
+	return EventBridgePublisher{
+		client:  client,
+		busName: busName,
+	}
+}
+
+func (p EventBridgePublisher) Publish(ctx context.Context, event Event) error {
+	detail, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "an error ocurred marshaling the event")
+	}
+
+	out, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []types.PutEventsRequestEntry{
+			{
+				EventBusName: aws.String(p.busName),
+				Source:       aws.String(event.Source),
+				DetailType:   aws.String(string(event.Type)),
+				Detail:       aws.String(string(detail)),
+				Time:         aws.Time(event.Time),
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to publish event %s", event.ID)
+	}
+
+	// PutEvents succeeds even when its entries don't
+	if out.FailedEntryCount > 0 {
+		entry := out.Entries[0]
+		return errors.Errorf("failed to publish event %s: %s: %s", event.ID, aws.ToString(entry.ErrorCode), aws.ToString(entry.ErrorMessage))
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package userevents
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MemoryPublisher keeps the events it is given, for tests
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+var _ Publisher = (*MemoryPublisher)(nil)
+
+func (p *MemoryPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return nil
+}
+
+// Events returns the events published so far, in the order they were published
+func (p *MemoryPublisher) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Event{}, p.events...)
+}
+
+/*
+FilePublisher writes each event as a line of JSON, which is handy for tests and for inspecting events when
+running locally. It is usually given an *os.File opened for appending.
+*/
+type FilePublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var _ Publisher = (*FilePublisher)(nil)
+
+func NewFilePublisher(w io.Writer) *FilePublisher {
+	return &FilePublisher{w: w}
+}
+
+func (p *FilePublisher) Publish(ctx context.Context, event Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "an error ocurred marshaling the event")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,64 @@
+/*
+Package userevents defines the events published when users are created, changed or deleted, and the publishers
+that deliver them. Events are versioned envelopes, so that consumers can tell when the shape of the user they
+carry has changed.
+*/
+package userevents
+
+import (
+	"context"
+	"time"
+
+	"github.com/benjaminkitson/bk-user-api/models"
+)
+
+// SchemaVersion is the version of the event envelope, and of the user it carries
+const SchemaVersion = 1
+
+// Source identifies the user API as the publisher of an event
+const Source = "bk-user-api"
+
+type Type string
+
+const (
+	UserCreated Type = "UserCreated"
+	UserUpdated Type = "UserUpdated"
+	UserDeleted Type = "UserDeleted"
+)
+
+// Event is the envelope of a single user lifecycle event
+type Event struct {
+	// ID uniquely identifies the event, so that consumers can drop duplicates
+	ID            string    `json:"id"`
+	Type          Type      `json:"type"`
+	SchemaVersion int       `json:"schemaVersion"`
+	Source        string    `json:"source"`
+	Time          time.Time `json:"time"`
+	UserID        string    `json:"userID"`
+	// TenantID is the tenant the user belongs to, which is empty for the default tenant
+	TenantID string `json:"tenantID,omitempty"`
+	// User is the user after the change, or as they were when deleted
+	User models.User `json:"user"`
+	// Previous is the user before the change, which is set unless the user was created or removed outright
+	Previous *models.User `json:"previous,omitempty"`
+}
+
+// New returns an event of the given type for the change from previous to user
+func New(id string, t Type, at time.Time, user models.User, previous *models.User) Event {
+	return Event{
+		ID:            id,
+		Type:          t,
+		SchemaVersion: SchemaVersion,
+		Source:        Source,
+		Time:          at.UTC(),
+		UserID:        user.UserID,
+		TenantID:      user.TenantID,
+		User:          user,
+		Previous:      previous,
+	}
+}
+
+// Publisher delivers events to whoever is listening for them
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
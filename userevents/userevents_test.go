@@ -0,0 +1,92 @@
+package userevents
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents() []Event {
+	at := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	created := models.User{UserID: "12345", Email: "abc@gmail.com", Version: 1, CreatedAt: at, UpdatedAt: at}
+	updated := created
+	updated.Email = "def@gmail.com"
+	updated.Version = 2
+	return []Event{
+		New("1", UserCreated, at, created, nil),
+		New("2", UserUpdated, at, updated, &created),
+	}
+}
+
+func TestFilePublisher(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewFilePublisher(&buf)
+
+	events := testEvents()
+	for _, e := range events {
+		require.NoError(t, p.Publish(context.Background(), e))
+	}
+
+	read := []Event{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		read = append(read, e)
+	}
+	assert.Equal(t, events, read)
+}
+
+func TestMemoryPublisher(t *testing.T) {
+	p := &MemoryPublisher{}
+	events := testEvents()
+	for _, e := range events {
+		require.NoError(t, p.Publish(context.Background(), e))
+	}
+	assert.Equal(t, events, p.Events())
+}
+
+type mockEventBridge struct {
+	input  *eventbridge.PutEventsInput
+	failed bool
+}
+
+func (m *mockEventBridge) PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	m.input = params
+	if m.failed {
+		return &eventbridge.PutEventsOutput{
+			FailedEntryCount: 1,
+			Entries:          []types.PutEventsResultEntry{{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("oops")}},
+		}, nil
+	}
+	return &eventbridge.PutEventsOutput{Entries: []types.PutEventsResultEntry{{EventId: aws.String("abc")}}}, nil
+}
+
+func TestEventBridgePublisher(t *testing.T) {
+	client := &mockEventBridge{}
+	p := EventBridgePublisher{client: client, busName: "users"}
+	e := testEvents()[1]
+
+	require.NoError(t, p.Publish(context.Background(), e))
+	require.Len(t, client.input.Entries, 1)
+	entry := client.input.Entries[0]
+	assert.Equal(t, "users", aws.ToString(entry.EventBusName))
+	assert.Equal(t, Source, aws.ToString(entry.Source))
+	assert.Equal(t, "UserUpdated", aws.ToString(entry.DetailType))
+	var detail Event
+	require.NoError(t, json.Unmarshal([]byte(aws.ToString(entry.Detail)), &detail))
+	assert.Equal(t, e, detail)
+
+	client.failed = true
+	require.ErrorContains(t, p.Publish(context.Background(), e), "InternalFailure")
+}
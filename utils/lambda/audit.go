@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/audit"
+)
+
+// WithAudit returns a context carrying the request's actor and id, for the stores to record against their changes
+func WithAudit(ctx context.Context, request events.APIGatewayProxyRequest) context.Context {
+	ctx = audit.WithRequestID(ctx, request.RequestContext.RequestID)
+	return audit.WithActor(ctx, Actor(request))
+}
+
+/*
+Actor identifies who made the request: the subject of a Cognito user pool token if the route has an authorizer,
+otherwise the principal of a custom authorizer or the IAM identity the request was signed with. It is empty for
+anonymous requests.
+*/
+func Actor(request events.APIGatewayProxyRequest) string {
+	authorizer := request.RequestContext.Authorizer
+	if claims, ok := authorizer["claims"].(map[string]interface{}); ok {
+		if sub, ok := claims["sub"]; ok {
+			return fmt.Sprint(sub)
+		}
+	}
+	if principal, ok := authorizer["principalId"]; ok {
+		return fmt.Sprint(principal)
+	}
+
+	identity := request.RequestContext.Identity
+	if identity.CognitoIdentityID != "" {
+		return identity.CognitoIdentityID
+	}
+	return identity.UserArn
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActor(t *testing.T) {
+	tests := map[string]struct {
+		context  events.APIGatewayProxyRequestContext
+		expected string
+	}{
+		"anonymous": {},
+		"cognito user pool": {
+			context: events.APIGatewayProxyRequestContext{
+				Authorizer: map[string]interface{}{"claims": map[string]interface{}{"sub": "abc-123"}},
+				Identity:   events.APIGatewayRequestIdentity{UserArn: "arn:aws:iam::123456789012:user/ignored"},
+			},
+			expected: "abc-123",
+		},
+		"custom authorizer": {
+			context:  events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"principalId": "service"}},
+			expected: "service",
+		},
+		"iam": {
+			context:  events.APIGatewayProxyRequestContext{Identity: events.APIGatewayRequestIdentity{UserArn: "arn:aws:iam::123456789012:user/admin"}},
+			expected: "arn:aws:iam::123456789012:user/admin",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Actor(events.APIGatewayProxyRequest{RequestContext: tt.context}))
+		})
+	}
+}
+
+func TestWithAudit(t *testing.T) {
+	request := events.APIGatewayProxyRequest{
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID:  "request-1",
+			Authorizer: map[string]interface{}{"principalId": "service"},
+		},
+	}
+
+	ctx := WithAudit(context.Background(), request)
+	assert.Equal(t, "request-1", audit.RequestID(ctx))
+	assert.Equal(t, "service", audit.Actor(ctx))
+}
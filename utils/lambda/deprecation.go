@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/*
+RPCRoutesDeprecatedAt is when the RPC-style routes, such as POST /user/get, were deprecated in favour of routes
+that address users by path. They're kept working for a transition period, with a Deprecation header telling
+callers to move off them.
+*/
+var RPCRoutesDeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+/*
+Deprecated adapts a handler for a deprecated route, adding an RFC 9745 Deprecation header giving the date the
+route was deprecated to every response, including error responses, so it should wrap the handler Wrap returns.
+*/
+func Deprecated(fn HandlerFunc, deprecatedAt time.Time) HandlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		response, err := fn(ctx, request)
+		return WithHeader(response, "Deprecation", fmt.Sprintf("@%d", deprecatedAt.Unix())), err
+	}
+}
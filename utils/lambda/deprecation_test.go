@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestDeprecated(t *testing.T) {
+	deprecatedAt := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+	ok := Deprecated(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return RESPONSE_200("{}"), nil
+	}, deprecatedAt)
+	r, err := ok(context.Background(), events.APIGatewayProxyRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "@1792281600", r.Headers["Deprecation"])
+
+	// Error responses are marked too, when the handler is wrapped
+	failed := Deprecated(Wrap(zap.NewNop(), func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{}, userstore.ErrNotFound
+	}), deprecatedAt)
+	r, err = failed(context.Background(), events.APIGatewayProxyRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, 404, r.StatusCode)
+	assert.Equal(t, "@1792281600", r.Headers["Deprecation"])
+
+	// The shared headers aren't changed
+	assert.NotContains(t, Headers, "Deprecation")
+}
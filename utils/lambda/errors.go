@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/pkg/errors"
+)
+
+/*
+Error is an error carrying the problem it should be reported to the caller as. Handlers return one when the
+problem can't be worked out from the error alone, such as for invalid input, and wrap the cause if there is one.
+*/
+type Error struct {
+	Status int
+	// Type is one of the models.Problem types
+	Type string
+	// Detail explains the problem to the caller
+	Detail string
+	// Fields describes which fields of the request were invalid, and why
+	Fields []models.FieldError
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError returns an error reported as a problem of the given status and type, caused by err if it isn't nil
+func NewError(status int, problemType string, detail string, err error) *Error {
+	return &Error{Status: status, Type: problemType, Detail: detail, Err: err}
+}
+
+// ValidationError returns a 400 error describing the invalid fields of a request
+func ValidationError(fields ...models.FieldError) *Error {
+	return &Error{Status: 400, Type: models.ProblemValidation, Detail: "The request has invalid fields", Fields: fields}
+}
+
+// problemTitles holds the title of each type of problem
+var problemTitles = map[string]string{
+	models.ProblemValidation:         "Invalid request",
+	models.ProblemMalformedRequest:   "Malformed request",
+	models.ProblemInvalidPath:        "Invalid path",
+	models.ProblemMethodNotAllowed:   "Method not allowed",
+	models.ProblemInvalidTenant:      "Invalid tenant",
+	models.ProblemForbidden:          "Forbidden",
+	models.ProblemNotFound:           "Not found",
+	models.ProblemConflict:           "Conflict",
+	models.ProblemEmailTaken:         "Email address is already in use",
+	models.ProblemPreconditionFailed: "Precondition failed",
+	models.ProblemUnavailable:        "Service unavailable",
+	models.ProblemInternal:           "Something went wrong!",
+}
+
+// toError returns the Error that err should be reported as
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	switch {
+	case errors.Is(err, ErrInvalidTenant):
+		return NewError(400, models.ProblemInvalidTenant, err.Error(), err)
+	case errors.Is(err, ErrTenantForbidden):
+		return NewError(403, models.ProblemForbidden, "The caller can't act on behalf of the tenant", err)
+	case errors.Is(err, userstore.ErrInvalidCursor):
+		e := ValidationError(models.FieldError{Field: "cursor", Message: "is not a valid cursor"})
+		e.Err = err
+		return e
+	case errors.Is(err, userstore.ErrValidation):
+		return NewError(400, models.ProblemValidation, "The request was rejected by the store", err)
+	case errors.Is(err, userstore.ErrNotFound):
+		return NewError(404, models.ProblemNotFound, "The user doesn't exist", err)
+	case errors.Is(err, userstore.ErrEmailTaken):
+		return NewError(409, models.ProblemEmailTaken, "The email address belongs to another user", err)
+	case errors.Is(err, userstore.ErrNotDeleted):
+		return NewError(409, models.ProblemConflict, "The user isn't deleted", err)
+	case errors.Is(err, userstore.ErrConflict):
+		return NewError(409, models.ProblemConflict, "The user was changed by another request", err)
+	case errors.Is(err, userstore.ErrThrottled), errors.Is(err, userstore.ErrTimeout):
+		return NewError(503, models.ProblemUnavailable, "The service is out of capacity, please retry", err)
+	default:
+		return NewError(500, models.ProblemInternal, "", err)
+	}
+}
+
+// ErrorStatus returns the HTTP status code corresponding to an error from a handler or the user store
+func ErrorStatus(err error) int {
+	if err == nil {
+		return 200
+	}
+	return toError(err).Status
+}
+
+/*
+ErrorResponse returns the problem+json response to a request that failed with the given error. Errors from the
+user store are mapped to the problem matching their kind, and anything unrecognised is a 500. 503s ask the
+caller to back off for RetryAfterSeconds before retrying.
+*/
+func ErrorResponse(request events.APIGatewayProxyRequest, err error) events.APIGatewayProxyResponse {
+	e := toError(err)
+	problem := models.Problem{
+		Type:     e.Type,
+		Title:    problemTitles[e.Type],
+		Status:   e.Status,
+		Detail:   e.Detail,
+		Instance: request.RequestContext.RequestID,
+		Errors:   e.Fields,
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(e.Status)
+	}
+
+	headers := withHeader(Headers, "Content-Type", models.ProblemContentType)
+	if e.Status == 503 {
+		headers = withHeader(headers, "Retry-After", strconv.Itoa(RetryAfterSeconds))
+	}
+
+	// A problem only holds strings and numbers, so can always be marshaled
+	b, _ := json.Marshal(problem)
+	return events.APIGatewayProxyResponse{
+		StatusCode: problem.Status,
+		Headers:    headers,
+		Body:       string(b),
+	}
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorResponse(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected int
+	}{
+		"not found":   {err: userstore.ErrNotFound, expected: 404},
+		"conflict":    {err: errors.Wrap(userstore.ErrConflict, "put"), expected: 409},
+		"not deleted": {err: errors.Wrap(userstore.ErrNotDeleted, "restore"), expected: 409},
+		"throttled":   {err: errors.Wrap(userstore.ErrThrottled, "query"), expected: 503},
+		"timeout":     {err: userstore.ErrTimeout, expected: 503},
+		"validation":  {err: userstore.ErrValidation, expected: 400},
+		"cursor":      {err: errors.Wrap(userstore.ErrInvalidCursor, "list"), expected: 400},
+		"tenant":      {err: ErrInvalidTenant, expected: 400},
+		"forbidden":   {err: ErrTenantForbidden, expected: 403},
+		"typed":       {err: errors.Wrap(PreconditionFailed(userstore.ErrConflict), "delete"), expected: 412},
+		"other":       {err: errors.New("something else"), expected: 500},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := ErrorResponse(events.APIGatewayProxyRequest{}, tt.err)
+			assert.Equal(t, models.ProblemContentType, r.Headers["Content-Type"])
+			assert.Equal(t, tt.expected, r.StatusCode)
+			if tt.expected == 503 {
+				assert.Equal(t, "1", r.Headers["Retry-After"])
+			}
+		})
+	}
+
+	// The shared headers aren't changed by responses that add their own
+	assert.NotContains(t, Headers, "Retry-After")
+}
+
+func TestErrorResponseProblem(t *testing.T) {
+	request := events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{RequestID: "abc-123"}}
+
+	r := ErrorResponse(request, ValidationError(models.FieldError{Field: "email", Message: "is required"}))
+	assert.Equal(t, 400, r.StatusCode)
+	assert.JSONEq(t, `{
+		"type": "/problems/validation",
+		"title": "Invalid request",
+		"status": 400,
+		"detail": "The request has invalid fields",
+		"instance": "abc-123",
+		"errors": [{"field": "email", "message": "is required"}]
+	}`, r.Body)
+
+	r = ErrorResponse(request, errors.Wrap(userstore.ErrNotFound, "get"))
+	assert.JSONEq(t, `{
+		"type": "/problems/not-found",
+		"title": "Not found",
+		"status": 404,
+		"detail": "The user doesn't exist",
+		"instance": "abc-123"
+	}`, r.Body)
+
+	// The cause of unrecognised errors isn't shared with the caller
+	r = ErrorResponse(request, errors.New("connection refused"))
+	assert.JSONEq(t, `{
+		"type": "/problems/internal",
+		"title": "Something went wrong!",
+		"status": 500,
+		"instance": "abc-123"
+	}`, r.Body)
+}
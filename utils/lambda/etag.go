@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/models"
+)
+
+// ETag formats a record version as a strong entity tag
+func ETag(version int64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// WithETag returns a copy of the response with an ETag header for the given record version
+func WithETag(response events.APIGatewayProxyResponse, version int64) events.APIGatewayProxyResponse {
+	return WithHeader(response, "ETag", ETag(version))
+}
+
+/*
+IfMatchVersion returns the record version from the request's If-Match header. A version of zero is returned
+if the header is absent or is "*", meaning the write should not be conditional on the version, and a 400 error
+if it isn't a version.
+*/
+func IfMatchVersion(request events.APIGatewayProxyRequest) (int64, error) {
+	value := Header(request, "If-Match")
+
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+
+	value = strings.TrimPrefix(value, "W/")
+	version, err := strconv.ParseInt(strings.Trim(value, "\""), 10, 64)
+	if err != nil || version < 1 {
+		e := ValidationError(models.FieldError{Field: "If-Match", Message: "must be a quoted version or *"})
+		e.Err = fmt.Errorf("invalid If-Match header %q", value)
+		return 0, e
+	}
+
+	return version, nil
+}
+
+// PreconditionFailed returns the error reported when a write conditional on the If-Match version lost to another write
+func PreconditionFailed(err error) *Error {
+	return NewError(412, models.ProblemPreconditionFailed, "The user has changed since the version given in If-Match", err)
+}
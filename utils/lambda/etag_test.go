@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	type test struct {
+		Name            string
+		Headers         map[string]string
+		ExpectedVersion int64
+		IsErrorExpected bool
+	}
+
+	tests := []test{
+		{
+			Name:            "No header",
+			ExpectedVersion: 0,
+		},
+		{
+			Name:            "Wildcard",
+			Headers:         map[string]string{"If-Match": "*"},
+			ExpectedVersion: 0,
+		},
+		{
+			Name:            "Quoted version",
+			Headers:         map[string]string{"If-Match": "\"3\""},
+			ExpectedVersion: 3,
+		},
+		{
+			Name:            "Lowercase header name",
+			Headers:         map[string]string{"if-match": "\"7\""},
+			ExpectedVersion: 7,
+		},
+		{
+			Name:            "Invalid version",
+			Headers:         map[string]string{"If-Match": "\"abc\""},
+			IsErrorExpected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			v, err := IfMatchVersion(events.APIGatewayProxyRequest{Headers: tt.Headers})
+			if tt.IsErrorExpected {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.ExpectedVersion, v)
+		})
+	}
+}
+
+func TestWithETag(t *testing.T) {
+	r := WithETag(RESPONSE_200("{}"), 2)
+	assert.Equal(t, "\"2\"", r.Headers["ETag"])
+	_, ok := Headers["ETag"]
+	assert.False(t, ok, "shared headers should not be modified")
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Header returns the trimmed value of the named request header, ignoring the case of its name
+func Header(request events.APIGatewayProxyRequest, name string) string {
+	for k, v := range request.Headers {
+		if strings.EqualFold(k, name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// NoCache reports whether the request's Cache-Control header asks for a response that wasn't served from a cache
+func NoCache(request events.APIGatewayProxyRequest) bool {
+	for _, directive := range strings.Split(Header(request, "Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-cache", "no-store", "max-age=0":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoCache(t *testing.T) {
+	tests := map[string]bool{
+		"":                    false,
+		"no-cache":            true,
+		"No-Store":            true,
+		"max-age=0":           true,
+		"max-age=60":          false,
+		"private, no-cache":   true,
+		"private, max-age=10": false,
+	}
+
+	for value, expected := range tests {
+		t.Run(value, func(t *testing.T) {
+			request := events.APIGatewayProxyRequest{Headers: map[string]string{"cache-control": value}}
+			assert.Equal(t, expected, NoCache(request))
+		})
+	}
+}
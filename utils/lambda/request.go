@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/pkg/errors"
+)
+
+/*
+DecodeBody decodes the request's JSON body into a T, and checks it against the rules declared in its validate
+tags. A 400 error is returned if the body isn't a single JSON value matching T, including if it has fields T
+doesn't, so that callers find out about misspelt fields rather than having them silently ignored.
+*/
+func DecodeBody[T any](request events.APIGatewayProxyRequest) (T, error) {
+	var body T
+	if strings.TrimSpace(request.Body) == "" {
+		return body, NewError(400, models.ProblemMalformedRequest, "A request body is required", nil)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(request.Body))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&body)
+	if err != nil {
+		return body, decodeError(err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return body, NewError(400, models.ProblemMalformedRequest, "The request body has data after its JSON value", nil)
+	}
+
+	return body, Validate(body)
+}
+
+// decodeError returns the 400 error reported for a body that couldn't be decoded
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		e := ValidationError(models.FieldError{Field: typeErr.Field, Message: fmt.Sprintf("must be a %s", jsonType(typeErr.Type.Kind().String()))})
+		e.Err = err
+		return e
+	}
+
+	// The decoder only reports unknown fields through its error message
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if unquoted, unquoteErr := strconv.Unquote(name); unquoteErr == nil {
+			name = unquoted
+		}
+		e := ValidationError(models.FieldError{Field: name, Message: "is not a known field"})
+		e.Err = err
+		return e
+	}
+
+	return NewError(400, models.ProblemMalformedRequest, "The request body isn't valid JSON", err)
+}
+
+// jsonType names the JSON type that values of the given Go kind are decoded from
+func jsonType(kind string) string {
+	switch {
+	case kind == "string":
+		return "string"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice" || kind == "array":
+		return "list"
+	case kind == "struct" || kind == "map":
+		return "object"
+	case strings.HasPrefix(kind, "int") || strings.HasPrefix(kind, "uint") || strings.HasPrefix(kind, "float"):
+		return "number"
+	}
+	return kind
+}
+
+/*
+PageLimit returns the page size asked for by the limit query parameter, or zero to use the store's default if
+there isn't one. A 400 error is returned if it isn't a number between 1 and userstore.MaxPageSize.
+*/
+func PageLimit(request events.APIGatewayProxyRequest) (int32, error) {
+	l, ok := request.QueryStringParameters["limit"]
+	if !ok {
+		return 0, nil
+	}
+
+	limit, err := strconv.ParseInt(l, 10, 32)
+	if err != nil || limit < 1 || limit > int64(userstore.MaxPageSize) {
+		return 0, ValidationError(models.FieldError{Field: "limit", Message: fmt.Sprintf("must be a number between 1 and %d", userstore.MaxPageSize)})
+	}
+	return int32(limit), nil
+}
+
+// JSON returns a 200 response with v as its JSON body
+func JSON(v any) (events.APIGatewayProxyResponse, error) {
+	var b bytes.Buffer
+	err := json.NewEncoder(&b).Encode(v)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, errors.Wrap(err, "an error ocurred marshaling the response body")
+	}
+	return RESPONSE_200(strings.TrimSuffix(b.String(), "\n")), nil
+}
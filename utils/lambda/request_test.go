@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeBody(t *testing.T) {
+	tests := map[string]struct {
+		body     string
+		expected string
+		fields   []models.FieldError
+	}{
+		"valid":          {body: "{\"email\": \"abc@gmail.com\"}", expected: "abc@gmail.com"},
+		"empty":          {body: ""},
+		"malformed":      {body: "{\"email\": "},
+		"trailing data":  {body: "{\"email\": \"abc@gmail.com\"} {}"},
+		"unknown field":  {body: "{\"email\": \"abc@gmail.com\", \"emial\": \"\"}", fields: []models.FieldError{{Field: "emial", Message: "is not a known field"}}},
+		"wrong type":     {body: "{\"email\": 1}", fields: []models.FieldError{{Field: "email", Message: "must be a string"}}},
+		"failed a rule":  {body: "{\"email\": \"abc\"}", fields: []models.FieldError{{Field: "email", Message: "must be an email address"}}},
+		"missing fields": {body: "{}", fields: []models.FieldError{{Field: "email", Message: "is required"}}},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			body, err := DecodeBody[validateItem](events.APIGatewayProxyRequest{Body: tt.body})
+			if tt.expected != "" {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, body.Email)
+				return
+			}
+
+			var e *Error
+			require.ErrorAs(t, err, &e)
+			assert.Equal(t, 400, e.Status)
+			assert.Equal(t, tt.fields, e.Fields)
+		})
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	limit, err := PageLimit(events.APIGatewayProxyRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), limit)
+
+	limit, err = PageLimit(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"limit": "10"}})
+	require.NoError(t, err)
+	assert.Equal(t, int32(10), limit)
+
+	for _, l := range []string{"0", "101", "ten"} {
+		_, err = PageLimit(events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"limit": l}})
+		assert.Equal(t, 400, ErrorStatus(err), l)
+	}
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/pkg/errors"
+)
+
+const (
+	// TenantHeader names the header callers may name their tenant in, which must be the one their authorizer assigns
+	TenantHeader = "X-Tenant-ID"
+	// TenantPathParameter names the path parameter routes scoped to a tenant name it in, which must likewise be the
+	// caller's
+	TenantPathParameter = "tenant"
+	// TenantAuthorizerKey names the value a custom authorizer sets in its context to assign the caller's tenant
+	TenantAuthorizerKey = "tenantID"
+	// TenantClaim names the Cognito user pool attribute holding the caller's tenant
+	TenantClaim = "custom:tenantID"
+)
+
+var (
+	ErrInvalidTenant   = errors.New("invalid tenant")
+	ErrTenantForbidden = errors.New("tenant forbidden")
+)
+
+/*
+WithTenant returns a context carrying the tenant the request is made on behalf of, which the stores scope
+everything they do to. Only a tenant assigned by the authorizer can't be forged by the caller, so the path and
+header are only trusted to name the tenant the authorizer assigned, and ErrTenantForbidden is returned if they
+name a different one, or name one when the authorizer assigned none. Requests that name no tenant and have none
+assigned belong to the default tenant. ErrInvalidTenant is returned for ids that aren't valid tenant ids, or if
+the path and header disagree.
+*/
+func WithTenant(ctx context.Context, request events.APIGatewayProxyRequest) (context.Context, error) {
+	requested := request.PathParameters[TenantPathParameter]
+	if header := Header(request, TenantHeader); header != "" {
+		if requested != "" && requested != header {
+			return ctx, errors.Wrapf(ErrInvalidTenant, "path names tenant %q but header names %q", requested, header)
+		}
+		requested = header
+	}
+
+	if requested != "" {
+		err := tenant.Validate(requested)
+		if err != nil {
+			return ctx, errors.Wrap(ErrInvalidTenant, err.Error())
+		}
+	}
+
+	assigned, ok := authorizerTenant(request)
+	if !ok {
+		if requested != "" {
+			return ctx, errors.Wrapf(ErrTenantForbidden, "caller has no tenant assigned, so can't act for %q", requested)
+		}
+		return tenant.WithTenant(ctx, tenant.Default), nil
+	}
+	if requested != "" && requested != assigned {
+		return ctx, errors.Wrapf(ErrTenantForbidden, "caller belongs to tenant %q, not %q", assigned, requested)
+	}
+
+	err := tenant.Validate(assigned)
+	if err != nil {
+		return ctx, errors.Wrap(ErrInvalidTenant, err.Error())
+	}
+	return tenant.WithTenant(ctx, assigned), nil
+}
+
+// authorizerTenant returns the tenant the request's authorizer assigned the caller to, if it assigned one
+func authorizerTenant(request events.APIGatewayProxyRequest) (string, bool) {
+	authorizer := request.RequestContext.Authorizer
+	if claims, ok := authorizer["claims"].(map[string]interface{}); ok {
+		if id, ok := claims[TenantClaim]; ok {
+			return fmt.Sprint(id), true
+		}
+	}
+	if id, ok := authorizer[TenantAuthorizerKey]; ok {
+		return fmt.Sprint(id), true
+	}
+	return "", false
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTenant(t *testing.T) {
+	tests := map[string]struct {
+		request  events.APIGatewayProxyRequest
+		expected string
+		err      error
+	}{
+		"default": {},
+		"header": {
+			request: events.APIGatewayProxyRequest{
+				Headers:        map[string]string{"x-tenant-id": "acme"},
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantID": "acme"}},
+			},
+			expected: "acme",
+		},
+		"path": {
+			request: events.APIGatewayProxyRequest{
+				PathParameters: map[string]string{"tenant": "acme"},
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantID": "acme"}},
+			},
+			expected: "acme",
+		},
+		"header without an authorizer": {
+			request: events.APIGatewayProxyRequest{Headers: map[string]string{"X-Tenant-ID": "acme"}},
+			err:     ErrTenantForbidden,
+		},
+		"path without an authorizer": {
+			request: events.APIGatewayProxyRequest{PathParameters: map[string]string{"tenant": "acme"}},
+			err:     ErrTenantForbidden,
+		},
+		"path and header disagree": {
+			request: events.APIGatewayProxyRequest{
+				Headers:        map[string]string{"X-Tenant-ID": "globex"},
+				PathParameters: map[string]string{"tenant": "acme"},
+			},
+			err: ErrInvalidTenant,
+		},
+		"invalid": {
+			request: events.APIGatewayProxyRequest{Headers: map[string]string{"X-Tenant-ID": "acme/user"}},
+			err:     ErrInvalidTenant,
+		},
+		"custom authorizer": {
+			request: events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantID": "acme"}},
+			},
+			expected: "acme",
+		},
+		"cognito user pool": {
+			request: events.APIGatewayProxyRequest{
+				Headers: map[string]string{"X-Tenant-ID": "acme"},
+				RequestContext: events.APIGatewayProxyRequestContext{
+					Authorizer: map[string]interface{}{"claims": map[string]interface{}{"custom:tenantID": "acme"}},
+				},
+			},
+			expected: "acme",
+		},
+		"another tenant than the authorizer's": {
+			request: events.APIGatewayProxyRequest{
+				Headers: map[string]string{"X-Tenant-ID": "globex"},
+				RequestContext: events.APIGatewayProxyRequestContext{
+					Authorizer: map[string]interface{}{"tenantID": "acme"},
+				},
+			},
+			err: ErrTenantForbidden,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, err := WithTenant(context.Background(), tt.request)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, tenant.FromContext(ctx))
+		})
+	}
+}
@@ -1,23 +1,17 @@
 package utils
 
-import "github.com/aws/aws-lambda-go/events"
+import (
+	"github.com/aws/aws-lambda-go/events"
+)
 
-var Headers = map[string]string{
-	"Access-Control-Allow-Headers": "Content-Type",
-	"Access-Control-Allow-Origin":  "*",
-	"Access-Control-Allow-Methods": "OPTIONS,POST,GET",
-}
+// RetryAfterSeconds is how long callers are asked to wait before retrying a request that got a 503
+const RetryAfterSeconds = 1
 
-var RESPONSE_500 = events.APIGatewayProxyResponse{
-	StatusCode: 500,
-	Headers:    Headers,
-	Body:       "{\"message\": \"Something went wrong!\"}",
-}
-
-var RESPONSE_400 = events.APIGatewayProxyResponse{
-	StatusCode: 400,
-	Headers:    Headers,
-	Body:       "{\"message\": \"Invalid request\"}",
+var Headers = map[string]string{
+	"Access-Control-Allow-Headers":  "Content-Type,If-Match,Cache-Control,X-Tenant-ID",
+	"Access-Control-Allow-Origin":   "*",
+	"Access-Control-Allow-Methods":  "OPTIONS,POST,GET,PATCH,DELETE",
+	"Access-Control-Expose-Headers": "ETag,Retry-After,Deprecation",
 }
 
 func RESPONSE_200(body string) events.APIGatewayProxyResponse {
@@ -27,3 +21,19 @@ func RESPONSE_200(body string) events.APIGatewayProxyResponse {
 		Body:       body,
 	}
 }
+
+// WithHeader returns a copy of the response with the given header set
+func WithHeader(response events.APIGatewayProxyResponse, name string, value string) events.APIGatewayProxyResponse {
+	response.Headers = withHeader(response.Headers, name, value)
+	return response
+}
+
+// withHeader returns a copy of the headers with the given header set
+func withHeader(headers map[string]string, name string, value string) map[string]string {
+	h := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		h[k] = v
+	}
+	h[name] = value
+	return h
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/google/uuid"
+)
+
+/*
+Validate checks v against the rules declared in its fields' validate tags, returning a validation error that
+describes every field breaking them. Rules are separated by commas:
+
+  - required: the field must be set, and strings, slices and maps must not be empty
+  - min=n, max=n: bound the length of strings, slices and maps, or the value of numbers
+  - email: the field must be a bare email address
+  - uuid: the field must be a UUID in its canonical form, like the ids the API generates, unless it's empty
+
+Rules other than required are skipped for nil pointers, so optional fields can be pointers. Fields are named by
+their JSON names, and structs, including embedded ones, and slices of structs are checked field by field.
+*/
+func Validate(v any) error {
+	fields := validateValue(reflect.ValueOf(v), "")
+	if len(fields) > 0 {
+		return ValidationError(fields...)
+	}
+	return nil
+}
+
+func validateValue(v reflect.Value, path string) []models.FieldError {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	fields := []models.FieldError{}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous && f.Tag.Get("json") == "" {
+				// Embedded structs' fields are decoded as if they were the outer struct's
+				fields = append(fields, validateValue(v.Field(i), path)...)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			name := jsonName(f)
+			if name == "-" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			fields = append(fields, validateField(v.Field(i), name, f.Tag.Get("validate"))...)
+			fields = append(fields, validateValue(v.Field(i), name)...)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fields = append(fields, validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return fields
+}
+
+// validateField checks a single field against the rules in its tag, returning the first rule it breaks
+func validateField(v reflect.Value, name string, tag string) []models.FieldError {
+	if tag == "" {
+		return nil
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		rule, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if rule == "required" {
+			if isEmpty(v) {
+				return []models.FieldError{{Field: name, Message: "is required"}}
+			}
+			continue
+		}
+
+		for v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+
+		var message string
+		switch rule {
+		case "min", "max":
+			message = checkBound(v, rule, arg)
+		case "email":
+			if address, err := mail.ParseAddress(v.String()); err != nil || address.Name != "" || address.Address != strings.TrimSpace(v.String()) {
+				message = "must be an email address"
+			}
+		case "uuid":
+			if s := v.String(); s != "" {
+				if _, err := uuid.Parse(s); err != nil || len(s) != 36 {
+					message = "must be a UUID"
+				}
+			}
+		default:
+			panic(fmt.Sprintf("unknown validation rule %q on %s", rule, name))
+		}
+		if message != "" {
+			return []models.FieldError{{Field: name, Message: message}}
+		}
+	}
+	return nil
+}
+
+// checkBound returns why the value breaks the min or max rule, if it does
+func checkBound(v reflect.Value, rule string, arg string) string {
+	bound, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid bound %q for validation rule %s", arg, rule))
+	}
+
+	var n float64
+	unit := ""
+	switch v.Kind() {
+	case reflect.String:
+		n, unit = float64(len([]rune(v.String()))), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n, unit = float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	default:
+		panic(fmt.Sprintf("validation rule %s can't be applied to a %s", rule, v.Kind()))
+	}
+
+	if rule == "min" && n < bound {
+		return fmt.Sprintf("must be at least %s%s", arg, unit)
+	}
+	if rule == "max" && n > bound {
+		return fmt.Sprintf("must be at most %s%s", arg, unit)
+	}
+	return ""
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// jsonName returns the name the field is given in JSON
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/benjaminkitson/bk-user-api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type validateItem struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type validateEmbedded struct {
+	Name string `json:"name" validate:"max=3"`
+}
+
+type validateRequest struct {
+	validateEmbedded
+	ID       string         `json:"id" validate:"required,max=5"`
+	Nickname *string        `json:"nickname,omitempty" validate:"min=2"`
+	Count    int            `json:"count" validate:"max=10"`
+	Ref      string         `json:"ref" validate:"uuid"`
+	Items    []validateItem `json:"items" validate:"required,max=2"`
+}
+
+func TestValidate(t *testing.T) {
+	short := "a"
+	valid := validateRequest{ID: "12345", Items: []validateItem{{Email: "abc@gmail.com"}}}
+
+	tests := map[string]struct {
+		request  func(r *validateRequest)
+		expected []models.FieldError
+	}{
+		"valid": {request: func(r *validateRequest) {}},
+		"missing required field": {
+			request:  func(r *validateRequest) { r.ID = "" },
+			expected: []models.FieldError{{Field: "id", Message: "is required"}},
+		},
+		"too long": {
+			request:  func(r *validateRequest) { r.ID = "123456" },
+			expected: []models.FieldError{{Field: "id", Message: "must be at most 5 characters"}},
+		},
+		"optional field set": {
+			request:  func(r *validateRequest) { r.Nickname = &short },
+			expected: []models.FieldError{{Field: "nickname", Message: "must be at least 2 characters"}},
+		},
+		"embedded field": {
+			request:  func(r *validateRequest) { r.Name = "abcd" },
+			expected: []models.FieldError{{Field: "name", Message: "must be at most 3 characters"}},
+		},
+		"number too large": {
+			request:  func(r *validateRequest) { r.Count = 11 },
+			expected: []models.FieldError{{Field: "count", Message: "must be at most 10"}},
+		},
+		"uuid": {
+			request: func(r *validateRequest) { r.Ref = "0b9f8a8e-6a8c-4d4e-9d1c-3b3f5f0a2c11" },
+		},
+		"not a uuid": {
+			request:  func(r *validateRequest) { r.Ref = "../12345" },
+			expected: []models.FieldError{{Field: "ref", Message: "must be a UUID"}},
+		},
+		"uuid in another form": {
+			request:  func(r *validateRequest) { r.Ref = "urn:uuid:0b9f8a8e-6a8c-4d4e-9d1c-3b3f5f0a2c11" },
+			expected: []models.FieldError{{Field: "ref", Message: "must be a UUID"}},
+		},
+		"empty list": {
+			request:  func(r *validateRequest) { r.Items = nil },
+			expected: []models.FieldError{{Field: "items", Message: "is required"}},
+		},
+		"invalid list item": {
+			request: func(r *validateRequest) {
+				r.Items = []validateItem{{Email: "abc@gmail.com"}, {Email: "Abc <abc@gmail.com>"}}
+			},
+			expected: []models.FieldError{{Field: "items[1].email", Message: "must be an email address"}},
+		},
+		"every invalid field": {
+			request: func(r *validateRequest) {
+				r.ID = ""
+				r.Items = []validateItem{{}, {}, {}}
+			},
+			expected: []models.FieldError{
+				{Field: "id", Message: "is required"},
+				{Field: "items", Message: "must be at most 2 items"},
+				{Field: "items[0].email", Message: "is required"},
+				{Field: "items[1].email", Message: "is required"},
+				{Field: "items[2].email", Message: "is required"},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := valid
+			tt.request(&r)
+
+			err := Validate(r)
+			if tt.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var e *Error
+			require.ErrorAs(t, err, &e)
+			assert.Equal(t, 400, e.Status)
+			assert.Equal(t, tt.expected, e.Fields)
+		})
+	}
+}
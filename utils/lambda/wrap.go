@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.uber.org/zap"
+)
+
+// HandlerFunc handles an API Gateway request, returning an error for any request it can't respond to successfully
+type HandlerFunc func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+/*
+Wrap adapts a HandlerFunc into a Lambda handler. The request's tenant and audit details are added to the context
+before fn is called, and any error fn returns, or panic it raises, is logged and turned into the matching problem
+response with ErrorResponse. The wrapped handler never returns an error itself, as Lambda would have API Gateway
+respond with a 502 rather than the response describing what went wrong.
+*/
+func Wrap(logger *zap.Logger, fn HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, request events.APIGatewayProxyRequest) (response events.APIGatewayProxyResponse, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("handler panicked", zap.Any("panic", r), zap.Stack("stack"))
+				response, err = ErrorResponse(request, fmt.Errorf("handler panicked: %v", r)), nil
+			}
+		}()
+
+		ctx, err = WithTenant(ctx, request)
+		if err == nil {
+			ctx = WithAudit(ctx, request)
+			response, err = fn(ctx, request)
+		}
+		if err == nil {
+			return response, nil
+		}
+
+		response = ErrorResponse(request, err)
+		if response.StatusCode >= 500 {
+			logger.Error("request failed", zap.Int("status", response.StatusCode), zap.Error(err))
+		} else {
+			logger.Info("request rejected", zap.Int("status", response.StatusCode), zap.Error(err))
+		}
+		return response, nil
+	}
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/benjaminkitson/bk-user-api/db/userstore"
+	"github.com/benjaminkitson/bk-user-api/tenant"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestWrap(t *testing.T) {
+	tests := map[string]struct {
+		request  events.APIGatewayProxyRequest
+		fn       HandlerFunc
+		expected int
+	}{
+		"success": {
+			fn: func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+				return RESPONSE_200("{}"), nil
+			},
+			expected: 200,
+		},
+		"tenant added to the context": {
+			request: events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{"tenantID": "acme"}},
+			},
+			fn: func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+				if tenant.FromContext(ctx) != "acme" {
+					return events.APIGatewayProxyResponse{}, errors.New("wrong tenant")
+				}
+				return RESPONSE_200("{}"), nil
+			},
+			expected: 200,
+		},
+		"tenant rejected": {
+			request: events.APIGatewayProxyRequest{Headers: map[string]string{"X-Tenant-ID": "acme/user"}},
+			fn: func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+				return RESPONSE_200("{}"), nil
+			},
+			expected: 400,
+		},
+		"tenant not assigned": {
+			request: events.APIGatewayProxyRequest{Headers: map[string]string{"X-Tenant-ID": "acme"}},
+			fn: func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+				return RESPONSE_200("{}"), nil
+			},
+			expected: 403,
+		},
+		"store error": {
+			fn: func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+				return events.APIGatewayProxyResponse{}, errors.Wrap(userstore.ErrNotFound, "get")
+			},
+			expected: 404,
+		},
+		"handler error": {
+			fn: func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+				return events.APIGatewayProxyResponse{}, PreconditionFailed(userstore.ErrConflict)
+			},
+			expected: 412,
+		},
+		"panic": {
+			fn: func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+				panic("oops")
+			},
+			expected: 500,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := Wrap(zap.NewNop(), tt.fn)(context.Background(), tt.request)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, r.StatusCode)
+		})
+	}
+}